@@ -13,21 +13,61 @@ import (
 	"net/http"
 	"net/url"
 	"os"
+	"strings"
+	"time"
 
-	"github.com/gorilla/sessions"
 	verifier "github.com/okta/okta-jwt-verifier-golang"
 	oktaUtils "github.com/okta/samples-golang/custom-login/utils"
+	"github.com/okta/samples-golang/discovery"
+	"github.com/okta/samples-golang/utils/config"
+	"github.com/okta/samples-golang/utils/session"
 )
 
+// defaults are the settings used when neither the config file, the
+// environment nor the flags set them.
+var defaults = config.Config{
+	ListenAddr:   "localhost:8080",
+	BaseURL:      "http://localhost:8080",
+	RedirectPath: "/authorization-code/callback",
+	// scopes requested by the widget, offline_access gets us a refresh token.
+	Scopes: []string{"openid", "profile", "email", "offline_access"},
+	Cookie: config.Cookie{
+		Name:     "okta-custom-login-session-store",
+		Path:     "/",
+		MaxAge:   12 * 60 * 60,
+		SameSite: "lax",
+	},
+	// Server-side sessions keep the tokens out of the cookie, which they
+	// would easily grow past the 4KB browsers accept.
+	Session: config.Session{
+		Store:           "memory",
+		IdleTimeout:     30 * time.Minute,
+		AbsoluteTimeout: 12 * time.Hour,
+	},
+}
+
 var (
-	tpl          *template.Template
-	sessionStore = sessions.NewCookieStore([]byte("okta-custom-login-session-store"))
-	state        = generateState()
-	nonce        = "NonceNotSetYet"
+	state = generateState()
+	nonce = "NonceNotSetYet"
 )
 
-func init() {
-	tpl = template.Must(template.ParseGlob("templates/*"))
+type Server struct {
+	config       *config.Config
+	tpl          *template.Template
+	sessionStore *session.Store
+}
+
+func NewServer(c *config.Config) (*Server, error) {
+	sessionStore, err := session.NewStore(c)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Server{
+		config:       c,
+		tpl:          template.Must(template.ParseGlob("templates/*")),
+		sessionStore: sessionStore,
+	}, nil
 }
 
 func generateState() string {
@@ -38,36 +78,49 @@ func generateState() string {
 }
 
 func main() {
-	oktaUtils.ParseEnvironment()
+	cfg, err := config.Load(defaults, os.Args[1:])
+	if err == nil {
+		err = cfg.Validate("CLIENT_ID", "CLIENT_SECRET", "ISSUER", "SESSION_SECRET")
+	}
+	if err != nil {
+		log.Print(err)
+		os.Exit(1)
+	}
 
-	http.HandleFunc("/", HomeHandler)
-	http.HandleFunc("/login", LoginHandler)
-	http.HandleFunc("/authorization-code/callback", AuthCodeCallbackHandler)
-	http.HandleFunc("/profile", ProfileHandler)
-	http.HandleFunc("/logout", LogoutHandler)
+	s, err := NewServer(cfg)
+	if err != nil {
+		log.Printf("could not create the session store: %s", err)
+		os.Exit(1)
+	}
 
-	log.Print("server starting at localhost:8080 ... ")
-	err := http.ListenAndServe("localhost:8080", nil)
+	http.HandleFunc("/", s.HomeHandler)
+	http.HandleFunc("/login", s.LoginHandler)
+	http.HandleFunc(cfg.RedirectPath, s.AuthCodeCallbackHandler)
+	http.HandleFunc("/profile", s.ProfileHandler)
+	http.HandleFunc("/logout", s.LogoutHandler)
+
+	log.Printf("server starting at %s ... ", cfg.ListenAddr)
+	err = http.ListenAndServe(cfg.ListenAddr, s.refreshTokensMiddleware(http.DefaultServeMux))
 	if err != nil {
 		log.Printf("the HTTP server failed to start: %s", err)
 		os.Exit(1)
 	}
 }
 
-func HomeHandler(w http.ResponseWriter, r *http.Request) {
+func (s *Server) HomeHandler(w http.ResponseWriter, r *http.Request) {
 	type customData struct {
 		Profile         map[string]string
 		IsAuthenticated bool
 	}
 
 	data := customData{
-		Profile:         getProfileData(r),
-		IsAuthenticated: isAuthenticated(r),
+		Profile:         s.getProfileData(r),
+		IsAuthenticated: s.isAuthenticated(r),
 	}
-	tpl.ExecuteTemplate(w, "home.gohtml", data)
+	s.tpl.ExecuteTemplate(w, "home.gohtml", data)
 }
 
-func LoginHandler(w http.ResponseWriter, r *http.Request) {
+func (s *Server) LoginHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Add("Cache-Control", "no-cache") // See https://github.com/okta/samples-golang/issues/20
 
 	nonce, _ = oktaUtils.GenerateNonce()
@@ -79,24 +132,28 @@ func LoginHandler(w http.ResponseWriter, r *http.Request) {
 		Issuer          string
 		State           string
 		Nonce           string
+		Scopes          []string
+		RedirectURI     string
 	}
 
-	issuerParts, _ := url.Parse(os.Getenv("ISSUER"))
+	issuerParts, _ := url.Parse(s.config.Issuer)
 	baseUrl := issuerParts.Scheme + "://" + issuerParts.Hostname()
 
 	data := customData{
-		Profile:         getProfileData(r),
-		IsAuthenticated: isAuthenticated(r),
+		Profile:         s.getProfileData(r),
+		IsAuthenticated: s.isAuthenticated(r),
 		BaseUrl:         baseUrl,
-		ClientId:        os.Getenv("CLIENT_ID"),
-		Issuer:          os.Getenv("ISSUER"),
+		ClientId:        s.config.ClientID,
+		Issuer:          s.config.Issuer,
 		State:           state,
 		Nonce:           nonce,
+		Scopes:          s.config.Scopes,
+		RedirectURI:     s.config.RedirectURI(),
 	}
-	tpl.ExecuteTemplate(w, "login.gohtml", data)
+	s.tpl.ExecuteTemplate(w, "login.gohtml", data)
 }
 
-func AuthCodeCallbackHandler(w http.ResponseWriter, r *http.Request) {
+func (s *Server) AuthCodeCallbackHandler(w http.ResponseWriter, r *http.Request) {
 	// Check the state that was returned in the query string is the same as the above state
 	if r.URL.Query().Get("state") != state {
 		fmt.Fprintln(w, "The state was not as expected")
@@ -108,7 +165,7 @@ func AuthCodeCallbackHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	exchange := exchangeCode(r.URL.Query().Get("code"), r)
+	exchange := s.exchangeCode(r.URL.Query().Get("code"), r)
 
 	if exchange.Error != "" {
 		fmt.Println(exchange.Error)
@@ -116,12 +173,13 @@ func AuthCodeCallbackHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	session, err := sessionStore.Get(r, "okta-custom-login-session-store")
+	session, err := s.sessionStore.Get(r, s.config.Cookie.Name)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
 
-	_, verificationError := verifyToken(exchange.IdToken)
+	_, verificationError := s.verifyToken(exchange.IdToken)
 
 	if verificationError != nil {
 		fmt.Println(verificationError)
@@ -130,52 +188,130 @@ func AuthCodeCallbackHandler(w http.ResponseWriter, r *http.Request) {
 	if verificationError == nil {
 		session.Values["id_token"] = exchange.IdToken
 		session.Values["access_token"] = exchange.AccessToken
-
-		session.Save(r, w)
+		session.Values["refresh_token"] = exchange.RefreshToken
+		session.Values["expires_at"] = expiresAt(exchange.ExpiresIn)
+
+		// A new session ID, so one planted before the login is of no use
+		if err := s.sessionStore.Renew(r, w, session); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
 	}
 
 	http.Redirect(w, r, "/", http.StatusFound)
 }
 
-func ProfileHandler(w http.ResponseWriter, r *http.Request) {
+func (s *Server) ProfileHandler(w http.ResponseWriter, r *http.Request) {
 	type customData struct {
 		Profile         map[string]string
 		IsAuthenticated bool
 	}
 
 	data := customData{
-		Profile:         getProfileData(r),
-		IsAuthenticated: isAuthenticated(r),
+		Profile:         s.getProfileData(r),
+		IsAuthenticated: s.isAuthenticated(r),
 	}
-	tpl.ExecuteTemplate(w, "profile.gohtml", data)
+	s.tpl.ExecuteTemplate(w, "profile.gohtml", data)
 }
 
-func LogoutHandler(w http.ResponseWriter, r *http.Request) {
-	session, err := sessionStore.Get(r, "okta-custom-login-session-store")
+// LogoutHandler revokes the session's tokens, clears them from the cookie
+// and ends the Okta session through the issuer's logout endpoint, which sends
+// the browser on to POST_LOGOUT_REDIRECT_URI.
+func (s *Server) LogoutHandler(w http.ResponseWriter, r *http.Request) {
+	session, err := s.sessionStore.Get(r, s.config.Cookie.Name)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	idToken, _ := session.Values["id_token"].(string)
+	if refreshToken, ok := session.Values["refresh_token"].(string); ok && refreshToken != "" {
+		if err := s.revokeToken(r, refreshToken, "refresh_token"); err != nil {
+			log.Printf("revoke error: %s", err)
+		}
+	}
+	if accessToken, ok := session.Values["access_token"].(string); ok && accessToken != "" {
+		if err := s.revokeToken(r, accessToken, "access_token"); err != nil {
+			log.Printf("revoke error: %s", err)
+		}
 	}
 
 	delete(session.Values, "id_token")
 	delete(session.Values, "access_token")
-
+	delete(session.Values, "refresh_token")
+	delete(session.Values, "expires_at")
 	session.Save(r, w)
 
-	http.Redirect(w, r, "/", http.StatusFound)
+	if idToken == "" {
+		http.Redirect(w, r, "/", http.StatusFound)
+		return
+	}
+
+	// post_logout_redirect_uri must match one of the "Sign-out redirect URIs"
+	// defined on the Okta application
+	q := url.Values{}
+	q.Add("id_token_hint", idToken)
+	q.Add("post_logout_redirect_uri", s.config.PostLogoutRedirectURI)
+
+	oidc, err := discovery.Get(r.Context(), s.config.Issuer)
+	if err != nil {
+		log.Printf("could not end the Okta session: %s", err)
+		http.Redirect(w, r, "/", http.StatusFound)
+		return
+	}
+
+	http.Redirect(w, r, oidc.EndSessionEndpoint+"?"+q.Encode(), http.StatusFound)
 }
 
-func exchangeCode(code string, r *http.Request) Exchange {
+func (s *Server) revokeToken(r *http.Request, token string, tokenTypeHint string) error {
+	oidc, err := discovery.Get(r.Context(), s.config.Issuer)
+	if err != nil {
+		return err
+	}
+
 	authHeader := base64.StdEncoding.EncodeToString(
-		[]byte(os.Getenv("CLIENT_ID") + ":" + os.Getenv("CLIENT_SECRET")))
+		[]byte(s.config.ClientID + ":" + s.config.ClientSecret))
+
+	form := url.Values{}
+	form.Add("token", token)
+	form.Add("token_type_hint", tokenTypeHint)
+
+	req, _ := http.NewRequest("POST", oidc.RevocationEndpoint, strings.NewReader(form.Encode()))
+	h := req.Header
+	h.Add("Authorization", "Basic "+authHeader)
+	h.Add("Accept", "application/json")
+	h.Add("Content-Type", "application/x-www-form-urlencoded")
+
+	client := &http.Client{}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("revoking %s failed with status %s", tokenTypeHint, resp.Status)
+	}
+	return nil
+}
+
+func (s *Server) exchangeCode(code string, r *http.Request) Exchange {
+	oidc, err := discovery.Get(r.Context(), s.config.Issuer)
+	if err != nil {
+		return Exchange{Error: "discovery_failed", ErrorDescription: err.Error()}
+	}
+
+	authHeader := base64.StdEncoding.EncodeToString(
+		[]byte(s.config.ClientID + ":" + s.config.ClientSecret))
 
 	q := r.URL.Query()
 	q.Add("grant_type", "authorization_code")
 	q.Set("code", code)
-	q.Add("redirect_uri", "http://localhost:8080/authorization-code/callback")
+	q.Add("redirect_uri", s.config.RedirectURI())
 
-	url := os.Getenv("ISSUER") + "/v1/token?" + q.Encode()
+	tokenUrl := oidc.TokenEndpoint + "?" + q.Encode()
 
-	req, _ := http.NewRequest("POST", url, bytes.NewReader([]byte("")))
+	req, _ := http.NewRequest("POST", tokenUrl, bytes.NewReader([]byte("")))
 	h := req.Header
 	h.Add("Authorization", "Basic "+authHeader)
 	h.Add("Accept", "application/json")
@@ -184,17 +320,123 @@ func exchangeCode(code string, r *http.Request) Exchange {
 	h.Add("Content-Length", "0")
 
 	client := &http.Client{}
-	resp, _ := client.Do(req)
+	resp, err := client.Do(req)
+	if err != nil {
+		return Exchange{Error: "request_failed", ErrorDescription: err.Error()}
+	}
+	defer resp.Body.Close()
 	body, _ := ioutil.ReadAll(resp.Body)
+	var exchange Exchange
+	json.Unmarshal(body, &exchange)
+
+	return exchange
+}
+
+// refreshWindow is how long before the access token expires that
+// refreshTokensMiddleware renews it.
+const refreshWindow = 60 * time.Second
+
+func expiresAt(expiresIn int) int64 {
+	return time.Now().Add(time.Duration(expiresIn) * time.Second).Unix()
+}
+
+// refreshTokensMiddleware renews the session's access token with its refresh
+// token shortly before it expires. If the refresh fails the tokens are removed
+// from the session, logging the user out, and they are sent back home.
+func (s *Server) refreshTokensMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/logout" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		session, err := s.sessionStore.Get(r, s.config.Cookie.Name)
+		if err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// Keep sessions in use from reaching the idle timeout
+		if err := s.sessionStore.Touch(r, w, session); err != nil {
+			log.Printf("could not save the session: %s", err)
+		}
+
+		expires, ok := session.Values["expires_at"].(int64)
+		if !ok || time.Until(time.Unix(expires, 0)) > refreshWindow {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		refreshToken, _ := session.Values["refresh_token"].(string)
+		var exchange Exchange
+		if refreshToken != "" {
+			exchange = s.refreshTokens(r, refreshToken)
+		}
+
+		if refreshToken == "" || exchange.Error != "" || exchange.AccessToken == "" {
+			log.Printf("could not refresh the access token: %s %s", exchange.Error, exchange.ErrorDescription)
+			for _, k := range []string{"id_token", "access_token", "refresh_token", "expires_at"} {
+				delete(session.Values, k)
+			}
+			session.Save(r, w)
+			if r.URL.Path != "/" {
+				http.Redirect(w, r, "/", http.StatusFound)
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		session.Values["access_token"] = exchange.AccessToken
+		session.Values["expires_at"] = expiresAt(exchange.ExpiresIn)
+		if exchange.IdToken != "" {
+			session.Values["id_token"] = exchange.IdToken
+		}
+		// Refresh tokens may be rotated on every use
+		if exchange.RefreshToken != "" {
+			session.Values["refresh_token"] = exchange.RefreshToken
+		}
+		session.Save(r, w)
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *Server) refreshTokens(r *http.Request, refreshToken string) Exchange {
+	oidc, err := discovery.Get(r.Context(), s.config.Issuer)
+	if err != nil {
+		return Exchange{Error: "discovery_failed", ErrorDescription: err.Error()}
+	}
+
+	authHeader := base64.StdEncoding.EncodeToString(
+		[]byte(s.config.ClientID + ":" + s.config.ClientSecret))
+
+	form := url.Values{}
+	form.Add("grant_type", "refresh_token")
+	form.Add("refresh_token", refreshToken)
+	form.Add("scope", strings.Join(s.config.Scopes, " "))
+
+	req, _ := http.NewRequest("POST", oidc.TokenEndpoint, strings.NewReader(form.Encode()))
+	h := req.Header
+	h.Add("Authorization", "Basic "+authHeader)
+	h.Add("Accept", "application/json")
+	h.Add("Content-Type", "application/x-www-form-urlencoded")
+
+	client := &http.Client{}
+	resp, err := client.Do(req)
+	if err != nil {
+		return Exchange{Error: "request_failed", ErrorDescription: err.Error()}
+	}
 	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
 	var exchange Exchange
 	json.Unmarshal(body, &exchange)
 
 	return exchange
 }
 
-func isAuthenticated(r *http.Request) bool {
-	session, err := sessionStore.Get(r, "okta-custom-login-session-store")
+func (s *Server) isAuthenticated(r *http.Request) bool {
+	session, err := s.sessionStore.Get(r, s.config.Cookie.Name)
 
 	if err != nil || session.Values["id_token"] == nil || session.Values["id_token"] == "" {
 		return false
@@ -203,37 +445,45 @@ func isAuthenticated(r *http.Request) bool {
 	return true
 }
 
-func getProfileData(r *http.Request) map[string]string {
+func (s *Server) getProfileData(r *http.Request) map[string]string {
 	m := make(map[string]string)
 
-	session, err := sessionStore.Get(r, "okta-custom-login-session-store")
+	session, err := s.sessionStore.Get(r, s.config.Cookie.Name)
 
 	if err != nil || session.Values["access_token"] == nil || session.Values["access_token"] == "" {
 		return m
 	}
 
-	reqUrl := os.Getenv("ISSUER") + "/v1/userinfo"
+	oidc, err := discovery.Get(r.Context(), s.config.Issuer)
+	if err != nil {
+		log.Printf("could not get the userinfo endpoint: %s", err)
+		return m
+	}
 
-	req, _ := http.NewRequest("GET", reqUrl, bytes.NewReader([]byte("")))
+	req, _ := http.NewRequest("GET", oidc.UserinfoEndpoint, bytes.NewReader([]byte("")))
 	h := req.Header
 	h.Add("Authorization", "Bearer "+session.Values["access_token"].(string))
 	h.Add("Accept", "application/json")
 
 	client := &http.Client{}
-	resp, _ := client.Do(req)
-	body, _ := ioutil.ReadAll(resp.Body)
+	resp, err := client.Do(req)
+	if err != nil {
+		log.Printf("could not get the profile: %s", err)
+		return m
+	}
 	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
 	json.Unmarshal(body, &m)
 
 	return m
 }
 
-func verifyToken(t string) (*verifier.Jwt, error) {
+func (s *Server) verifyToken(t string) (*verifier.Jwt, error) {
 	tv := map[string]string{}
 	tv["nonce"] = nonce
-	tv["aud"] = os.Getenv("CLIENT_ID")
+	tv["aud"] = s.config.ClientID
 	jv := verifier.JwtVerifier{
-		Issuer:           os.Getenv("ISSUER"),
+		Issuer:           s.config.Issuer,
 		ClaimsToValidate: tv,
 	}
 
@@ -257,4 +507,5 @@ type Exchange struct {
 	ExpiresIn        int    `json:"expires_in,omitempty"`
 	Scope            string `json:"scope,omitempty"`
 	IdToken          string `json:"id_token,omitempty"`
+	RefreshToken     string `json:"refresh_token,omitempty"`
 }
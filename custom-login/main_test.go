@@ -0,0 +1,365 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeIssuer is an Okta authorization server that records the requests made
+// to its endpoints, answers token requests with tokenResponse and revocation
+// requests with revokeStatus.
+type fakeIssuer struct {
+	*httptest.Server
+	issuer string
+
+	mu            sync.Mutex
+	requests      map[string][]url.Values
+	tokenResponse map[string]interface{}
+	revokeStatus  int
+}
+
+func newFakeIssuer(t *testing.T) *fakeIssuer {
+	t.Helper()
+	f := &fakeIssuer{requests: map[string][]url.Values{}, revokeStatus: http.StatusOK}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/oauth2/default/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":               f.issuer,
+			"token_endpoint":       f.issuer + "/v1/token",
+			"revocation_endpoint":  f.issuer + "/v1/revoke",
+			"end_session_endpoint": f.issuer + "/v1/logout",
+		})
+	})
+	mux.HandleFunc("/oauth2/default/v1/token", func(w http.ResponseWriter, r *http.Request) {
+		f.record(r)
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		if f.tokenResponse == nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		json.NewEncoder(w).Encode(f.tokenResponse)
+	})
+	mux.HandleFunc("/oauth2/default/v1/revoke", func(w http.ResponseWriter, r *http.Request) {
+		f.record(r)
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		w.WriteHeader(f.revokeStatus)
+	})
+	f.Server = httptest.NewServer(mux)
+	f.issuer = f.URL + "/oauth2/default"
+	t.Cleanup(f.Close)
+	return f
+}
+
+// record keeps the query and form of a request made to the issuer, with the
+// client it authenticated as.
+func (f *fakeIssuer) record(r *http.Request) {
+	r.ParseForm()
+	values := r.Form
+	if id, secret, ok := r.BasicAuth(); ok {
+		values.Set("basic_auth", id+":"+secret)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.requests[r.URL.Path] = append(f.requests[r.URL.Path], values)
+}
+
+func (f *fakeIssuer) requestsTo(endpoint string) []url.Values {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.requests["/oauth2/default/v1/"+endpoint]
+}
+
+func newTestServer(t *testing.T, issuer *fakeIssuer) *Server {
+	t.Helper()
+	c := defaults
+	c.ClientID = "client-id"
+	c.ClientSecret = "client-secret"
+	c.Issuer = issuer.issuer
+	c.SessionSecret = "session-secret"
+	c.PostLogoutRedirectURI = "http://localhost:8080/"
+	s, err := NewServer(&c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// browser keeps the cookies the server gave it, like a browser does.
+type browser struct {
+	cookies map[string]*http.Cookie
+}
+
+func newBrowser() *browser {
+	return &browser{cookies: map[string]*http.Cookie{}}
+}
+
+func (b *browser) do(handler http.Handler, path string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodGet, path, nil)
+	for _, c := range b.cookies {
+		r.AddCookie(c)
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	for _, c := range w.Result().Cookies() {
+		b.cookies[c.Name] = c
+	}
+	return w
+}
+
+// session returns the values of the browser's session.
+func (b *browser) session(t *testing.T, s *Server) map[interface{}]interface{} {
+	t.Helper()
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	for _, c := range b.cookies {
+		r.AddCookie(c)
+	}
+	session, err := s.sessionStore.Get(r, s.config.Cookie.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return session.Values
+}
+
+// setSession saves values into the browser's session.
+func (b *browser) setSession(t *testing.T, s *Server, values map[string]interface{}) {
+	t.Helper()
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	for _, c := range b.cookies {
+		r.AddCookie(c)
+	}
+	session, err := s.sessionStore.Get(r, s.config.Cookie.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k, v := range values {
+		session.Values[k] = v
+	}
+	w := httptest.NewRecorder()
+	if err := session.Save(r, w); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range w.Result().Cookies() {
+		b.cookies[c.Name] = c
+	}
+}
+
+func TestLoginAsksForARefreshToken(t *testing.T) {
+	s := newTestServer(t, newFakeIssuer(t))
+
+	w := newBrowser().do(http.HandlerFunc(s.LoginHandler), "/login")
+
+	if !strings.Contains(w.Body.String(), `"offline_access"`) {
+		t.Errorf("the widget doesn't ask for offline_access: %s", w.Body)
+	}
+}
+
+func TestRefreshTokensMiddleware(t *testing.T) {
+	signedIn := map[string]interface{}{
+		"id_token":      "id-token",
+		"access_token":  "access-token",
+		"refresh_token": "refresh-token",
+	}
+	tests := []struct {
+		name          string
+		path          string
+		expiresIn     time.Duration
+		tokenResponse map[string]interface{}
+		wantRefresh   bool
+		wantSession   map[string]interface{}
+		wantRedirect  bool
+	}{
+		{
+			name:        "far from expiring",
+			path:        "/profile",
+			expiresIn:   time.Hour,
+			wantSession: map[string]interface{}{"access_token": "access-token", "refresh_token": "refresh-token"},
+		},
+		{
+			name:          "about to expire",
+			path:          "/profile",
+			expiresIn:     30 * time.Second,
+			tokenResponse: map[string]interface{}{"access_token": "new-access-token", "refresh_token": "new-refresh-token", "expires_in": 3600},
+			wantRefresh:   true,
+			wantSession:   map[string]interface{}{"access_token": "new-access-token", "refresh_token": "new-refresh-token", "id_token": "id-token"},
+		},
+		{
+			name:          "expired, refresh token not rotated",
+			path:          "/profile",
+			expiresIn:     -time.Minute,
+			tokenResponse: map[string]interface{}{"access_token": "new-access-token", "id_token": "new-id-token", "expires_in": 3600},
+			wantRefresh:   true,
+			wantSession:   map[string]interface{}{"access_token": "new-access-token", "refresh_token": "refresh-token", "id_token": "new-id-token"},
+		},
+		{
+			name:         "refresh rejected",
+			path:         "/profile",
+			expiresIn:    30 * time.Second,
+			wantRefresh:  true,
+			wantSession:  map[string]interface{}{},
+			wantRedirect: true,
+		},
+		{
+			name:        "refresh rejected on the home page",
+			path:        "/",
+			expiresIn:   30 * time.Second,
+			wantRefresh: true,
+			wantSession: map[string]interface{}{},
+		},
+		{
+			name:        "logout",
+			path:        "/logout",
+			expiresIn:   30 * time.Second,
+			wantSession: map[string]interface{}{"access_token": "access-token", "refresh_token": "refresh-token"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			issuer := newFakeIssuer(t)
+			issuer.tokenResponse = tt.tokenResponse
+			s := newTestServer(t, issuer)
+			b := newBrowser()
+			b.setSession(t, s, signedIn)
+			b.setSession(t, s, map[string]interface{}{"expires_at": time.Now().Add(tt.expiresIn).Unix()})
+
+			served := false
+			w := b.do(s.refreshTokensMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				served = true
+			})), tt.path)
+
+			if served == tt.wantRedirect {
+				t.Errorf("page served %v, want %v", served, !tt.wantRedirect)
+			}
+			if tt.wantRedirect && (w.Code != http.StatusFound || w.Header().Get("Location") != "/") {
+				t.Errorf("answered %d to %q, want a redirect home", w.Code, w.Header().Get("Location"))
+			}
+
+			requests := issuer.requestsTo("token")
+			if refreshed := len(requests) > 0; refreshed != tt.wantRefresh {
+				t.Fatalf("refreshed %v, want %v", refreshed, tt.wantRefresh)
+			}
+			if tt.wantRefresh {
+				want := url.Values{
+					"grant_type":    {"refresh_token"},
+					"refresh_token": {"refresh-token"},
+					"scope":         {"openid profile email offline_access"},
+					"basic_auth":    {"client-id:client-secret"},
+				}
+				for k, v := range want {
+					if got := requests[0][k]; len(got) != 1 || got[0] != v[0] {
+						t.Errorf("refresh request has %s %v, want %v", k, got, v)
+					}
+				}
+			}
+
+			session := b.session(t, s)
+			for _, k := range []string{"id_token", "access_token", "refresh_token"} {
+				if want, ok := tt.wantSession[k]; ok && session[k] != want {
+					t.Errorf("session has %s %v, want %v", k, session[k], want)
+				}
+			}
+			if len(tt.wantSession) == 0 {
+				for _, k := range []string{"id_token", "access_token", "refresh_token", "expires_at"} {
+					if _, found := session[k]; found {
+						t.Errorf("the session still has the %s after a failed refresh", k)
+					}
+				}
+			}
+			if tt.tokenResponse != nil {
+				expires, _ := session["expires_at"].(int64)
+				if time.Until(time.Unix(expires, 0)) < 59*time.Minute {
+					t.Errorf("the refreshed token expires at %v", time.Unix(expires, 0))
+				}
+			}
+		})
+	}
+}
+
+func TestLogout(t *testing.T) {
+	tests := []struct {
+		name         string
+		session      map[string]interface{}
+		revokeStatus int
+		wantRevoked  []string
+		wantEndOkta  bool
+	}{
+		{
+			name: "signed in",
+			session: map[string]interface{}{
+				"id_token":      "id-token",
+				"access_token":  "access-token",
+				"refresh_token": "refresh-token",
+				"expires_at":    time.Now().Add(time.Hour).Unix(),
+			},
+			revokeStatus: http.StatusOK,
+			wantRevoked:  []string{"refresh-token refresh_token", "access-token access_token"},
+			wantEndOkta:  true,
+		},
+		{
+			name: "revocation failed",
+			session: map[string]interface{}{
+				"id_token":      "id-token",
+				"access_token":  "access-token",
+				"refresh_token": "refresh-token",
+			},
+			revokeStatus: http.StatusInternalServerError,
+			wantRevoked:  []string{"refresh-token refresh_token", "access-token access_token"},
+			wantEndOkta:  true,
+		},
+		{
+			name:         "not signed in",
+			revokeStatus: http.StatusOK,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			issuer := newFakeIssuer(t)
+			issuer.revokeStatus = tt.revokeStatus
+			s := newTestServer(t, issuer)
+			b := newBrowser()
+			b.setSession(t, s, tt.session)
+
+			w := b.do(http.HandlerFunc(s.LogoutHandler), "/logout")
+
+			var revoked []string
+			for _, r := range issuer.requestsTo("revoke") {
+				if r.Get("basic_auth") != "client-id:client-secret" {
+					t.Errorf("revocation authenticated as %q", r.Get("basic_auth"))
+				}
+				revoked = append(revoked, r.Get("token")+" "+r.Get("token_type_hint"))
+			}
+			if strings.Join(revoked, ", ") != strings.Join(tt.wantRevoked, ", ") {
+				t.Errorf("revoked %v, want %v", revoked, tt.wantRevoked)
+			}
+
+			session := b.session(t, s)
+			for _, k := range []string{"id_token", "access_token", "refresh_token", "expires_at"} {
+				if _, found := session[k]; found {
+					t.Errorf("the session still has the %s", k)
+				}
+			}
+
+			if w.Code != http.StatusFound {
+				t.Fatalf("logout answered %d: %s", w.Code, w.Body)
+			}
+			want := "/"
+			if tt.wantEndOkta {
+				want = issuer.issuer + "/v1/logout?" + url.Values{
+					"id_token_hint":            {"id-token"},
+					"post_logout_redirect_uri": {"http://localhost:8080/"},
+				}.Encode()
+			}
+			if got := w.Header().Get("Location"); got != want {
+				t.Errorf("logout redirected to %s, want %s", got, want)
+			}
+		})
+	}
+}
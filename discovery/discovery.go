@@ -0,0 +1,144 @@
+/**
+ * Copyright 2021 - Present Okta, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package discovery resolves the endpoints of an Okta authorization server
+// from its OpenID Connect discovery document. It works the same for the org
+// authorization server (https://{yourOktaDomain}) and custom authorization
+// servers (https://{yourOktaDomain}/oauth2/{authorizationServerId}).
+package discovery
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CacheTTL is how long a fetched discovery document is reused before it is
+// fetched again.
+var CacheTTL = time.Hour
+
+// HTTPClient is used to fetch discovery documents.
+var HTTPClient = &http.Client{Timeout: 30 * time.Second}
+
+// Configuration holds the parts of the discovery document the samples use.
+type Configuration struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	UserinfoEndpoint      string `json:"userinfo_endpoint"`
+	RevocationEndpoint    string `json:"revocation_endpoint"`
+	IntrospectionEndpoint string `json:"introspection_endpoint"`
+	EndSessionEndpoint    string `json:"end_session_endpoint"`
+	JwksURI               string `json:"jwks_uri"`
+}
+
+type cacheEntry struct {
+	config    *Configuration
+	fetchedAt time.Time
+}
+
+// fetchCall is a fetch of an issuer's document that requests for the same
+// issuer wait on instead of starting their own.
+type fetchCall struct {
+	done   chan struct{}
+	config *Configuration
+	err    error
+}
+
+var (
+	mu       sync.Mutex
+	cache    = map[string]cacheEntry{}
+	inflight = map[string]*fetchCall{}
+)
+
+// WellKnownURL returns the location of the issuer's discovery document.
+func WellKnownURL(issuer string) string {
+	return strings.TrimSuffix(issuer, "/") + "/.well-known/openid-configuration"
+}
+
+// Get returns the discovery document of issuer, fetching it if it isn't
+// cached or the cached copy is older than CacheTTL. Concurrent fetches of the
+// same issuer are merged into one, other issuers don't wait on it.
+func Get(ctx context.Context, issuer string) (*Configuration, error) {
+	issuer = strings.TrimSuffix(issuer, "/")
+
+	mu.Lock()
+	if entry, ok := cache[issuer]; ok && time.Since(entry.fetchedAt) < CacheTTL {
+		mu.Unlock()
+		return entry.config, nil
+	}
+	call, ok := inflight[issuer]
+	if !ok {
+		call = &fetchCall{done: make(chan struct{})}
+		inflight[issuer] = call
+		go fetchInto(call, issuer)
+	}
+	mu.Unlock()
+
+	select {
+	case <-call.done:
+		return call.config, call.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// fetchInto runs call and caches what it fetched. The fetch isn't tied to the
+// request that started it, the other requests waiting on it shouldn't fail
+// because that one went away.
+func fetchInto(call *fetchCall, issuer string) {
+	config, err := fetch(context.Background(), issuer)
+
+	mu.Lock()
+	if err == nil {
+		cache[issuer] = cacheEntry{config: config, fetchedAt: time.Now()}
+	}
+	delete(inflight, issuer)
+	call.config, call.err = config, err
+	mu.Unlock()
+	close(call.done)
+}
+
+func fetch(ctx context.Context, issuer string) (*Configuration, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, WellKnownURL(issuer), nil)
+	if err != nil {
+		return nil, fmt.Errorf("discovery: %w", err)
+	}
+	req.Header.Add("Accept", "application/json")
+
+	resp, err := HTTPClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("discovery: fetching %s: %w", req.URL, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("discovery: fetching %s: unexpected status %s", req.URL, resp.Status)
+	}
+
+	var config Configuration
+	if err := json.NewDecoder(resp.Body).Decode(&config); err != nil {
+		return nil, fmt.Errorf("discovery: decoding %s: %w", req.URL, err)
+	}
+	if config.Issuer != issuer {
+		return nil, fmt.Errorf("discovery: document issuer %q does not match %q", config.Issuer, issuer)
+	}
+	return &config, nil
+}
@@ -0,0 +1,166 @@
+/**
+ * Copyright 2021 - Present Okta, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package discovery
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newIssuer serves the discovery document of an issuer, claiming to be
+// issuer if it isn't empty, and counts the fetches.
+func newIssuer(t *testing.T, issuer string, status int) (string, *int64) {
+	t.Helper()
+	var fetches int64
+	var ts *httptest.Server
+	ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&fetches, 1)
+		if r.URL.Path != "/oauth2/default/.well-known/openid-configuration" {
+			http.NotFound(w, r)
+			return
+		}
+		if status != http.StatusOK {
+			w.WriteHeader(status)
+			return
+		}
+		claimed := issuer
+		if claimed == "" {
+			claimed = ts.URL + "/oauth2/default"
+		}
+		json.NewEncoder(w).Encode(&Configuration{
+			Issuer:           claimed,
+			TokenEndpoint:    claimed + "/v1/token",
+			UserinfoEndpoint: claimed + "/v1/userinfo",
+		})
+	}))
+	t.Cleanup(ts.Close)
+	t.Cleanup(func() {
+		mu.Lock()
+		cache = map[string]cacheEntry{}
+		mu.Unlock()
+	})
+	return ts.URL + "/oauth2/default", &fetches
+}
+
+func TestGet(t *testing.T) {
+	issuer, fetches := newIssuer(t, "", http.StatusOK)
+
+	config, err := Get(context.Background(), issuer+"/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Issuer != issuer || config.UserinfoEndpoint != issuer+"/v1/userinfo" {
+		t.Errorf("Get() = %+v", config)
+	}
+
+	again, err := Get(context.Background(), issuer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again != config {
+		t.Error("second Get() didn't return the cached document")
+	}
+	if n := atomic.LoadInt64(fetches); n != 1 {
+		t.Errorf("fetched the document %d times, want once", n)
+	}
+}
+
+func TestGetExpired(t *testing.T) {
+	issuer, fetches := newIssuer(t, "", http.StatusOK)
+	ttl := CacheTTL
+	CacheTTL = 0
+	defer func() { CacheTTL = ttl }()
+
+	for i := 0; i < 2; i++ {
+		if _, err := Get(context.Background(), issuer); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := atomic.LoadInt64(fetches); n != 2 {
+		t.Errorf("fetched the document %d times, want twice", n)
+	}
+}
+
+func TestGetConcurrent(t *testing.T) {
+	issuer, fetches := newIssuer(t, "", http.StatusOK)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := Get(context.Background(), issuer); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := atomic.LoadInt64(fetches); n != 1 {
+		t.Errorf("fetched the document %d times, want once", n)
+	}
+}
+
+func TestGetErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		issuer  string
+		status  int
+		wantErr string
+	}{
+		{name: "issuer mismatch", issuer: "https://evil.example.com", status: http.StatusOK, wantErr: "does not match"},
+		{name: "not found", status: http.StatusNotFound, wantErr: "unexpected status 404"},
+		{name: "server error", status: http.StatusInternalServerError, wantErr: "unexpected status 500"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			issuer, fetches := newIssuer(t, tt.issuer, tt.status)
+
+			for i := 0; i < 2; i++ {
+				_, err := Get(context.Background(), issuer)
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Get() error = %v, want %q", err, tt.wantErr)
+				}
+			}
+			if n := atomic.LoadInt64(fetches); n != 2 {
+				t.Errorf("fetched the document %d times, want errors not to be cached", n)
+			}
+		})
+	}
+}
+
+func TestGetCanceled(t *testing.T) {
+	release := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		http.NotFound(w, r)
+	}))
+	defer ts.Close()
+	defer close(release)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := Get(ctx, ts.URL); err != context.DeadlineExceeded {
+		t.Errorf("Get() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+}
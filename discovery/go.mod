@@ -0,0 +1,3 @@
+module github.com/okta/samples-golang/discovery
+
+go 1.17
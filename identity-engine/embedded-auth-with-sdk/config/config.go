@@ -16,9 +16,30 @@
 
 package config
 
-import "net/http"
+import (
+	"net/http"
+	"time"
+
+	"github.com/okta/samples-golang/utils/serve"
+)
 
 type Config struct {
 	Testing    bool
 	HttpClient *http.Client
+
+	// Address is the host:port the sample listens on.
+	Address string
+	// TLS makes the sample serve HTTPS, with the given certificate or a
+	// self-signed one.
+	TLS serve.TLS
+	// ShutdownTimeout is how long in-flight requests get to finish once the
+	// sample receives SIGINT or SIGTERM.
+	ShutdownTimeout time.Duration
+	// AllowedOrigins are the origins, as scheme://host[:port], allowed to make
+	// credentialed cross-origin requests, like a single page app using the
+	// JSON API. The sample's own pages need none.
+	AllowedOrigins []string
+	// MetricsAddress, if set, is the host:port the Prometheus metrics are
+	// served on, apart from the sample's own address.
+	MetricsAddress string
 }
@@ -157,7 +157,7 @@ func (th *TestHarness) InitializeTestSuite(ctx *godog.TestSuiteContext) {
 
 		rules, _, err := th.ListAppSignOnPolicyRules(context.Background(), th.org.appSignOnPolicy)
 		if err != nil {
-			log.Fatalf("failed to list app sign-on policy rules: %w", err)
+			log.Fatalf("failed to list app sign-on policy rules: %v", err)
 		}
 		for _, rule := range rules {
 			if rule.Name == "Catch-all Rule" {
@@ -173,9 +173,16 @@ func (th *TestHarness) InitializeTestSuite(ctx *godog.TestSuiteContext) {
 			log.Fatalf("failed to setup the organisation: %v", err)
 		}
 
-		srv.Run()
+		if err := srv.Run(); err != nil {
+			log.Fatalf("failed to start the sample: %v", err)
+		}
 	})
 	ctx.AfterSuite(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := th.server.Shutdown(ctx); err != nil {
+			log.Printf("failed to shut down the sample: %v", err)
+		}
 	})
 }
 
@@ -17,11 +17,27 @@
 package main
 
 import (
+	"log"
+
+	flag "github.com/spf13/pflag"
+
 	"github.com/okta/samples-golang/identity-engine/embedded-auth-with-sdk/config"
 	"github.com/okta/samples-golang/identity-engine/embedded-auth-with-sdk/server"
+	"github.com/okta/samples-golang/utils/serve"
 )
 
 func main() {
 	cfg := &config.Config{}
-	server.NewServer(cfg).Run()
+	flag.StringVar(&cfg.Address, "addr", "127.0.0.1:8000", "host:port to listen on")
+	flag.StringVar(&cfg.TLS.CertFile, "tls-cert", "", "PEM certificate file, serves HTTPS together with --tls-key")
+	flag.StringVar(&cfg.TLS.KeyFile, "tls-key", "", "PEM private key file of --tls-cert")
+	flag.BoolVar(&cfg.TLS.SelfSigned, "tls-self-signed", false, "serve HTTPS with a generated self-signed certificate, for development")
+	flag.DurationVar(&cfg.ShutdownTimeout, "shutdown-timeout", serve.DefaultShutdownTimeout, "how long in-flight requests get to finish on SIGINT or SIGTERM")
+	flag.StringSliceVar(&cfg.AllowedOrigins, "allowed-origin", nil, "origin allowed to make credentialed cross-origin requests, may be repeated")
+	flag.StringVar(&cfg.MetricsAddress, "metrics-addr", "", "host:port to serve the Prometheus metrics on, none if empty")
+	flag.Parse()
+
+	if err := server.NewServer(cfg).Run(); err != nil {
+		log.Fatal(err)
+	}
 }
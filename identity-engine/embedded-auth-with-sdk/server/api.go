@@ -0,0 +1,478 @@
+/**
+ * Copyright 2021 - Present Okta, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	idx "github.com/okta/okta-idx-golang"
+)
+
+// The JSON API drives the same IDX flows as the HTML pages, for single page
+// and mobile apps using this server as their backend. The flow state lives
+// in the direct-auth session like it does for the pages, so clients have to
+// send the session cookie back with every request.
+
+// apiFlowResponse is what every API call that moves a flow along returns.
+// Steps are the String() of the steps the flow can take next, Tokens is only
+// set once the flow is done.
+type apiFlowResponse struct {
+	Steps             []string               `json:"steps"`
+	IdentityProviders []idx.IdentityProvider `json:"identityProviders,omitempty"`
+	Data              interface{}            `json:"data,omitempty"`
+	Tokens            *apiTokens             `json:"tokens,omitempty"`
+}
+
+// apiTokens are the tokens a finished flow hands the client. Only the access
+// and ID tokens are given out, a refresh token never leaves the server.
+type apiTokens struct {
+	AccessToken string `json:"access_token"`
+	IDToken     string `json:"id_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int    `json:"expires_in"`
+	Scope       string `json:"scope"`
+}
+
+func newAPITokens(token *idx.Token) *apiTokens {
+	if token == nil {
+		return nil
+	}
+	return &apiTokens{
+		AccessToken: token.AccessToken,
+		IDToken:     token.IDToken,
+		TokenType:   token.TokenType,
+		ExpiresIn:   token.ExpiresIn,
+		Scope:       token.Scope,
+	}
+}
+
+type apiErrorResponse struct {
+	Error apiError `json:"error"`
+}
+
+type apiError struct {
+	Status  int      `json:"status"`
+	Message string   `json:"message"`
+	Steps   []string `json:"steps,omitempty"`
+}
+
+type apiPhoneInput struct {
+	Method      idx.PhoneOption `json:"method"`
+	PhoneNumber string          `json:"phoneNumber"`
+}
+
+// loginAction takes a step of a login. data is returned to the client along
+// with the next steps.
+type loginAction func(ctx context.Context, lr *idx.LoginResponse) (next *idx.LoginResponse, data interface{}, err error)
+
+// enrollmentAction takes a step of a registration.
+type enrollmentAction func(ctx context.Context, er *idx.EnrollmentResponse) (next *idx.EnrollmentResponse, data interface{}, err error)
+
+func (s *Server) registerAPIRoutes(r *mux.Router) {
+	api := r.PathPrefix("/api/v1/idx").Subrouter()
+
+	api.HandleFunc("/login", s.apiLogin).Methods("GET")
+	api.HandleFunc("/login", s.apiLoginStart).Methods("POST")
+	api.HandleFunc("/login/identify", s.apiLoginIdentify).Methods("POST")
+	api.HandleFunc("/login/phone", s.apiLoginPhoneVerification).Methods("POST")
+	api.HandleFunc("/login/google-auth/init", s.apiLoginGoogleAuthInit).Methods("POST")
+	api.HandleFunc("/login/skip", s.apiLoginSkip).Methods("POST")
+
+	api.HandleFunc("/register", s.apiRegistration).Methods("GET")
+	api.HandleFunc("/register", s.apiRegistrationStart).Methods("POST")
+	api.HandleFunc("/register/password", s.apiEnrollPassword).Methods("POST")
+	api.HandleFunc("/register/phone", s.apiEnrollPhoneVerification).Methods("POST")
+	api.HandleFunc("/register/google-auth/init", s.apiEnrollGoogleAuthInit).Methods("POST")
+	api.HandleFunc("/register/skip", s.apiEnrollSkip).Methods("POST")
+
+	// The authenticator steps are served from their definitions, see steps.go.
+	for _, def := range loginStepDefs {
+		if def.apiChallenge != "" {
+			api.HandleFunc("/login"+def.apiChallenge, s.apiLoginChallenge(def)).Methods("POST")
+		}
+		if def.apiSubmit != "" {
+			api.HandleFunc("/login"+def.apiSubmit, s.apiLoginSubmit(def)).Methods("POST")
+		}
+	}
+	for _, def := range enrollmentStepDefs {
+		if def.apiChallenge != "" {
+			api.HandleFunc("/register"+def.apiChallenge, s.apiEnrollmentChallenge(def)).Methods("POST")
+		}
+		if def.apiSubmit != "" {
+			api.HandleFunc("/register"+def.apiSubmit, s.apiEnrollmentSubmit(def)).Methods("POST")
+		}
+	}
+
+	api.NotFoundHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.writeAPIError(w, r, notFoundError("No such API endpoint"))
+	})
+}
+
+// BEGIN: Login
+
+func (s *Server) apiLoginStart(w http.ResponseWriter, r *http.Request) {
+	flow := s.flowState(w, r)
+	flow.Delete("loginResponse")
+	loginsStarted.Inc()
+	lr, err := s.idxClient.InitLogin(r.Context())
+	if err != nil {
+		s.writeAPIError(w, r, upstreamError(err))
+		return
+	}
+	flow.Set("loginResponse", lr, time.Minute*5)
+	s.writeJSON(w, http.StatusCreated, loginFlowResponse(lr, nil))
+}
+
+func (s *Server) apiLogin(w http.ResponseWriter, r *http.Request) {
+	clr, found := s.flowState(w, r).Get("loginResponse")
+	if !found {
+		s.writeAPIError(w, r, expiredTransactionError())
+		return
+	}
+	s.writeJSON(w, http.StatusOK, loginFlowResponse(clr.(*idx.LoginResponse), nil))
+}
+
+// apiLoginIdentify takes the identify step, with the password or, for a
+// passwordless login, without one. See handleLoginPasswordless for what the
+// policy has to look like for the latter.
+func (s *Server) apiLoginIdentify(w http.ResponseWriter, r *http.Request) {
+	var in struct {
+		Identifier string `json:"identifier"`
+		Password   string `json:"password"`
+	}
+	s.apiLoginStep(w, r, idx.LoginStepIdentify, &in, func(ctx context.Context, lr *idx.LoginResponse) (*idx.LoginResponse, interface{}, error) {
+		lr, err := lr.Identify(ctx, &idx.IdentifyRequest{
+			Identifier:  in.Identifier,
+			Credentials: idx.Credentials{Password: in.Password},
+		})
+		return lr, nil, err
+	})
+}
+
+func (s *Server) apiLoginPhoneVerification(w http.ResponseWriter, r *http.Request) {
+	var in apiPhoneInput
+	step := idx.LoginStepPhoneVerification
+	if lr, found := s.flowState(w, r).Get("loginResponse"); found && lr.(*idx.LoginResponse).HasStep(idx.LoginStepPhoneInitialVerification) {
+		step = idx.LoginStepPhoneInitialVerification
+	}
+	s.apiLoginStep(w, r, step, &in, func(ctx context.Context, lr *idx.LoginResponse) (*idx.LoginResponse, interface{}, error) {
+		if in.Method == "" {
+			in.Method = idx.PhoneMethodSMS
+		}
+		if step == idx.LoginStepPhoneInitialVerification {
+			lr, err := lr.VerifyPhoneInitial(ctx, in.Method, in.PhoneNumber)
+			return lr, nil, err
+		}
+		lr, err := lr.VerifyPhone(ctx, in.Method)
+		return lr, nil, err
+	})
+}
+
+func (s *Server) apiLoginGoogleAuthInit(w http.ResponseWriter, r *http.Request) {
+	s.apiLoginStep(w, r, idx.LoginStepGoogleAuthenticatorInitialVerification, nil, func(ctx context.Context, lr *idx.LoginResponse) (*idx.LoginResponse, interface{}, error) {
+		lr, err := lr.GoogleAuthInitialVerify(ctx)
+		if err != nil {
+			return lr, nil, err
+		}
+		return lr, googleAuthData(lr.ContextualData()), nil
+	})
+}
+
+func (s *Server) apiLoginSkip(w http.ResponseWriter, r *http.Request) {
+	s.apiLoginStep(w, r, idx.LoginStepSkip, nil, func(ctx context.Context, lr *idx.LoginResponse) (*idx.LoginResponse, interface{}, error) {
+		return loginWhereAmI(ctx)(lr.Skip(ctx))
+	})
+}
+
+// apiLoginStep runs action on the caller's login if it can take step, after
+// decoding the request body into in when it is not nil. It answers with the
+// next steps, or with the tokens once the login is done.
+func (s *Server) apiLoginStep(w http.ResponseWriter, r *http.Request, step idx.LoginStep, in interface{}, action loginAction) {
+	flow := s.flowState(w, r)
+	clr, found := flow.Get("loginResponse")
+	if !found {
+		s.writeAPIError(w, r, expiredTransactionError())
+		return
+	}
+	lr := clr.(*idx.LoginResponse)
+	if !lr.HasStep(step) {
+		s.writeAPIError(w, r, unavailableStepError(step.String(), loginStepNames(lr)))
+		return
+	}
+	if err := decodeAPIInput(w, r, in); err != nil {
+		s.writeAPIError(w, r, err)
+		return
+	}
+
+	start := time.Now()
+	next, data, err := action(r.Context(), lr)
+	observeLoginStep(step, start, err)
+	if err != nil {
+		s.writeAPIError(w, r, rejectedStepError(err))
+		return
+	}
+
+	if next.Token() != nil {
+		loginsCompleted.Inc()
+		flow.Delete("loginResponse")
+		if err := s.storeTokens(w, r, next.Token()); err != nil {
+			s.writeAPIError(w, r, internalError(err))
+			return
+		}
+	} else {
+		flow.Set("loginResponse", next, time.Minute*5)
+	}
+	s.writeJSON(w, http.StatusOK, loginFlowResponse(next, data))
+}
+
+// loginWhereAmI refreshes the steps of a login that isn't done yet, the way
+// the HTML handlers do after a confirmation.
+func loginWhereAmI(ctx context.Context) func(*idx.LoginResponse, error) (*idx.LoginResponse, interface{}, error) {
+	return func(lr *idx.LoginResponse, err error) (*idx.LoginResponse, interface{}, error) {
+		if err != nil || lr.Token() != nil {
+			return lr, nil, err
+		}
+		lr, err = lr.WhereAmI(ctx)
+		return lr, nil, err
+	}
+}
+
+func loginFlowResponse(lr *idx.LoginResponse, data interface{}) *apiFlowResponse {
+	return &apiFlowResponse{
+		Steps:             loginStepNames(lr),
+		IdentityProviders: lr.IdentityProviders(),
+		Data:              data,
+		Tokens:            newAPITokens(lr.Token()),
+	}
+}
+
+func loginStepNames(lr *idx.LoginResponse) []string {
+	steps := make([]string, 0, len(lr.AvailableSteps()))
+	for _, step := range lr.AvailableSteps() {
+		steps = append(steps, step.String())
+	}
+	return steps
+}
+
+// END: Login
+
+// BEGIN: Registration
+
+func (s *Server) apiRegistrationStart(w http.ResponseWriter, r *http.Request) {
+	var profile idx.UserProfile
+	if err := decodeAPIInput(w, r, &profile); err != nil {
+		s.writeAPIError(w, r, err)
+		return
+	}
+	flow := s.flowState(w, r)
+	flow.Delete("enrollResponse")
+	enrollmentsStarted.Inc()
+	er, err := s.idxClient.InitProfileEnroll(r.Context(), &profile)
+	if err != nil {
+		s.writeAPIError(w, r, rejectedStepError(err))
+		return
+	}
+	flow.Set("enrollResponse", er, time.Minute*5)
+	s.writeJSON(w, http.StatusCreated, enrollmentFlowResponse(er, nil))
+}
+
+func (s *Server) apiRegistration(w http.ResponseWriter, r *http.Request) {
+	cer, found := s.flowState(w, r).Get("enrollResponse")
+	if !found {
+		s.writeAPIError(w, r, expiredTransactionError())
+		return
+	}
+	s.writeJSON(w, http.StatusOK, enrollmentFlowResponse(cer.(*idx.EnrollmentResponse), nil))
+}
+
+func (s *Server) apiEnrollPassword(w http.ResponseWriter, r *http.Request) {
+	var in struct {
+		Password string `json:"password"`
+	}
+	s.apiEnrollmentStep(w, r, idx.EnrollmentStepPasswordSetup, &in, func(ctx context.Context, er *idx.EnrollmentResponse) (*idx.EnrollmentResponse, interface{}, error) {
+		er, err := er.SetNewPassword(ctx, in.Password)
+		return er, nil, err
+	})
+}
+
+func (s *Server) apiEnrollPhoneVerification(w http.ResponseWriter, r *http.Request) {
+	var in apiPhoneInput
+	s.apiEnrollmentStep(w, r, idx.EnrollmentStepPhoneVerification, &in, func(ctx context.Context, er *idx.EnrollmentResponse) (*idx.EnrollmentResponse, interface{}, error) {
+		if in.Method == "" {
+			in.Method = idx.PhoneMethodSMS
+		}
+		er, err := er.VerifyPhone(ctx, in.Method, in.PhoneNumber)
+		return er, nil, err
+	})
+}
+
+func (s *Server) apiEnrollGoogleAuthInit(w http.ResponseWriter, r *http.Request) {
+	s.apiEnrollmentStep(w, r, idx.EnrollmentStepGoogleAuthenticatorInit, nil, func(ctx context.Context, er *idx.EnrollmentResponse) (*idx.EnrollmentResponse, interface{}, error) {
+		er, err := er.GoogleAuthInit(ctx)
+		if err != nil {
+			return er, nil, err
+		}
+		return er, googleAuthData(er.ContextualData()), nil
+	})
+}
+
+func (s *Server) apiEnrollSkip(w http.ResponseWriter, r *http.Request) {
+	s.apiEnrollmentStep(w, r, idx.EnrollmentStepSkip, nil, func(ctx context.Context, er *idx.EnrollmentResponse) (*idx.EnrollmentResponse, interface{}, error) {
+		er, err := er.Skip(ctx)
+		return er, nil, err
+	})
+}
+
+// apiEnrollmentStep is apiLoginStep for registrations.
+func (s *Server) apiEnrollmentStep(w http.ResponseWriter, r *http.Request, step idx.EnrollmentStep, in interface{}, action enrollmentAction) {
+	flow := s.flowState(w, r)
+	cer, found := flow.Get("enrollResponse")
+	if !found {
+		s.writeAPIError(w, r, expiredTransactionError())
+		return
+	}
+	er := cer.(*idx.EnrollmentResponse)
+	if !er.HasStep(step) {
+		s.writeAPIError(w, r, unavailableStepError(step.String(), enrollmentStepNames(er)))
+		return
+	}
+	if err := decodeAPIInput(w, r, in); err != nil {
+		s.writeAPIError(w, r, err)
+		return
+	}
+
+	start := time.Now()
+	next, data, err := action(r.Context(), er)
+	observeEnrollmentStep(step, start, err)
+	if err != nil {
+		s.writeAPIError(w, r, rejectedStepError(err))
+		return
+	}
+
+	if next.Token() != nil {
+		enrollmentsCompleted.Inc()
+		flow.Delete("enrollResponse")
+		if err := s.storeTokens(w, r, next.Token()); err != nil {
+			s.writeAPIError(w, r, internalError(err))
+			return
+		}
+	} else {
+		flow.Set("enrollResponse", next, time.Minute*5)
+	}
+	s.writeJSON(w, http.StatusOK, enrollmentFlowResponse(next, data))
+}
+
+func enrollmentFlowResponse(er *idx.EnrollmentResponse, data interface{}) *apiFlowResponse {
+	return &apiFlowResponse{
+		Steps:  enrollmentStepNames(er),
+		Data:   data,
+		Tokens: newAPITokens(er.Token()),
+	}
+}
+
+func enrollmentStepNames(er *idx.EnrollmentResponse) []string {
+	steps := make([]string, 0, len(er.AvailableSteps()))
+	for _, step := range er.AvailableSteps() {
+		steps = append(steps, step.String())
+	}
+	return steps
+}
+
+// END: Registration
+
+func googleAuthData(cd *idx.ContextualData) interface{} {
+	return struct {
+		QRCode       string `json:"qrCode"`
+		SharedSecret string `json:"sharedSecret"`
+	}{
+		QRCode:       cd.QRcode.Href,
+		SharedSecret: cd.SharedSecret,
+	}
+}
+
+// storeTokens keeps the tokens of a finished flow in the direct-auth session,
+// like the HTML handlers do, so the API client is signed in to the pages too.
+func (s *Server) storeTokens(w http.ResponseWriter, r *http.Request, token *idx.Token) error {
+	session, err := sessionStore.Get(r, "direct-auth")
+	if err != nil {
+		return err
+	}
+	session.Values["access_token"] = token.AccessToken
+	session.Values["id_token"] = token.IDToken
+	return session.Save(r, w)
+}
+
+// decodeAPIInput decodes the JSON body of r into in, unless in is nil.
+func decodeAPIInput(w http.ResponseWriter, r *http.Request, in interface{}) error {
+	if in == nil {
+		return nil
+	}
+	defer r.Body.Close()
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(in); err != nil {
+		return invalidInputError("The request body is not valid JSON for this step", err)
+	}
+	return nil
+}
+
+// unavailableStepError is returned when the flow can't take the step the
+// client asked for, steps are the ones it can take.
+func unavailableStepError(step string, steps []string) *appError {
+	return &appError{
+		kind:    errorKindConflict,
+		message: fmt.Sprintf("The %s step is not available, the flow can take %v", step, steps),
+		steps:   steps,
+	}
+}
+
+// rejectedStepError is returned when Okta turns down the input of a step,
+// such as a wrong code. Like the HTML pages the client is shown Okta's
+// message, and can try the step again.
+func rejectedStepError(err error) *appError {
+	var respErr *idx.ResponseError
+	if errors.As(err, &respErr) {
+		return invalidInputError(err.Error(), err)
+	}
+	return upstreamError(err)
+}
+
+func (s *Server) writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("could not write the API response: %s", err)
+	}
+}
+
+// writeAPIError is renderError for the JSON API.
+func (s *Server) writeAPIError(w http.ResponseWriter, r *http.Request, err error) {
+	ae := s.logError(r, err)
+	s.writeJSON(w, ae.StatusCode(), &apiErrorResponse{Error: apiError{
+		Status:  ae.StatusCode(),
+		Message: ae.message,
+		Steps:   ae.steps,
+	}})
+}
@@ -0,0 +1,215 @@
+/**
+ * Copyright 2021 - Present Okta, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+// apiClient calls the JSON API like a browser app does, sending back the
+// session cookies it was given.
+type apiClient struct {
+	t       *testing.T
+	handler http.Handler
+	cookies map[string]*http.Cookie
+}
+
+func newAPIClient(t *testing.T) *apiClient {
+	s := newLoginTestServer(t)
+	r := mux.NewRouter()
+	s.registerAPIRoutes(r)
+	return &apiClient{t: t, handler: r, cookies: map[string]*http.Cookie{}}
+}
+
+// post sends body to path and returns the status and the decoded answer.
+func (c *apiClient) post(path, body string) (int, map[string]interface{}) {
+	c.t.Helper()
+	r := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	for _, cookie := range c.cookies {
+		r.AddCookie(cookie)
+	}
+	w := httptest.NewRecorder()
+	c.handler.ServeHTTP(w, r)
+	for _, cookie := range w.Result().Cookies() {
+		c.cookies[cookie.Name] = cookie
+	}
+
+	var out map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &out); err != nil {
+		c.t.Fatalf("POST %s answered %d with %q: %s", path, w.Code, w.Body, err)
+	}
+	return w.Code, out
+}
+
+// steps returns the steps of a flow response, or of an error's.
+func steps(out map[string]interface{}) []string {
+	if e, ok := out["error"].(map[string]interface{}); ok {
+		out = e
+	}
+	raw, _ := out["steps"].([]interface{})
+	steps := []string{}
+	for _, s := range raw {
+		steps = append(steps, s.(string))
+	}
+	return steps
+}
+
+func hasStep(out map[string]interface{}, step string) bool {
+	for _, s := range steps(out) {
+		if s == step {
+			return true
+		}
+	}
+	return false
+}
+
+func TestAPILogin(t *testing.T) {
+	c := newAPIClient(t)
+
+	status, out := c.post("/api/v1/idx/login", "")
+	if status != http.StatusCreated || !hasStep(out, "IDENTIFY") {
+		t.Fatalf("start: %d %v", status, out)
+	}
+
+	// Passwordless, the policy of the fake offers no password
+	status, out = c.post("/api/v1/idx/login/identify", `{"identifier": "`+fakeUsername+`"}`)
+	if status != http.StatusOK || !hasStep(out, "EMAIL_VERIFICATION") {
+		t.Fatalf("identify: %d %v", status, out)
+	}
+
+	status, out = c.post("/api/v1/idx/login/email", "")
+	if status != http.StatusOK || !hasStep(out, "EMAIL_CONFIRMATION") {
+		t.Fatalf("email challenge: %d %v", status, out)
+	}
+
+	status, out = c.post("/api/v1/idx/login/email/confirm", `{"code": "`+fakeEmailCode+`"}`)
+	if status != http.StatusOK {
+		t.Fatalf("email confirmation: %d %v", status, out)
+	}
+	want := map[string]interface{}{
+		"access_token": fakeAccessToken,
+		"id_token":     fakeIDToken,
+		"token_type":   "Bearer",
+		"expires_in":   float64(3600),
+		"scope":        "openid profile",
+	}
+	if !reflect.DeepEqual(out["tokens"], want) {
+		t.Errorf("tokens = %v, want %v", out["tokens"], want)
+	}
+
+	access, id := sessionTokens(t, []*http.Cookie{c.cookies["direct-auth"]})
+	if access != fakeAccessToken || id != fakeIDToken {
+		t.Errorf("session has tokens %v and %v", access, id)
+	}
+}
+
+func TestAPILoginErrors(t *testing.T) {
+	tests := []struct {
+		name       string
+		setup      []string
+		path       string
+		body       string
+		wantStatus int
+		wantSteps  []string
+	}{
+		{
+			name:       "no login",
+			path:       "/api/v1/idx/login/identify",
+			body:       `{"identifier": "` + fakeUsername + `"}`,
+			wantStatus: http.StatusGone,
+		},
+		{
+			name:       "unknown user",
+			setup:      []string{"/api/v1/idx/login"},
+			path:       "/api/v1/idx/login/identify",
+			body:       `{"identifier": "nobody@example.com"}`,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "unknown field",
+			setup:      []string{"/api/v1/idx/login"},
+			path:       "/api/v1/idx/login/identify",
+			body:       `{"username": "` + fakeUsername + `"}`,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "invalid json",
+			setup:      []string{"/api/v1/idx/login"},
+			path:       "/api/v1/idx/login/identify",
+			body:       `{"identifier":`,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "step the login can't take",
+			setup:      []string{"/api/v1/idx/login"},
+			path:       "/api/v1/idx/login/email/confirm",
+			body:       `{"code": "` + fakeEmailCode + `"}`,
+			wantStatus: http.StatusConflict,
+			wantSteps:  []string{"CANCEL", "IDENTIFY", "EMAIL_VERIFICATION"},
+		},
+		{
+			name:       "wrong code",
+			setup:      []string{"/api/v1/idx/login", "/api/v1/idx/login/email"},
+			path:       "/api/v1/idx/login/email/confirm",
+			body:       `{"code": "000000"}`,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "no code",
+			setup:      []string{"/api/v1/idx/login", "/api/v1/idx/login/email"},
+			path:       "/api/v1/idx/login/email/confirm",
+			body:       `{}`,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "no such endpoint",
+			path:       "/api/v1/idx/login/nothing",
+			wantStatus: http.StatusNotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newAPIClient(t)
+			for _, path := range tt.setup {
+				if status, out := c.post(path, ""); status >= 300 {
+					t.Fatalf("%s: %d %v", path, status, out)
+				}
+			}
+
+			status, out := c.post(tt.path, tt.body)
+
+			if status != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %v", status, tt.wantStatus, out)
+			}
+			e, ok := out["error"].(map[string]interface{})
+			if !ok || e["status"] != float64(tt.wantStatus) || e["message"] == "" {
+				t.Errorf("error = %v", out["error"])
+			}
+			if tt.wantSteps != nil && !reflect.DeepEqual(steps(out), tt.wantSteps) {
+				t.Errorf("steps = %v, want %v", steps(out), tt.wantSteps)
+			}
+		})
+	}
+}
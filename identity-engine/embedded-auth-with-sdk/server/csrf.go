@@ -0,0 +1,132 @@
+/**
+ * Copyright 2021 - Present Okta, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package server
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Every request that isn't a GET, HEAD or OPTIONS has to carry the CSRF token
+// of its session, either in the csrf_token field of the form, which the
+// _csrf template adds, or in the X-CSRF-Token header for the JSON API and
+// the pages' scripts. The token is kept in the direct-auth session, and
+// requests whose Origin or Referer is another site than this one or the
+// configured origins are turned down before the token is even looked at.
+const (
+	csrfTokenKey    = "csrf_token"
+	csrfFieldName   = "csrf_token"
+	csrfHeaderName  = "X-CSRF-Token"
+	csrfTokenLength = 32
+)
+
+func (s *Server) csrfMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			if strings.HasPrefix(r.URL.Path, "/api/") {
+				// API clients read the token from the header of any GET
+				if token, err := s.csrfToken(w, r); err == nil {
+					w.Header().Set(csrfHeaderName, token)
+				}
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if !s.sameSite(r) {
+			s.rejectCSRF(w, r, "Cross-site requests are not allowed")
+			return
+		}
+		session, err := sessionStore.Get(r, "direct-auth")
+		if err != nil {
+			s.rejectCSRF(w, r, "The request has no session, please reload the page and try again")
+			return
+		}
+		expected, _ := session.Values[csrfTokenKey].(string)
+		got := r.Header.Get(csrfHeaderName)
+		if got == "" {
+			got = r.PostFormValue(csrfFieldName)
+		}
+		if expected == "" || subtle.ConstantTimeCompare([]byte(expected), []byte(got)) != 1 {
+			s.rejectCSRF(w, r, "The form has expired, please reload the page and try again")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// csrfToken returns the CSRF token of the caller's session, creating it on
+// the first visit.
+func (s *Server) csrfToken(w http.ResponseWriter, r *http.Request) (string, error) {
+	session, err := sessionStore.Get(r, "direct-auth")
+	if err != nil {
+		return "", err
+	}
+	if token, ok := session.Values[csrfTokenKey].(string); ok && token != "" {
+		return token, nil
+	}
+	b := make([]byte, csrfTokenLength)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+	session.Values[csrfTokenKey] = token
+	if err := session.Save(r, w); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// sameSite reports whether the request comes from this server's pages or one
+// of the configured origins. Requests without an Origin or Referer, like
+// those of API clients that aren't browsers, are left to the token check.
+func (s *Server) sameSite(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		origin = r.Header.Get("Referer")
+	}
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil || u.Host == "" {
+		return false
+	}
+	if u.Host == r.Host {
+		return true
+	}
+	for _, allowed := range s.config.AllowedOrigins {
+		if strings.EqualFold(strings.TrimSuffix(allowed, "/"), u.Scheme+"://"+u.Host) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Server) rejectCSRF(w http.ResponseWriter, r *http.Request, message string) {
+	err := forbiddenError(message)
+	if strings.HasPrefix(r.URL.Path, "/api/") {
+		s.writeAPIError(w, r, err)
+		return
+	}
+	s.renderError(w, r, err)
+}
@@ -0,0 +1,136 @@
+/**
+ * Copyright 2021 - Present Okta, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/okta/samples-golang/identity-engine/embedded-auth-with-sdk/config"
+)
+
+func newCSRFTestServer() *Server {
+	return &Server{config: &config.Config{AllowedOrigins: []string{"https://spa.example.com/"}}}
+}
+
+// csrfSession gets a CSRF token the way API clients do, from the header of a
+// GET, and returns it with the session cookies.
+func csrfSession(t *testing.T, s *Server) (string, []*http.Cookie) {
+	t.Helper()
+	w := httptest.NewRecorder()
+	s.csrfMiddleware(http.NotFoundHandler()).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/login", nil))
+	token := w.Header().Get(csrfHeaderName)
+	if token == "" {
+		t.Fatal("GET of the API didn't return a CSRF token")
+	}
+	return token, w.Result().Cookies()
+}
+
+func TestCSRFMiddleware(t *testing.T) {
+	s := newCSRFTestServer()
+	token, cookies := csrfSession(t, s)
+
+	tests := []struct {
+		name     string
+		method   string
+		path     string
+		cookies  bool
+		header   string
+		field    string
+		origin   string
+		referer  string
+		wantNext bool
+	}{
+		{name: "GET needs no token", method: http.MethodGet, path: "/login", wantNext: true},
+		{name: "HEAD needs no token", method: http.MethodHead, path: "/login", wantNext: true},
+		{name: "token in the header", path: "/api/v1/login", cookies: true, header: token, wantNext: true},
+		{name: "token in the form", path: "/login", cookies: true, field: token, wantNext: true},
+		{name: "no token", path: "/api/v1/login", cookies: true},
+		{name: "wrong token", path: "/api/v1/login", cookies: true, header: token + "x"},
+		{name: "token without its session", path: "/api/v1/login", header: token},
+		{name: "same host origin", path: "/api/v1/login", cookies: true, header: token, origin: "http://example.com", wantNext: true},
+		{name: "configured origin", path: "/api/v1/login", cookies: true, header: token, origin: "https://spa.example.com", wantNext: true},
+		{name: "configured origin is exact", path: "/api/v1/login", cookies: true, header: token, origin: "http://spa.example.com"},
+		{name: "cross-site origin", path: "/api/v1/login", cookies: true, header: token, origin: "https://evil.example.com"},
+		{name: "cross-site referer", path: "/api/v1/login", cookies: true, header: token, referer: "https://evil.example.com/page"},
+		{name: "opaque origin", path: "/api/v1/login", cookies: true, header: token, origin: "null"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			method := tt.method
+			if method == "" {
+				method = http.MethodPost
+			}
+			form := url.Values{}
+			if tt.field != "" {
+				form.Set(csrfFieldName, tt.field)
+			}
+			r := httptest.NewRequest(method, tt.path, strings.NewReader(form.Encode()))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			if tt.cookies {
+				for _, c := range cookies {
+					r.AddCookie(c)
+				}
+			}
+			if tt.header != "" {
+				r.Header.Set(csrfHeaderName, tt.header)
+			}
+			if tt.origin != "" {
+				r.Header.Set("Origin", tt.origin)
+			}
+			if tt.referer != "" {
+				r.Header.Set("Referer", tt.referer)
+			}
+
+			called := false
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				called = true
+			})
+			w := httptest.NewRecorder()
+			s.csrfMiddleware(next).ServeHTTP(w, r)
+
+			if called != tt.wantNext {
+				t.Errorf("next called = %v, want %v", called, tt.wantNext)
+			}
+			if !tt.wantNext && w.Code != http.StatusForbidden {
+				t.Errorf("status = %d, want %d", w.Code, http.StatusForbidden)
+			}
+		})
+	}
+}
+
+func TestCSRFTokenIsKeptPerSession(t *testing.T) {
+	s := newCSRFTestServer()
+	token, cookies := csrfSession(t, s)
+
+	r := httptest.NewRequest(http.MethodGet, "/api/v1/login", nil)
+	for _, c := range cookies {
+		r.AddCookie(c)
+	}
+	w := httptest.NewRecorder()
+	s.csrfMiddleware(http.NotFoundHandler()).ServeHTTP(w, r)
+	if got := w.Header().Get(csrfHeaderName); got != token {
+		t.Errorf("second GET returned token %q, want the session's %q", got, token)
+	}
+
+	if other, _ := csrfSession(t, s); other == token {
+		t.Error("two sessions got the same token")
+	}
+}
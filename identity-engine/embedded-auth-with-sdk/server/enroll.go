@@ -3,9 +3,8 @@ package server
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"html/template"
-	"io/ioutil"
-	"log"
 	"net/http"
 	"time"
 
@@ -13,10 +12,11 @@ import (
 )
 
 func (s *Server) register(w http.ResponseWriter, r *http.Request) {
-	s.render("register.gohtml", w, r)
+	s.render("register.gohtml", w, r, &ViewData{})
 }
 
 func (s *Server) handleRegister(w http.ResponseWriter, r *http.Request) {
+	flow := s.flowState(w, r)
 	profile := &idx.UserProfile{
 		FirstName: r.FormValue("firstName"),
 		LastName:  r.FormValue("lastName"),
@@ -26,9 +26,11 @@ func (s *Server) handleRegister(w http.ResponseWriter, r *http.Request) {
 	// Get session store so we can store our tokens
 	session, err := sessionStore.Get(r, "direct-auth")
 	if err != nil {
-		log.Fatalf("could not get store: %s", err)
+		s.renderError(w, r, internalError(err))
+		return
 	}
 
+	enrollmentsStarted.Inc()
 	enrollResponse, err := s.idxClient.InitProfileEnroll(context.TODO(), profile)
 	if err != nil {
 		session.Values["Errors"] = err.Error()
@@ -36,7 +38,7 @@ func (s *Server) handleRegister(w http.ResponseWriter, r *http.Request) {
 		http.Redirect(w, r, "/register", http.StatusFound)
 		return
 	}
-	s.cache.Set("enrollResponse", enrollResponse, time.Minute*5)
+	flow.Set("enrollResponse", enrollResponse, time.Minute*5)
 	if enrollResponse.HasStep(idx.EnrollmentStepPasswordSetup) {
 		http.Redirect(w, r, "/enrollPassword", http.StatusFound)
 		return
@@ -44,12 +46,18 @@ func (s *Server) handleRegister(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *Server) enrollFactor(w http.ResponseWriter, r *http.Request) {
-	cer, _ := s.cache.Get("enrollResponse")
+	flow := s.flowState(w, r)
+	cer, found := flow.Get("enrollResponse")
+	if !found {
+		s.renderError(w, r, expiredTransactionError())
+		return
+	}
 	enrollResponse := cer.(*idx.EnrollmentResponse)
 
-	if errors, ok := s.cache.Get("Errors"); ok {
-		s.ViewData["Errors"] = errors
-		s.cache.Delete("Errors")
+	data := &factorsView{}
+	if errors, ok := flow.Get("Errors"); ok {
+		data.Errors = errors.(string)
+		flow.Delete("Errors")
 	}
 
 	if enrollResponse.EnrollmentSuccess() {
@@ -57,13 +65,13 @@ func (s *Server) enrollFactor(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	s.ViewData["FactorSkip"] = enrollResponse.HasStep(idx.EnrollmentStepSkip)
-	s.ViewData["FactorPhone"] = enrollResponse.HasStep(idx.EnrollmentStepPhoneVerification)
-	s.ViewData["FactorEmail"] = enrollResponse.HasStep(idx.EnrollmentStepEmailVerification)
-	s.ViewData["FactorOktaVerify"] = enrollResponse.HasStep(idx.EnrollmentStepOktaVerifyInit)
-	s.ViewData["FactorGoogleAuth"] = enrollResponse.HasStep(idx.EnrollmentStepGoogleAuthenticatorInit)
-	s.ViewData["FactorWebAuthN"] = enrollResponse.HasStep(idx.EnrollmentStepWebAuthNSetup)
-	s.ViewData["FactorSecurityQuestion"] = enrollResponse.HasStep(idx.EnrollmentStepSecurityQuestionOptions)
+	data.FactorSkip = enrollResponse.HasStep(idx.EnrollmentStepSkip)
+	data.FactorPhone = enrollResponse.HasStep(idx.EnrollmentStepPhoneVerification)
+	data.FactorEmail = enrollResponse.HasStep(idx.EnrollmentStepEmailVerification)
+	data.FactorOktaVerify = enrollResponse.HasStep(idx.EnrollmentStepOktaVerifyInit)
+	data.FactorGoogleAuth = enrollResponse.HasStep(idx.EnrollmentStepGoogleAuthenticatorInit)
+	data.FactorWebAuthN = enrollResponse.HasStep(idx.EnrollmentStepWebAuthNSetup)
+	data.FactorSecurityQuestion = enrollResponse.HasStep(idx.EnrollmentStepSecurityQuestionOptions)
 
 	if !enrollResponse.HasStep(idx.EnrollmentStepPhoneVerification) &&
 		!enrollResponse.HasStep(idx.EnrollmentStepEmailVerification) &&
@@ -75,11 +83,16 @@ func (s *Server) enrollFactor(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	s.render("enroll.gohtml", w, r)
+	s.render("enroll.gohtml", w, r, data)
 }
 
 func (s *Server) handleEnrollFactor(w http.ResponseWriter, r *http.Request) {
-	cer, _ := s.cache.Get("enrollResponse")
+	flow := s.flowState(w, r)
+	cer, found := flow.Get("enrollResponse")
+	if !found {
+		s.renderError(w, r, expiredTransactionError())
+		return
+	}
 	enrollResponse := cer.(*idx.EnrollmentResponse)
 
 	submit := r.FormValue("submit")
@@ -116,32 +129,39 @@ func (s *Server) handleEnrollFactor(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *Server) transitionToProfile(er *idx.EnrollmentResponse, w http.ResponseWriter, r *http.Request) {
+	flow := s.flowState(w, r)
 	session, err := sessionStore.Get(r, "direct-auth")
 	if err != nil {
-		log.Fatalf("could not get store: %s", err)
+		s.renderError(w, r, internalError(err))
+		return
 	}
 
 	// skip can be an option if we aren't at the conclusion of enrollment success
 	if !er.EnrollmentSuccess() {
+		start := time.Now()
 		er, err := er.Skip(r.Context())
+		observeEnrollmentStep(idx.EnrollmentStepSkip, start, err)
 		if err != nil {
 			session.Values["Errors"] = err.Error()
 			session.Save(r, w)
 			http.Redirect(w, r, "/", http.StatusFound)
 			return
 		}
-		s.cache.Set("enrollResponse", er, time.Minute*5)
+		flow.Set("enrollResponse", er, time.Minute*5)
 	}
 
 	if er.Token() != nil {
+		enrollmentsCompleted.Inc()
 		session.Values["access_token"] = er.Token().AccessToken
 		session.Values["id_token"] = er.Token().IDToken
 		err = session.Save(r, w)
 		if err != nil {
-			log.Fatalf("could not save access token: %s", err)
+			s.renderError(w, r, internalError(err))
+			return
 		}
 	} else {
-		log.Fatal("attempting to transition to profile but no token is present")
+		s.renderError(w, r, internalError(errors.New("attempting to transition to profile but no token is present")))
+		return
 	}
 
 	http.Redirect(w, r, "/", http.StatusFound)
@@ -149,17 +169,23 @@ func (s *Server) transitionToProfile(er *idx.EnrollmentResponse, w http.Response
 }
 
 func (s *Server) enrollPassword(w http.ResponseWriter, r *http.Request) {
-	s.render("enrollPassword.gohtml", w, r)
+	s.render("enrollPassword.gohtml", w, r, &ViewData{})
 }
 
 func (s *Server) handleEnrollPassword(w http.ResponseWriter, r *http.Request) {
-	cer, _ := s.cache.Get("enrollResponse")
+	flow := s.flowState(w, r)
+	cer, found := flow.Get("enrollResponse")
+	if !found {
+		s.renderError(w, r, expiredTransactionError())
+		return
+	}
 	enrollResponse := cer.(*idx.EnrollmentResponse)
 
 	// Get session store so we can store our tokens
 	session, err := sessionStore.Get(r, "direct-auth")
 	if err != nil {
-		log.Fatalf("could not get store: %s", err)
+		s.renderError(w, r, internalError(err))
+		return
 	}
 
 	newPassword := r.FormValue("newPassword")
@@ -172,14 +198,16 @@ func (s *Server) handleEnrollPassword(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	start := time.Now()
 	enrollResponse, err = enrollResponse.SetNewPassword(context.TODO(), r.FormValue("newPassword"))
+	observeEnrollmentStep(idx.EnrollmentStepPasswordSetup, start, err)
 	if err != nil {
 		session.Values["Errors"] = err.Error()
 		session.Save(r, w)
 		http.Redirect(w, r, "/enrollPassword", http.StatusFound)
 		return
 	}
-	s.cache.Set("enrollResponse", enrollResponse, time.Minute*5)
+	flow.Set("enrollResponse", enrollResponse, time.Minute*5)
 
 	if !enrollResponse.HasStep(idx.EnrollmentStepSuccess) {
 		http.Redirect(w, r, "/enrollFactor", http.StatusFound)
@@ -187,11 +215,13 @@ func (s *Server) handleEnrollPassword(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if enrollResponse.Token() != nil {
+		enrollmentsCompleted.Inc()
 		session.Values["access_token"] = enrollResponse.Token().AccessToken
 		session.Values["id_token"] = enrollResponse.Token().IDToken
 		err = session.Save(r, w)
 		if err != nil {
-			log.Fatalf("could not save access token: %s", err)
+			s.renderError(w, r, internalError(err))
+			return
 		}
 	} else {
 		session.Values["Errors"] = "This sample does not support this use case, please review your policy setup and try again."
@@ -203,64 +233,23 @@ func (s *Server) handleEnrollPassword(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *Server) enrollPhone(w http.ResponseWriter, r *http.Request) {
-	s.render("enrollPhone.gohtml", w, r)
+	s.render("enrollPhone.gohtml", w, r, &ViewData{})
 }
 
 func (s *Server) enrollPhoneMethod(w http.ResponseWriter, r *http.Request) {
-	s.cache.Set("phoneNumber", r.FormValue("phoneNumber"), time.Minute*5)
-	s.render("enrollPhoneMethod.gohtml", w, r)
-}
-
-func (s *Server) handleEnrollPhoneCode(w http.ResponseWriter, r *http.Request) {
-	cer, _ := s.cache.Get("enrollResponse")
-	enrollResponse := cer.(*idx.EnrollmentResponse)
-
-	session, err := sessionStore.Get(r, "direct-auth")
-	if err != nil {
-		log.Fatalf("could not get store: %s", err)
-	}
-	enrollResponse, err = enrollResponse.ConfirmPhone(r.Context(), r.FormValue("code"))
-	if err != nil {
-		s.ViewData["InvalidPhoneCode"] = true
-		session.Values["Errors"] = err.Error()
-		session.Save(r, w)
-		s.render("enrollPhoneCode.gohtml", w, r)
-		return
-	}
-	s.ViewData["InvalidPhoneCode"] = false
-	// If we have tokens we have success, so lets store tokens
-	if enrollResponse.Token() != nil {
-		session, err := sessionStore.Get(r, "direct-auth")
-		if err != nil {
-			log.Fatalf("could not get store: %s", err)
-		}
-		session.Values["access_token"] = enrollResponse.Token().AccessToken
-		session.Values["id_token"] = enrollResponse.Token().IDToken
-		err = session.Save(r, w)
-		if err != nil {
-			log.Fatalf("could not save access token: %s", err)
-		}
-		// redirect the user to /profile
-		http.Redirect(w, r, "/", http.StatusFound)
-		return
-	}
-	enrollResponse, err = enrollResponse.WhereAmI(r.Context())
-	if err != nil {
-		session.Values["Errors"] = err.Error()
-		session.Save(r, w)
-		http.Redirect(w, r, "/login", http.StatusFound)
-		return
-	}
-	s.cache.Set("enrollResponse", enrollResponse, time.Minute*5)
-	http.Redirect(w, r, "/enrollFactor", http.StatusFound)
+	flow := s.flowState(w, r)
+	flow.Set("phoneNumber", r.FormValue("phoneNumber"), time.Minute*5)
+	s.render("enrollPhoneMethod.gohtml", w, r, &ViewData{})
 }
 
 func (s *Server) handleEnrollPhoneMethod(w http.ResponseWriter, r *http.Request) {
+	flow := s.flowState(w, r)
 	session, err := sessionStore.Get(r, "direct-auth")
 	if err != nil {
-		log.Fatalf("could not get store: %s", err)
+		s.renderError(w, r, internalError(err))
+		return
 	}
-	pn, _ := s.cache.Get("phoneNumber")
+	pn, _ := flow.Get("phoneNumber")
 	if pn == nil {
 		session.Values["Errors"] = "Invalid phone phone Number"
 		session.Save(r, w)
@@ -268,7 +257,7 @@ func (s *Server) handleEnrollPhoneMethod(w http.ResponseWriter, r *http.Request)
 		return
 	}
 	var pm idx.PhoneOption
-	spm, _ := s.cache.Get("phoneMethod")
+	spm, _ := flow.Get("phoneMethod")
 	if spm != nil {
 		pm = spm.(idx.PhoneOption)
 	} else if r.FormValue("mobile_factor") == "voice" {
@@ -281,130 +270,97 @@ func (s *Server) handleEnrollPhoneMethod(w http.ResponseWriter, r *http.Request)
 		http.Redirect(w, r, "/enrollPhone/method", http.StatusFound)
 		return
 	}
-	s.cache.Set("phoneMethod", pm, time.Minute*6)
+	flow.Set("phoneMethod", pm, time.Minute*6)
 
-	cer, _ := s.cache.Get("enrollResponse")
+	cer, found := flow.Get("enrollResponse")
+	if !found {
+		s.renderError(w, r, expiredTransactionError())
+		return
+	}
 	enrollResponse := cer.(*idx.EnrollmentResponse)
 
-	invCode, ok := s.ViewData["InvalidPhoneCode"]
-	if !ok || !invCode.(bool) {
+	if _, rejected := flow.Get(enrollPhoneStep.rejectedKey()); !rejected {
+		start := time.Now()
 		enrollResponse, err = enrollResponse.VerifyPhone(r.Context(), pm, pn.(string))
+		observeEnrollmentStep(idx.EnrollmentStepPhoneVerification, start, err)
 		if err != nil {
-			s.cache.Set("Errors", err.Error(), time.Minute*5)
+			flow.Set("Errors", err.Error(), time.Minute*5)
 			session.Values["Errors"] = err.Error()
 			session.Save(r, w)
 			http.Redirect(w, r, "/enrollFactor", http.StatusFound)
 			return
 		}
-		s.cache.Set("enrollResponse", enrollResponse, time.Minute*5)
-	}
-	s.render("enrollPhoneCode.gohtml", w, r)
-}
-
-func (s *Server) enrollEmail(w http.ResponseWriter, r *http.Request) {
-	cer, _ := s.cache.Get("enrollResponse")
-	enrollResponse := cer.(*idx.EnrollmentResponse)
-	if !enrollResponse.HasStep(idx.EnrollmentStepEmailVerification) {
-		http.Redirect(w, r, "/enrollFactor", http.StatusFound)
-		return
-	}
-	invCode, ok := s.ViewData["InvalidEmailCode"]
-	if !ok || !invCode.(bool) {
-		enrollResponse, err := enrollResponse.VerifyEmail(r.Context())
-		if err != nil {
-			http.Redirect(w, r, "/enrollFactor", http.StatusFound)
-			return
-		}
-		s.cache.Set("enrollResponse", enrollResponse, time.Minute*5)
+		flow.Set("enrollResponse", enrollResponse, time.Minute*5)
 	}
-	s.render("enrollEmail.gohtml", w, r)
+	s.renderStep(w, r, &enrollPhoneStep.stepForm, nil, false)
 }
 
-func (s *Server) handleEnrollEmail(w http.ResponseWriter, r *http.Request) {
-	cer, _ := s.cache.Get("enrollResponse")
-	enrollResponse := cer.(*idx.EnrollmentResponse)
-	if !enrollResponse.HasStep(idx.EnrollmentStepEmailConfirmation) {
-		http.Redirect(w, r, "/enrollFactor", http.StatusFound)
-		return
-	}
-	session, err := sessionStore.Get(r, "direct-auth")
-	if err != nil {
-		log.Fatalf("could not get store: %s", err)
-	}
-	enrollResponse, err = enrollResponse.ConfirmEmail(r.Context(), r.FormValue("code"))
-	if err != nil {
-		s.ViewData["InvalidEmailCode"] = true
-		session.Values["Errors"] = err.Error()
-		session.Save(r, w)
-		http.Redirect(w, r, "/enrollEmail", http.StatusFound)
-		return
-	}
-	s.ViewData["InvalidEmailCode"] = false
-	if enrollResponse.Token() != nil {
-		session, err := sessionStore.Get(r, "direct-auth")
-		if err != nil {
-			log.Fatalf("could not get store: %s", err)
-		}
-		session.Values["access_token"] = enrollResponse.Token().AccessToken
-		session.Values["id_token"] = enrollResponse.Token().IDToken
-		err = session.Save(r, w)
-		if err != nil {
-			log.Fatalf("could not save access token: %s", err)
-		}
-		// redirect the user to /profile
-		http.Redirect(w, r, "/", http.StatusFound)
-		return
-	}
-	enrollResponse, err = enrollResponse.WhereAmI(r.Context())
-	if err != nil {
-		session.Values["Errors"] = err.Error()
-		session.Save(r, w)
-		http.Redirect(w, r, "/login", http.StatusFound)
+func (s *Server) enrollOktaVerify(w http.ResponseWriter, r *http.Request) {
+	flow := s.flowState(w, r)
+	cer, found := flow.Get("enrollResponse")
+	if !found {
+		s.renderError(w, r, expiredTransactionError())
 		return
 	}
-	s.cache.Set("enrollResponse", enrollResponse, time.Minute*5)
-	http.Redirect(w, r, "/enrollFactor", http.StatusFound)
-}
-
-func (s *Server) enrollOktaVerify(w http.ResponseWriter, r *http.Request) {
-	cer, _ := s.cache.Get("enrollResponse")
 	enrollResponse := cer.(*idx.EnrollmentResponse)
 	if !enrollResponse.HasStep(idx.EnrollmentStepOktaVerifyInit) {
-		s.ViewData["Errors"] = "Missing enrollment step Okta Verify"
+		if session, err := sessionStore.Get(r, "direct-auth"); err == nil {
+			session.Values["Errors"] = "Missing enrollment step Okta Verify"
+			session.Save(r, w)
+		}
 		http.Redirect(w, r, "/enrollFactor", http.StatusFound)
 		return
 	}
 
-	s.render("enrollOktaVerify.gohtml", w, r)
+	s.render("enrollOktaVerify.gohtml", w, r, &ViewData{})
 }
 
 func (s *Server) enrollOktaVerifyQR(w http.ResponseWriter, r *http.Request) {
-	cer, _ := s.cache.Get("enrollResponse")
+	flow := s.flowState(w, r)
+	cer, found := flow.Get("enrollResponse")
+	if !found {
+		s.renderError(w, r, expiredTransactionError())
+		return
+	}
 	enrollResponse := cer.(*idx.EnrollmentResponse)
 	if !enrollResponse.HasStep(idx.EnrollmentStepOktaVerifyInit) {
-		s.ViewData["Errors"] = "Missing enrollment step Okta Verify"
+		if session, err := sessionStore.Get(r, "direct-auth"); err == nil {
+			session.Values["Errors"] = "Missing enrollment step Okta Verify"
+			session.Save(r, w)
+		}
 		http.Redirect(w, r, "/enrollFactor", http.StatusFound)
 		return
 	}
 
+	start := time.Now()
 	enrollResponse, err := enrollResponse.OktaVerifyInit(r.Context(), idx.OktaVerifyOptionQRCode)
+	observeEnrollmentStep(idx.EnrollmentStepOktaVerifyInit, start, err)
 	if err != nil {
-		log.Fatalf("okta verify error: %s", err)
+		s.renderError(w, r, upstreamError(err))
+		return
 	}
-	s.cache.Set("enrollResponse", enrollResponse, time.Minute*5)
+	flow.Set("enrollResponse", enrollResponse, time.Minute*5)
 
-	s.ViewData["QRCode"] = enrollResponse.ContextualData().QRcode.Href
-	s.render("enrollOktaVerifyQR.gohtml", w, r)
+	data := &oktaVerifyQRView{
+		QRCode: enrollResponse.ContextualData().QRcode.Href,
+	}
+	s.render("enrollOktaVerifyQR.gohtml", w, r, data)
 }
 
 func (s *Server) handleEnrollOktaVerifyQR(w http.ResponseWriter, r *http.Request) {
+	flow := s.flowState(w, r)
 	// we don't need to poll anylonger if enrollment step enroll poll is missing  and enroll okta verify is missing
-	cer, _ := s.cache.Get("enrollResponse")
+	cer, found := flow.Get("enrollResponse")
+	if !found {
+		s.renderError(w, r, expiredTransactionError())
+		return
+	}
 	enrollResponse := cer.(*idx.EnrollmentResponse)
 	_, continuePolling, err := enrollResponse.OktaVerifyContinuePolling(r.Context())
-	s.cache.Set("enrollResponse", enrollResponse, time.Minute*5)
+	flow.Set("enrollResponse", enrollResponse, time.Minute*5)
 	if err != nil {
-		log.Fatalf("okta verify confirmation error: %s", err)
+		s.renderError(w, r, upstreamError(err))
+		return
 	}
 
 	data := struct {
@@ -412,34 +368,51 @@ func (s *Server) handleEnrollOktaVerifyQR(w http.ResponseWriter, r *http.Request
 		Next            string
 	}{continuePolling, "/enrollFactor"}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
 	resp, err := json.Marshal(data)
 	if err != nil {
-		log.Fatalf("Error happened in JSON marshal. Err: %s", err)
+		s.renderError(w, r, internalError(err))
+		return
 	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
 	w.Write(resp)
 }
 
 func (s *Server) enrollOktaVerifySMS(w http.ResponseWriter, r *http.Request) {
-	cer, _ := s.cache.Get("enrollResponse")
+	flow := s.flowState(w, r)
+	cer, found := flow.Get("enrollResponse")
+	if !found {
+		s.renderError(w, r, expiredTransactionError())
+		return
+	}
 	enrollResponse := cer.(*idx.EnrollmentResponse)
 	if !enrollResponse.HasStep(idx.EnrollmentStepOktaVerifyInit) {
-		s.ViewData["Errors"] = "Missing enrollment step Okta Verify"
+		if session, err := sessionStore.Get(r, "direct-auth"); err == nil {
+			session.Values["Errors"] = "Missing enrollment step Okta Verify"
+			session.Save(r, w)
+		}
 		http.Redirect(w, r, "/enrollFactor", http.StatusFound)
 		return
 	}
 
-	s.cache.Set("enrollResponse", enrollResponse, time.Minute*5)
+	flow.Set("enrollResponse", enrollResponse, time.Minute*5)
 
-	s.render("enrollOktaVerifySMS.gohtml", w, r)
+	s.render("enrollOktaVerifySMS.gohtml", w, r, &ViewData{})
 }
 
 func (s *Server) handleEnrollOktaVerifySMSNumber(w http.ResponseWriter, r *http.Request) {
-	cer, _ := s.cache.Get("enrollResponse")
+	flow := s.flowState(w, r)
+	cer, found := flow.Get("enrollResponse")
+	if !found {
+		s.renderError(w, r, expiredTransactionError())
+		return
+	}
 	enrollResponse := cer.(*idx.EnrollmentResponse)
 	if !enrollResponse.HasStep(idx.EnrollmentStepOktaVerifyInit) {
-		s.ViewData["Errors"] = "Missing enrollment step Okta Verify"
+		if session, err := sessionStore.Get(r, "direct-auth"); err == nil {
+			session.Values["Errors"] = "Missing enrollment step Okta Verify"
+			session.Save(r, w)
+		}
 		http.Redirect(w, r, "/enrollFactor", http.StatusFound)
 		return
 	}
@@ -448,30 +421,41 @@ func (s *Server) handleEnrollOktaVerifySMSNumber(w http.ResponseWriter, r *http.
 	decoder := json.NewDecoder(r.Body)
 	err := decoder.Decode(&data)
 	if err != nil {
-		panic(err)
+		s.renderError(w, r, invalidInputError("The request body is not valid JSON", err))
+		return
 	}
-	phoneNumber, found := data["phoneNumber"]
-	if !found {
-		panic(data)
+	phoneNumber, ok := data["phoneNumber"].(string)
+	if !ok {
+		s.renderError(w, r, invalidInputError("A phone number is required", nil))
+		return
 	}
 
-	enrollResponse, err = enrollResponse.OktaVerifySMSInit(r.Context(), phoneNumber.(string))
+	start := time.Now()
+	enrollResponse, err = enrollResponse.OktaVerifySMSInit(r.Context(), phoneNumber)
+	observeEnrollmentStep(idx.EnrollmentStepEnrollmentChannelData, start, err)
 	if err != nil {
-		log.Fatalf("okta verify error: %s", err)
+		s.renderError(w, r, upstreamError(err))
+		return
 	}
-	s.cache.Set("enrollResponse", enrollResponse, time.Minute*5)
+	flow.Set("enrollResponse", enrollResponse, time.Minute*5)
 
 	w.WriteHeader(http.StatusOK)
 }
 
 func (s *Server) handleEnrollOktaVerifySMS(w http.ResponseWriter, r *http.Request) {
+	flow := s.flowState(w, r)
 	// we don't need to poll anylonger if enrollment step enroll poll is missing  and enroll okta verify is missing
-	cer, _ := s.cache.Get("enrollResponse")
+	cer, found := flow.Get("enrollResponse")
+	if !found {
+		s.renderError(w, r, expiredTransactionError())
+		return
+	}
 	enrollResponse := cer.(*idx.EnrollmentResponse)
 	_, continuePolling, err := enrollResponse.OktaVerifyContinuePolling(r.Context())
-	s.cache.Set("enrollResponse", enrollResponse, time.Minute*5)
+	flow.Set("enrollResponse", enrollResponse, time.Minute*5)
 	if err != nil {
-		log.Fatalf("okta verify confirmation error: %s", err)
+		s.renderError(w, r, upstreamError(err))
+		return
 	}
 
 	data := struct {
@@ -479,34 +463,51 @@ func (s *Server) handleEnrollOktaVerifySMS(w http.ResponseWriter, r *http.Reques
 		Next            string
 	}{continuePolling, "/enrollFactor"}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
 	resp, err := json.Marshal(data)
 	if err != nil {
-		log.Fatalf("Error happened in JSON marshal. Err: %s", err)
+		s.renderError(w, r, internalError(err))
+		return
 	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
 	w.Write(resp)
 }
 
 func (s *Server) enrollOktaVerifyEmail(w http.ResponseWriter, r *http.Request) {
-	cer, _ := s.cache.Get("enrollResponse")
+	flow := s.flowState(w, r)
+	cer, found := flow.Get("enrollResponse")
+	if !found {
+		s.renderError(w, r, expiredTransactionError())
+		return
+	}
 	enrollResponse := cer.(*idx.EnrollmentResponse)
 	if !enrollResponse.HasStep(idx.EnrollmentStepOktaVerifyInit) {
-		s.ViewData["Errors"] = "Missing enrollment step Okta Verify"
+		if session, err := sessionStore.Get(r, "direct-auth"); err == nil {
+			session.Values["Errors"] = "Missing enrollment step Okta Verify"
+			session.Save(r, w)
+		}
 		http.Redirect(w, r, "/enrollFactor", http.StatusFound)
 		return
 	}
 
-	s.cache.Set("enrollResponse", enrollResponse, time.Minute*5)
+	flow.Set("enrollResponse", enrollResponse, time.Minute*5)
 
-	s.render("enrollOktaVerifyEmail.gohtml", w, r)
+	s.render("enrollOktaVerifyEmail.gohtml", w, r, &ViewData{})
 }
 
 func (s *Server) handleEnrollOktaVerifyEmailAddress(w http.ResponseWriter, r *http.Request) {
-	cer, _ := s.cache.Get("enrollResponse")
+	flow := s.flowState(w, r)
+	cer, found := flow.Get("enrollResponse")
+	if !found {
+		s.renderError(w, r, expiredTransactionError())
+		return
+	}
 	enrollResponse := cer.(*idx.EnrollmentResponse)
 	if !enrollResponse.HasStep(idx.EnrollmentStepOktaVerifyInit) {
-		s.ViewData["Errors"] = "Missing enrollment step Okta Verify"
+		if session, err := sessionStore.Get(r, "direct-auth"); err == nil {
+			session.Values["Errors"] = "Missing enrollment step Okta Verify"
+			session.Save(r, w)
+		}
 		http.Redirect(w, r, "/enrollFactor", http.StatusFound)
 		return
 	}
@@ -515,30 +516,41 @@ func (s *Server) handleEnrollOktaVerifyEmailAddress(w http.ResponseWriter, r *ht
 	decoder := json.NewDecoder(r.Body)
 	err := decoder.Decode(&data)
 	if err != nil {
-		panic(err)
+		s.renderError(w, r, invalidInputError("The request body is not valid JSON", err))
+		return
 	}
-	email, found := data["email"]
-	if !found {
-		panic(data)
+	email, ok := data["email"].(string)
+	if !ok {
+		s.renderError(w, r, invalidInputError("An email address is required", nil))
+		return
 	}
 
-	enrollResponse, err = enrollResponse.OktaVerifyEmailInit(r.Context(), email.(string))
+	start := time.Now()
+	enrollResponse, err = enrollResponse.OktaVerifyEmailInit(r.Context(), email)
+	observeEnrollmentStep(idx.EnrollmentStepEnrollmentChannelData, start, err)
 	if err != nil {
-		log.Fatalf("okta verify error: %s", err)
+		s.renderError(w, r, upstreamError(err))
+		return
 	}
-	s.cache.Set("enrollResponse", enrollResponse, time.Minute*5)
+	flow.Set("enrollResponse", enrollResponse, time.Minute*5)
 
 	w.WriteHeader(http.StatusOK)
 }
 
 func (s *Server) handleEnrollOktaVerifyEmail(w http.ResponseWriter, r *http.Request) {
+	flow := s.flowState(w, r)
 	// we don't need to poll anylonger if enrollment step enroll poll is missing  and enroll okta verify is missing
-	cer, _ := s.cache.Get("enrollResponse")
+	cer, found := flow.Get("enrollResponse")
+	if !found {
+		s.renderError(w, r, expiredTransactionError())
+		return
+	}
 	enrollResponse := cer.(*idx.EnrollmentResponse)
 	_, continuePolling, err := enrollResponse.OktaVerifyContinuePolling(r.Context())
-	s.cache.Set("enrollResponse", enrollResponse, time.Minute*5)
+	flow.Set("enrollResponse", enrollResponse, time.Minute*5)
 	if err != nil {
-		log.Fatalf("okta verify confirmation error: %s", err)
+		s.renderError(w, r, upstreamError(err))
+		return
 	}
 
 	data := struct {
@@ -546,17 +558,19 @@ func (s *Server) handleEnrollOktaVerifyEmail(w http.ResponseWriter, r *http.Requ
 		Next            string
 	}{continuePolling, "/enrollFactor"}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
 	resp, err := json.Marshal(data)
 	if err != nil {
-		log.Fatalf("Error happened in JSON marshal. Err: %s", err)
+		s.renderError(w, r, internalError(err))
+		return
 	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
 	w.Write(resp)
 }
 
 func (s *Server) enrollGoogleAuth(w http.ResponseWriter, r *http.Request) {
-	cer, _ := s.cache.Get("enrollResponse")
+	flow := s.flowState(w, r)
+	cer, _ := flow.Get("enrollResponse")
 	if cer == nil {
 		http.Redirect(w, r, "/", http.StatusFound)
 		return
@@ -566,241 +580,17 @@ func (s *Server) enrollGoogleAuth(w http.ResponseWriter, r *http.Request) {
 		http.Redirect(w, r, "/enrollFactor", http.StatusFound)
 		return
 	}
+	start := time.Now()
 	enrollResponse, err := enrollResponse.GoogleAuthInit(r.Context())
+	observeEnrollmentStep(idx.EnrollmentStepGoogleAuthenticatorInit, start, err)
 	if err != nil {
 		http.Redirect(w, r, "/enrollFactor", http.StatusFound)
 		return
 	}
-	s.cache.Set("enrollResponse", enrollResponse, time.Minute*5)
-	s.ViewData["QRCode"] = template.URL(enrollResponse.ContextualData().QRcode.Href)
-	s.ViewData["SharedSecret"] = template.URL(enrollResponse.ContextualData().SharedSecret)
-	s.render("enrollGoogleAuth.gohtml", w, r)
-}
-
-func (s *Server) enrollSecurityQuestion(w http.ResponseWriter, r *http.Request) {
-	cer, _ := s.cache.Get("enrollResponse")
-	if cer == nil {
-		http.Redirect(w, r, "/", http.StatusFound)
-		return
-	}
-	enrollResponse := cer.(*idx.EnrollmentResponse)
-	if !enrollResponse.HasStep(idx.EnrollmentStepSecurityQuestionOptions) {
-		http.Redirect(w, r, "/enrollFactor", http.StatusFound)
-		return
-	}
-	enrollResponse, questions, err := enrollResponse.SecurityQuestionOptions(r.Context())
-	if err != nil {
-		http.Redirect(w, r, "/enrollFactor", http.StatusFound)
-		return
-	}
-	s.cache.Set("enrollResponse", enrollResponse, time.Minute*5)
-	s.ViewData["Questions"] = questions
-	s.render("enrollSecurityQuestion.gohtml", w, r)
-}
-
-func (s *Server) handleEnrollSecurityQuestion(w http.ResponseWriter, r *http.Request) {
-	cer, _ := s.cache.Get("enrollResponse")
-	if cer == nil {
-		http.Redirect(w, r, "/", http.StatusFound)
-		return
-	}
-	enrollResponse := cer.(*idx.EnrollmentResponse)
-	if !enrollResponse.HasStep(idx.EnrollmentStepSecurityQuestionSetup) {
-		http.Redirect(w, r, "/enrollFactor", http.StatusFound)
-		return
-	}
-
-	session, err := sessionStore.Get(r, "direct-auth")
-	if err != nil {
-		log.Fatalf("could not get store: %s", err)
-	}
-
-	sq := idx.SecurityQuestion{
-		QuestionKey: r.FormValue("question"),
-		Question:    r.FormValue("custom_question"),
-		Answer:      r.FormValue("answer"),
-	}
-
-	enrollResponse, err = enrollResponse.SetupSecurityQuestion(r.Context(), &sq)
-	if err != nil {
-		session.Values["Errors"] = err.Error()
-		session.Save(r, w)
-		http.Redirect(w, r, "/enrollSecurityQuestion", http.StatusFound)
-		return
-	}
-	// If we have tokens we have success, so lets store tokens
-	if enrollResponse.Token() != nil {
-		session, err := sessionStore.Get(r, "direct-auth")
-		if err != nil {
-			log.Fatalf("could not get store: %s", err)
-		}
-		session.Values["access_token"] = enrollResponse.Token().AccessToken
-		session.Values["id_token"] = enrollResponse.Token().IDToken
-		err = session.Save(r, w)
-		if err != nil {
-			log.Fatalf("could not save access token: %s", err)
-		}
-		// redirect the user to /profile
-		http.Redirect(w, r, "/", http.StatusFound)
-		return
-	}
-	enrollResponse, err = enrollResponse.WhereAmI(r.Context())
-	if err != nil {
-		session.Values["Errors"] = err.Error()
-		session.Save(r, w)
-		http.Redirect(w, r, "/login", http.StatusFound)
-		return
-	}
-	s.cache.Set("enrollResponse", enrollResponse, time.Minute*5)
-	http.Redirect(w, r, "/enrollFactor", http.StatusFound)
-}
-
-func (s *Server) enrollWebAuthN(w http.ResponseWriter, r *http.Request) {
-	cer, _ := s.cache.Get("enrollResponse")
-	if cer == nil {
-		http.Redirect(w, r, "/", http.StatusFound)
-		return
-	}
-	enrollResponse := cer.(*idx.EnrollmentResponse)
-	if !enrollResponse.HasStep(idx.EnrollmentStepWebAuthNSetup) {
-		http.Redirect(w, r, "/enrollFactor", http.StatusFound)
-		return
-	}
-	enrollResponse, err := enrollResponse.WebAuthNSetup(r.Context())
-	if err != nil {
-		http.Redirect(w, r, "/enrollFactor", http.StatusFound)
-		return
-	}
-	s.cache.Set("enrollResponse", enrollResponse, time.Minute*5)
-
-	s.ViewData["Challenge"] = enrollResponse.ContextualData().ActivationData.Challenge
-	s.ViewData["UserID"] = enrollResponse.ContextualData().ActivationData.User.ID
-	s.ViewData["Username"] = enrollResponse.ContextualData().ActivationData.User.Name
-	s.ViewData["DisplayName"] = enrollResponse.ContextualData().ActivationData.User.DisplayName
-	s.render("enrollWebAuthN.gohtml", w, r)
-}
-
-func (s *Server) handleEnrollWebAuthN(w http.ResponseWriter, r *http.Request) {
-	cer, _ := s.cache.Get("enrollResponse")
-	if cer == nil {
-		http.Redirect(w, r, "/", http.StatusFound)
-		return
-	}
-	enrollResponse := cer.(*idx.EnrollmentResponse)
-	if !enrollResponse.HasStep(idx.EnrollmentStepWebAuthNVerify) {
-		http.Redirect(w, r, "/enrollWebAuthN", http.StatusFound)
-		return
+	flow.Set("enrollResponse", enrollResponse, time.Minute*5)
+	data := &googleAuthView{
+		QRCode:       template.URL(enrollResponse.ContextualData().QRcode.Href),
+		SharedSecret: template.URL(enrollResponse.ContextualData().SharedSecret),
 	}
-
-	session, err := sessionStore.Get(r, "direct-auth")
-	if err != nil {
-		log.Fatalf("could not get store: %s", err)
-	}
-
-	reqBody, err := ioutil.ReadAll(r.Body)
-	if err != nil {
-		log.Fatalf("could not read request body: %v", err)
-	}
-	defer r.Body.Close()
-	var credentials idx.WebAuthNVerifyCredentials
-	if err := json.Unmarshal(reqBody, &credentials); err != nil {
-		log.Fatalf("could not unmarshal request body: %v", err)
-	}
-
-	enrollResponse, err = enrollResponse.WebAuthNVerify(r.Context(), &credentials)
-	if err != nil {
-		session.Values["Errors"] = err.Error()
-		session.Save(r, w)
-		http.Redirect(w, r, "/enrollWebAuthN", http.StatusFound)
-		return
-	}
-	// If we have tokens we have success, so lets store tokens
-	if enrollResponse.Token() != nil {
-		session, err := sessionStore.Get(r, "direct-auth")
-		if err != nil {
-			log.Fatalf("could not get store: %s", err)
-		}
-		session.Values["access_token"] = enrollResponse.Token().AccessToken
-		session.Values["id_token"] = enrollResponse.Token().IDToken
-		err = session.Save(r, w)
-		if err != nil {
-			log.Fatalf("could not save access token: %s", err)
-		}
-		// redirect the user to /profile
-		http.Redirect(w, r, "/", http.StatusFound)
-		return
-	}
-	enrollResponse, err = enrollResponse.WhereAmI(r.Context())
-	if err != nil {
-		session.Values["Errors"] = err.Error()
-		session.Save(r, w)
-		http.Redirect(w, r, "/login", http.StatusFound)
-		return
-	}
-	s.cache.Set("enrollResponse", enrollResponse, time.Minute*5)
-	http.Redirect(w, r, "/enrollFactor", http.StatusFound)
-}
-
-func (s *Server) handleEnrollGoogleAuthQRCode(w http.ResponseWriter, r *http.Request) {
-	cer, _ := s.cache.Get("enrollResponse")
-	if cer == nil {
-		http.Redirect(w, r, "/", http.StatusFound)
-		return
-	}
-	enrollResponse := cer.(*idx.EnrollmentResponse)
-	if !enrollResponse.HasStep(idx.EnrollmentStepGoogleAuthenticatorConfirmation) {
-		http.Redirect(w, r, "/enrollGoogleAuth", http.StatusFound)
-		return
-	}
-	s.render("enrollGoogleAuthCode.gohtml", w, r)
-}
-
-func (s *Server) handleEnrollGoogleAuthCode(w http.ResponseWriter, r *http.Request) {
-	cer, _ := s.cache.Get("enrollResponse")
-	if cer == nil {
-		http.Redirect(w, r, "/", http.StatusFound)
-		return
-	}
-	enrollResponse := cer.(*idx.EnrollmentResponse)
-	if !enrollResponse.HasStep(idx.EnrollmentStepGoogleAuthenticatorConfirmation) {
-		http.Redirect(w, r, "/enrollGoogleAuth", http.StatusFound)
-		return
-	}
-
-	session, err := sessionStore.Get(r, "direct-auth")
-	if err != nil {
-		log.Fatalf("could not get store: %s", err)
-	}
-	enrollResponse, err = enrollResponse.GoogleAuthConfirm(r.Context(), r.FormValue("code"))
-	if err != nil {
-		session.Values["Errors"] = err.Error()
-		session.Save(r, w)
-		s.render("enrollGoogleAuthCode.gohtml", w, r)
-		return
-	}
-	// If we have tokens we have success, so lets store tokens
-	if enrollResponse.Token() != nil {
-		session, err := sessionStore.Get(r, "direct-auth")
-		if err != nil {
-			log.Fatalf("could not get store: %s", err)
-		}
-		session.Values["access_token"] = enrollResponse.Token().AccessToken
-		session.Values["id_token"] = enrollResponse.Token().IDToken
-		err = session.Save(r, w)
-		if err != nil {
-			log.Fatalf("could not save access token: %s", err)
-		}
-		// redirect the user to /profile
-		http.Redirect(w, r, "/", http.StatusFound)
-		return
-	}
-	enrollResponse, err = enrollResponse.WhereAmI(r.Context())
-	if err != nil {
-		session.Values["Errors"] = err.Error()
-		session.Save(r, w)
-		http.Redirect(w, r, "/login", http.StatusFound)
-		return
-	}
-	s.cache.Set("enrollResponse", enrollResponse, time.Minute*5)
-	http.Redirect(w, r, "/enrollFactor", http.StatusFound)
+	s.render("enrollGoogleAuth.gohtml", w, r, data)
 }
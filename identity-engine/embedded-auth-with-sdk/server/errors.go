@@ -0,0 +1,174 @@
+/**
+ * Copyright 2021 - Present Okta, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package server
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+)
+
+type errorKind int
+
+const (
+	errorKindInternal errorKind = iota
+	errorKindUpstream
+	errorKindExpiredTransaction
+	errorKindInvalidInput
+	errorKindNotFound
+	errorKindConflict
+	errorKindForbidden
+)
+
+// appError is an error a handler can't recover from by redirecting. It is
+// rendered on the error page instead of taking the whole server down.
+type appError struct {
+	kind    errorKind
+	message string
+	err     error
+	// steps are the steps the flow can take instead, for the JSON API.
+	steps []string
+}
+
+func (e *appError) Error() string {
+	if e.err == nil {
+		return e.message
+	}
+	return fmt.Sprintf("%s: %s", e.message, e.err)
+}
+
+func (e *appError) Unwrap() error {
+	return e.err
+}
+
+// StatusCode is the HTTP status the error page is served with.
+func (e *appError) StatusCode() int {
+	switch e.kind {
+	case errorKindUpstream:
+		return http.StatusBadGateway
+	case errorKindExpiredTransaction:
+		return http.StatusGone
+	case errorKindInvalidInput:
+		return http.StatusBadRequest
+	case errorKindNotFound:
+		return http.StatusNotFound
+	case errorKindConflict:
+		return http.StatusConflict
+	case errorKindForbidden:
+		return http.StatusForbidden
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// upstreamError is returned when Okta, or the IdP behind it, rejects a call.
+func upstreamError(err error) *appError {
+	return &appError{
+		kind:    errorKindUpstream,
+		message: "The identity provider could not complete the request",
+		err:     err,
+	}
+}
+
+// expiredTransactionError is returned when the caller's idx flow state is
+// missing, usually because it timed out or the user hit a step out of order.
+func expiredTransactionError() *appError {
+	return &appError{
+		kind:    errorKindExpiredTransaction,
+		message: "Your transaction has expired or was never started, please start again",
+	}
+}
+
+func invalidInputError(message string, err error) *appError {
+	return &appError{
+		kind:    errorKindInvalidInput,
+		message: message,
+		err:     err,
+	}
+}
+
+func notFoundError(message string) *appError {
+	return &appError{
+		kind:    errorKindNotFound,
+		message: message,
+	}
+}
+
+// forbiddenError is returned when a request fails the CSRF checks.
+func forbiddenError(message string) *appError {
+	return &appError{
+		kind:    errorKindForbidden,
+		message: message,
+	}
+}
+
+func internalError(err error) *appError {
+	return &appError{
+		kind:    errorKindInternal,
+		message: "Something went wrong on our side, please try again",
+		err:     err,
+	}
+}
+
+type errorView struct {
+	ViewData
+	StatusCode int
+	StatusText string
+}
+
+// renderError logs err with the request it belongs to and serves the error
+// page. Errors that aren't an appError are treated as internal errors.
+func (s *Server) renderError(w http.ResponseWriter, r *http.Request, err error) {
+	ae := s.logError(r, err)
+	data := &errorView{
+		ViewData:   ViewData{Errors: ae.message},
+		StatusCode: ae.StatusCode(),
+		StatusText: http.StatusText(ae.StatusCode()),
+	}
+	s.renderStatus("error.gohtml", ae.StatusCode(), w, r, data)
+}
+
+// logError logs err with the request it belongs to and returns it as an
+// appError.
+func (s *Server) logError(r *http.Request, err error) *appError {
+	var ae *appError
+	if !errors.As(err, &ae) {
+		ae = internalError(err)
+	}
+
+	txID := ""
+	if session, err := sessionStore.Get(r, "direct-auth"); err == nil {
+		txID, _ = session.Values[transactionIDKey].(string)
+	}
+	log.Printf("ERROR: %s %s (remote %s, transaction %q): %s", r.Method, r.URL.Path, r.RemoteAddr, txID, ae)
+	return ae
+}
+
+// flashError logs err and shows its message on the next page the user is
+// redirected to.
+func (s *Server) flashError(w http.ResponseWriter, r *http.Request, err error) {
+	ae := s.logError(r, err)
+	session, err := sessionStore.Get(r, "direct-auth")
+	if err == nil {
+		session.Values["Errors"] = ae.message
+		err = session.Save(r, w)
+	}
+	if err != nil {
+		log.Printf("could not flash the error: %s", err)
+	}
+}
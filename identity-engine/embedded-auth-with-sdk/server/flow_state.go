@@ -0,0 +1,99 @@
+/**
+ * Copyright 2021 - Present Okta, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/patrickmn/go-cache"
+)
+
+// transactionIDKey is the direct-auth session value holding the id of the
+// caller's in-flight IDX transaction.
+const transactionIDKey = "transaction_id"
+
+// flowState scopes the server's cache to a single browser session so that
+// concurrent users never see each other's login, enrollment or password reset
+// responses.
+type flowState struct {
+	transactionID string
+	cache         *cache.Cache
+}
+
+// flowState returns the IDX flow state of the caller, starting a new
+// transaction in the direct-auth session if the browser doesn't have one yet.
+func (s *Server) flowState(w http.ResponseWriter, r *http.Request) *flowState {
+	session, err := sessionStore.Get(r, "direct-auth")
+	if err != nil {
+		log.Printf("could not get store: %s", err)
+	}
+
+	txID, _ := session.Values[transactionIDKey].(string)
+	if txID == "" {
+		txID = generateTransactionID()
+		session.Values[transactionIDKey] = txID
+		if err := session.Save(r, w); err != nil {
+			log.Printf("could not save transaction id: %s", err)
+		}
+	}
+
+	return &flowState{
+		transactionID: txID,
+		cache:         s.cache,
+	}
+}
+
+func (fs *flowState) Get(key string) (interface{}, bool) {
+	return fs.cache.Get(fs.key(key))
+}
+
+func (fs *flowState) Set(key string, value interface{}, d time.Duration) {
+	fs.cache.Set(fs.key(key), value, d)
+}
+
+func (fs *flowState) Delete(key string) {
+	fs.cache.Delete(fs.key(key))
+}
+
+// Clear removes everything stored for the transaction, leaving other
+// sessions' flows untouched.
+func (fs *flowState) Clear() {
+	prefix := fs.key("")
+	for k := range fs.cache.Items() {
+		if strings.HasPrefix(k, prefix) {
+			fs.cache.Delete(k)
+		}
+	}
+}
+
+func (fs *flowState) key(name string) string {
+	return fs.transactionID + ":" + name
+}
+
+func generateTransactionID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(fmt.Sprintf("could not generate transaction id: %s", err))
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,145 @@
+/**
+ * Copyright 2021 - Present Okta, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/patrickmn/go-cache"
+)
+
+// browser keeps the cookies a server gave it, like a browser does.
+type browser struct {
+	cookies map[string]*http.Cookie
+}
+
+func newBrowser() *browser {
+	return &browser{cookies: map[string]*http.Cookie{}}
+}
+
+func (b *browser) request(method, path string) *http.Request {
+	r := httptest.NewRequest(method, path, nil)
+	for _, c := range b.cookies {
+		r.AddCookie(c)
+	}
+	return r
+}
+
+func (b *browser) keep(w *httptest.ResponseRecorder) {
+	for _, c := range w.Result().Cookies() {
+		b.cookies[c.Name] = c
+	}
+}
+
+// flowStateOf returns the flow state of the browser's session.
+func (b *browser) flowStateOf(s *Server) *flowState {
+	w := httptest.NewRecorder()
+	fs := s.flowState(w, b.request(http.MethodGet, "/"))
+	b.keep(w)
+	return fs
+}
+
+func TestFlowStateConcurrentSessions(t *testing.T) {
+	s := &Server{cache: cache.New(time.Minute, time.Minute)}
+	alice, bob := newBrowser(), newBrowser()
+
+	aliceFlow := alice.flowStateOf(s)
+	bobFlow := bob.flowStateOf(s)
+	if aliceFlow.transactionID == "" || aliceFlow.transactionID == bobFlow.transactionID {
+		t.Fatalf("sessions got transactions %q and %q", aliceFlow.transactionID, bobFlow.transactionID)
+	}
+
+	aliceFlow.Set("loginResponse", "alice's login", time.Minute)
+	bobFlow.Set("loginResponse", "bob's login", time.Minute)
+
+	// Later requests of a browser find the state of its own flow
+	for _, tt := range []struct {
+		browser *browser
+		want    string
+	}{
+		{browser: alice, want: "alice's login"},
+		{browser: bob, want: "bob's login"},
+	} {
+		got, found := tt.browser.flowStateOf(s).Get("loginResponse")
+		if !found || got != tt.want {
+			t.Errorf("Get() = %v, %v, want %q", got, found, tt.want)
+		}
+	}
+
+	aliceFlow.Delete("loginResponse")
+	if _, found := aliceFlow.Get("loginResponse"); found {
+		t.Error("Delete() left the value")
+	}
+	if got, _ := bobFlow.Get("loginResponse"); got != "bob's login" {
+		t.Errorf("Delete() of another session removed %q", "bob's login")
+	}
+}
+
+func TestFlowStateClear(t *testing.T) {
+	s := &Server{cache: cache.New(time.Minute, time.Minute)}
+	alice, bob := newBrowser(), newBrowser()
+	aliceFlow, bobFlow := alice.flowStateOf(s), bob.flowStateOf(s)
+	for _, fs := range []*flowState{aliceFlow, bobFlow} {
+		fs.Set("loginResponse", "login", time.Minute)
+		fs.Set("enrollResponse", "enrollment", time.Minute)
+	}
+	s.cache.Set("unscoped", "value", time.Minute)
+
+	aliceFlow.Clear()
+
+	for _, key := range []string{"loginResponse", "enrollResponse"} {
+		if _, found := aliceFlow.Get(key); found {
+			t.Errorf("Clear() left %s", key)
+		}
+		if _, found := bobFlow.Get(key); !found {
+			t.Errorf("Clear() removed %s of another session", key)
+		}
+	}
+	if _, found := s.cache.Get("unscoped"); !found {
+		t.Error("Clear() removed a value outside of the flow")
+	}
+}
+
+func TestLogoutClearsOnlyTheCallersFlow(t *testing.T) {
+	s := &Server{cache: cache.New(time.Minute, time.Minute)}
+	alice, bob := newBrowser(), newBrowser()
+	aliceFlow, bobFlow := alice.flowStateOf(s), bob.flowStateOf(s)
+	aliceFlow.Set("loginResponse", "alice's login", time.Minute)
+	bobFlow.Set("loginResponse", "bob's login", time.Minute)
+
+	w := httptest.NewRecorder()
+	s.handleLogout(w, alice.request(http.MethodPost, "/logout"))
+	alice.keep(w)
+
+	if w.Code != http.StatusFound || w.Header().Get("Location") != "/" {
+		t.Fatalf("logout answered %d to %q: %s", w.Code, w.Header().Get("Location"), w.Body)
+	}
+	if _, found := aliceFlow.Get("loginResponse"); found {
+		t.Error("logout left the caller's login")
+	}
+	if got, _ := bob.flowStateOf(s).Get("loginResponse"); got != "bob's login" {
+		t.Errorf("logout removed another session's login, it has %v", got)
+	}
+
+	// Signing in again starts a new transaction
+	if next := alice.flowStateOf(s); next.transactionID == aliceFlow.transactionID {
+		t.Error("the session kept its transaction after logout")
+	}
+}
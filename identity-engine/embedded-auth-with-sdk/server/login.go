@@ -1,55 +1,93 @@
 package server
 
 import (
-	"encoding/json"
 	"fmt"
 	"html/template"
 	"io/ioutil"
-	"log"
 	"net/http"
 	"net/url"
 	"strings"
 	"time"
 
 	idx "github.com/okta/okta-idx-golang"
+
+	"github.com/okta/samples-golang/discovery"
 )
 
 // BEGIN: Login
 func (s *Server) login(w http.ResponseWriter, r *http.Request) {
-	s.cache.Delete("loginResponse")
+	s.renderLogin(w, r, false)
+}
+
+// loginPasswordless is the identifier-first login page, it only asks for the
+// username and the user then picks one of the authenticators the policy
+// offers, like an email magic link, Okta Verify push or WebAuthn.
+func (s *Server) loginPasswordless(w http.ResponseWriter, r *http.Request) {
+	s.renderLogin(w, r, true)
+}
+
+func (s *Server) renderLogin(w http.ResponseWriter, r *http.Request, passwordless bool) {
+	flow := s.flowState(w, r)
+	flow.Delete("loginResponse")
 	// Initialize the login so we can see if there are Social IDP's to display
+	loginsStarted.Inc()
 	lr, err := s.idxClient.InitLogin(r.Context())
 	if err != nil {
-		log.Fatalf("Could not initalize login: %s", err.Error())
+		s.renderError(w, r, upstreamError(err))
+		return
 	}
 
 	// Store the login response in cache to use in the handler
-	s.cache.Set("loginResponse", lr, time.Minute*5)
+	flow.Set("loginResponse", lr, time.Minute*5)
 
-	// Set IDP's in the ViewData to iterate over.
+	// Set IDP's in the view data to iterate over.
 	idps := lr.IdentityProviders()
-	s.ViewData["IDPs"] = idps
-	s.ViewData["IdpCount"] = func() int {
-		return len(idps)
+	data := &loginView{
+		Passwordless: passwordless,
+		IDPs:         idps,
+		IdpCount: func() int {
+			return len(idps)
+		},
 	}
 
 	// Render the login page
-	s.render("login.gohtml", w, r)
+	s.render("login.gohtml", w, r, data)
+}
+
+// handleLogout signs the caller out, discarding their session's tokens and
+// in-flight IDX state.
+func (s *Server) handleLogout(w http.ResponseWriter, r *http.Request) {
+	// only the caller's in-flight idx state is discarded, other sessions keep theirs
+	s.flowState(w, r).Clear()
+
+	session, err := sessionStore.Get(r, "direct-auth")
+	if err == nil {
+		revokeErr := s.logout(r)
+		delete(session.Values, "id_token")
+		delete(session.Values, "access_token")
+		delete(session.Values, "Errors")
+		delete(session.Values, transactionIDKey)
+		session.Save(r, w)
+		if revokeErr != nil {
+			// the session is gone either way, but the token may still be live
+			s.renderError(w, r, upstreamError(revokeErr))
+			return
+		}
+	}
+
+	http.Redirect(w, r, "/", http.StatusFound)
 }
 
 // logout revokes the oauth2 token server side
-func (s *Server) logout(r *http.Request) {
+func (s *Server) logout(r *http.Request) error {
 	session, err := sessionStore.Get(r, "direct-auth")
 	if err != nil || session.Values["access_token"] == nil || session.Values["access_token"] == "" {
-		return
+		return nil
 	}
 
-	var revokeTokenUrl string
-	issuer := s.idxClient.Config().Okta.IDX.Issuer
-	if strings.Contains(issuer, "oauth2") {
-		revokeTokenUrl = issuer + "/v1/revoke"
-	} else {
-		revokeTokenUrl = issuer + "/oauth2/v1/revoke"
+	oidc, err := discovery.Get(r.Context(), s.idxClient.Config().Okta.IDX.Issuer)
+	if err != nil {
+		return err
 	}
 
 	form := url.Values{}
@@ -57,7 +95,10 @@ func (s *Server) logout(r *http.Request) {
 	form.Set("token_type_hint", "access_token")
 	form.Set("client_id", s.idxClient.Config().Okta.IDX.ClientID)
 	form.Set("client_secret", s.idxClient.Config().Okta.IDX.ClientSecret)
-	req, _ := http.NewRequest("POST", revokeTokenUrl, strings.NewReader(form.Encode()))
+	req, err := http.NewRequestWithContext(r.Context(), "POST", oidc.RevocationEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
 	h := req.Header
 	h.Add("Accept", "application/json")
 	h.Add("Content-Type", "application/x-www-form-urlencoded")
@@ -65,17 +106,18 @@ func (s *Server) logout(r *http.Request) {
 	client := &http.Client{Timeout: time.Second * 30}
 	resp, err := client.Do(req)
 	if err != nil {
-		body, _ := ioutil.ReadAll(resp.Body)
-		fmt.Printf("revoke error; status: %s, body: %s\n", resp.Status, string(body))
+		return err
 	}
 	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("revoke error; status: %s, body: %s", resp.Status, string(body))
+	}
+	return nil
 }
 
 func (s *Server) handleLogin(w http.ResponseWriter, r *http.Request) {
-	clr, _ := s.cache.Get("loginResponse")
-	s.cache.Delete("loginResponse")
-	lr := clr.(*idx.LoginResponse)
-
 	// PUll data from the web form and create your identify request
 	// THis is used in the Identify step
 	ir := &idx.IdentifyRequest{
@@ -84,51 +126,86 @@ func (s *Server) handleLogin(w http.ResponseWriter, r *http.Request) {
 			Password: r.FormValue("password"),
 		},
 	}
+	s.identify(w, r, ir, "/login")
+}
+
+// handleLoginPasswordless identifies the user by the username alone. The SDK
+// answers a password challenge offered after the username by itself, with
+// the empty password here, so this only works when the policy doesn't offer
+// the Password authenticator at all.
+func (s *Server) handleLoginPasswordless(w http.ResponseWriter, r *http.Request) {
+	s.identify(w, r, &idx.IdentifyRequest{Identifier: r.FormValue("identifier")}, "/login/passwordless")
+}
+
+// identify takes the identify step of the login, going back to the login
+// page at retry when Okta turns it down.
+func (s *Server) identify(w http.ResponseWriter, r *http.Request, ir *idx.IdentifyRequest, retry string) {
+	flow := s.flowState(w, r)
+	clr, found := flow.Get("loginResponse")
+	if !found {
+		s.renderError(w, r, expiredTransactionError())
+		return
+	}
+	flow.Delete("loginResponse")
+	lr := clr.(*idx.LoginResponse)
 
 	// Get session store so we can store our tokens
 	session, err := sessionStore.Get(r, "direct-auth")
 	if err != nil {
-		log.Fatalf("could not get store: %s", err)
+		s.renderError(w, r, internalError(err))
+		return
 	}
 
+	start := time.Now()
 	lr, err = lr.Identify(r.Context(), ir)
+	observeLoginStep(idx.LoginStepIdentify, start, err)
 	if err != nil {
 		session.Values["Errors"] = err.Error()
 		session.Save(r, w)
-		http.Redirect(w, r, "/login", http.StatusFound)
+		http.Redirect(w, r, retry, http.StatusFound)
 		return
 	}
 
 	// If we have tokens we have success, so lets store tokens
 	if lr.Token() != nil {
+		loginsCompleted.Inc()
 		session.Values["access_token"] = lr.Token().AccessToken
 		session.Values["id_token"] = lr.Token().IDToken
 		err = session.Save(r, w)
 		if err != nil {
-			log.Fatalf("could not save access token: %s", err)
+			s.renderError(w, r, internalError(err))
+			return
 		}
 		http.Redirect(w, r, "/", http.StatusFound)
 		return
 	}
 
-	s.cache.Set("loginResponse", lr, time.Minute*5)
+	flow.Set("loginResponse", lr, time.Minute*5)
 	http.Redirect(w, r, "/login/factors", http.StatusFound)
 }
 
 func (s *Server) handleLoginSecondaryFactors(w http.ResponseWriter, r *http.Request) {
-	clr, _ := s.cache.Get("loginResponse")
+	flow := s.flowState(w, r)
+	clr, found := flow.Get("loginResponse")
+	if !found {
+		s.renderError(w, r, expiredTransactionError())
+		return
+	}
 	lr := clr.(*idx.LoginResponse)
 	session, err := sessionStore.Get(r, "direct-auth")
 	if err != nil {
-		log.Fatalf("could not get store: %s", err)
+		s.renderError(w, r, internalError(err))
+		return
 	}
 	// If we have tokens we have success, so lets store tokens
 	if lr.Token() != nil {
+		loginsCompleted.Inc()
 		session.Values["access_token"] = lr.Token().AccessToken
 		session.Values["id_token"] = lr.Token().IDToken
 		err = session.Save(r, w)
 		if err != nil {
-			log.Fatalf("could not save access token: %s", err)
+			s.renderError(w, r, internalError(err))
+			return
 		}
 		http.Redirect(w, r, "/", http.StatusFound)
 		return
@@ -145,22 +222,29 @@ func (s *Server) handleLoginSecondaryFactors(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
-	s.ViewData["FactorEmail"] = lr.HasStep(idx.LoginStepEmailVerification)
-	s.ViewData["FactorPhone"] = lr.HasStep(idx.LoginStepPhoneVerification) || lr.HasStep(idx.LoginStepPhoneInitialVerification)
-	s.ViewData["FactorGoogleAuth"] = lr.HasStep(idx.LoginStepGoogleAuthenticatorInitialVerification) || lr.HasStep(idx.LoginStepGoogleAuthenticatorConfirmation)
-	s.ViewData["FactorOktaVerify"] = lr.HasStep(idx.LoginStepOktaVerify)
-	s.ViewData["FactorSkip"] = lr.HasStep(idx.LoginStepSkip)
-	s.ViewData["FactorWebAuthN"] = lr.HasStep(idx.LoginStepWebAuthNSetup) || lr.HasStep(idx.LoginStepWebAuthNChallenge)
-	s.ViewData["FactorSecurityQuestion"] = lr.HasStep(idx.LoginStepSecurityQuestionOptions)
+	data := &factorsView{
+		FactorEmail:            lr.HasStep(idx.LoginStepEmailVerification),
+		FactorPhone:            lr.HasStep(idx.LoginStepPhoneVerification) || lr.HasStep(idx.LoginStepPhoneInitialVerification),
+		FactorGoogleAuth:       lr.HasStep(idx.LoginStepGoogleAuthenticatorInitialVerification) || lr.HasStep(idx.LoginStepGoogleAuthenticatorConfirmation),
+		FactorOktaVerify:       lr.HasStep(idx.LoginStepOktaVerify),
+		FactorSkip:             lr.HasStep(idx.LoginStepSkip),
+		FactorWebAuthN:         lr.HasStep(idx.LoginStepWebAuthNSetup) || lr.HasStep(idx.LoginStepWebAuthNChallenge),
+		FactorSecurityQuestion: lr.HasStep(idx.LoginStepSecurityQuestionOptions),
+	}
 
-	s.render("loginSecondaryFactors.gohtml", w, r)
+	s.render("loginSecondaryFactors.gohtml", w, r, data)
 }
 
 func (s *Server) handleLoginSecondaryFactorsProceed(w http.ResponseWriter, r *http.Request) {
-	delete(s.ViewData, "InvalidEmailCode")
+	flow := s.flowState(w, r)
+	flow.Delete(loginEmailStep.rejectedKey())
 	submit := r.FormValue("submit")
 	if submit == "Skip" {
-		clr, _ := s.cache.Get("loginResponse")
+		clr, found := flow.Get("loginResponse")
+		if !found {
+			s.renderError(w, r, expiredTransactionError())
+			return
+		}
 		lr := clr.(*idx.LoginResponse)
 		s.loginTransitionToProfile(lr, w, r)
 		return
@@ -190,107 +274,33 @@ func (s *Server) handleLoginSecondaryFactorsProceed(w http.ResponseWriter, r *ht
 }
 
 func (s *Server) loginTransitionToProfile(er *idx.LoginResponse, w http.ResponseWriter, r *http.Request) {
+	flow := s.flowState(w, r)
 	session, err := sessionStore.Get(r, "direct-auth")
 	if err != nil {
-		log.Fatalf("could not get store: %s", err)
+		s.renderError(w, r, internalError(err))
+		return
 	}
 
+	start := time.Now()
 	lr, err := er.Skip(r.Context())
+	observeLoginStep(idx.LoginStepSkip, start, err)
 	if err != nil {
 		session.Values["Errors"] = err.Error()
 		session.Save(r, w)
 		http.Redirect(w, r, "/", http.StatusFound)
 		return
 	}
-	s.cache.Set("loginResponse", lr, time.Minute*5)
+	flow.Set("loginResponse", lr, time.Minute*5)
 
 	if lr.Token() != nil {
+		loginsCompleted.Inc()
 		session.Values["access_token"] = lr.Token().AccessToken
 		session.Values["id_token"] = lr.Token().IDToken
 		err = session.Save(r, w)
 		if err != nil {
-			log.Fatalf("could not save access token: %s", err)
-		}
-		// redirect the user to /profile
-		http.Redirect(w, r, "/", http.StatusFound)
-		return
-	}
-	lr, err = lr.WhereAmI(r.Context())
-	if err != nil {
-		session.Values["Errors"] = err.Error()
-		session.Save(r, w)
-		http.Redirect(w, r, "/login", http.StatusFound)
-		return
-	}
-	s.cache.Set("loginResponse", lr, time.Minute*5)
-	http.Redirect(w, r, "/login/factors", http.StatusFound)
-}
-
-func (s *Server) handleLoginEmailVerification(w http.ResponseWriter, r *http.Request) {
-	clr, _ := s.cache.Get("loginResponse")
-	lr := clr.(*idx.LoginResponse)
-	if !lr.HasStep(idx.LoginStepEmailVerification) {
-		http.Redirect(w, r, "/login/factors", http.StatusFound)
-		return
-	}
-	invCode, ok := s.ViewData["InvalidEmailCode"]
-	if !ok || !invCode.(bool) {
-		lr, err := lr.VerifyEmail(r.Context())
-		if err != nil {
-			http.Redirect(w, r, "/login", http.StatusFound)
+			s.renderError(w, r, internalError(err))
 			return
 		}
-		s.cache.Set("loginResponse", lr, time.Minute*5)
-	}
-
-	// set the idx state string in the session for inspection for otp login callback comparison.
-	session, err := sessionStore.Get(r, "direct-auth")
-	if err != nil {
-		log.Fatalf("could not get store: %s", err)
-	}
-	session.Values["idxContext.state"] = lr.Context().State
-	err = session.Save(r, w)
-	if err != nil {
-		log.Fatalf("could not save idx context state: %s", err)
-	}
-
-	s.render("loginFactorEmail.gohtml", w, r)
-}
-
-func (s *Server) handleLoginEmailConfirmation(w http.ResponseWriter, r *http.Request) {
-	clr, _ := s.cache.Get("loginResponse")
-	lr := clr.(*idx.LoginResponse)
-	if !lr.HasStep(idx.LoginStepEmailConfirmation) {
-		http.Redirect(w, r, "login/", http.StatusFound)
-		return
-	}
-	session, err := sessionStore.Get(r, "direct-auth")
-	if err != nil {
-		log.Fatalf("could not get store: %s", err)
-	}
-	lr, err = lr.ConfirmEmail(r.Context(), r.FormValue("code"))
-	if err != nil {
-		s.ViewData["InvalidEmailCode"] = true
-		session.Values["Errors"] = err.Error()
-		session.Save(r, w)
-		http.Redirect(w, r, "/login/factors/email", http.StatusFound)
-		return
-	}
-	s.cache.Set("loginResponse", lr, time.Minute*5)
-	s.ViewData["InvalidEmailCode"] = false
-
-	// If we have tokens we have success, so lets store tokens
-	if lr.Token() != nil {
-		session, err := sessionStore.Get(r, "direct-auth")
-		if err != nil {
-			log.Fatalf("could not get store: %s", err)
-		}
-		session.Values["access_token"] = lr.Token().AccessToken
-		session.Values["id_token"] = lr.Token().IDToken
-		err = session.Save(r, w)
-		if err != nil {
-			log.Fatalf("could not save access token: %s", err)
-		}
 		// redirect the user to /profile
 		http.Redirect(w, r, "/", http.StatusFound)
 		return
@@ -302,122 +312,55 @@ func (s *Server) handleLoginEmailConfirmation(w http.ResponseWriter, r *http.Req
 		http.Redirect(w, r, "/login", http.StatusFound)
 		return
 	}
-	s.cache.Set("loginResponse", lr, time.Minute*5)
+	flow.Set("loginResponse", lr, time.Minute*5)
 	http.Redirect(w, r, "/login/factors", http.StatusFound)
 }
 
-func (s *Server) handleLoginSecurityQuestion(w http.ResponseWriter, r *http.Request) {
-	clr, _ := s.cache.Get("loginResponse")
-	if clr == nil {
-		http.Redirect(w, r, "/", http.StatusFound)
-		return
-	}
-	lr := clr.(*idx.LoginResponse)
-	if !lr.HasStep(idx.LoginStepSecurityQuestionOptions) {
-		http.Redirect(w, r, "/login/factors", http.StatusFound)
-		return
-	}
-	lr, questions, err := lr.SecurityQuestionOptions(r.Context())
-	if err != nil {
-		http.Redirect(w, r, "/login/factors", http.StatusFound)
-		return
-	}
-	s.cache.Set("loginResponse", lr, time.Minute*5)
-	s.ViewData["Questions"] = questions
-	s.render("loginSetupSecurityQuestion.gohtml", w, r)
-}
-
-func (s *Server) handleLoginSecurityQuestionSetup(w http.ResponseWriter, r *http.Request) {
-	clr, _ := s.cache.Get("loginResponse")
-	if clr == nil {
-		http.Redirect(w, r, "/", http.StatusFound)
-		return
-	}
-	lr := clr.(*idx.LoginResponse)
-	if !lr.HasStep(idx.LoginStepSecurityQuestionSetup) {
-		http.Redirect(w, r, "/login/factors", http.StatusFound)
-		return
-	}
-
-	session, err := sessionStore.Get(r, "direct-auth")
-	if err != nil {
-		log.Fatalf("could not get store: %s", err)
-	}
-
-	sq := idx.SecurityQuestion{
-		QuestionKey: r.FormValue("question"),
-		Question:    r.FormValue("custom_question"),
-		Answer:      r.FormValue("answer"),
-	}
-	lr, err = lr.SecurityQuestionSetup(r.Context(), &sq)
-	if err != nil {
-		session.Values["Errors"] = err.Error()
-		session.Save(r, w)
-		http.Redirect(w, r, "/login/factors/security_question", http.StatusFound)
-		return
-	}
-	s.cache.Set("loginResponse", lr, time.Minute*5)
-
-	// If we have tokens we have success, so lets store tokens
-	if lr.Token() != nil {
-		session, err := sessionStore.Get(r, "direct-auth")
-		if err != nil {
-			log.Fatalf("could not get store: %s", err)
-		}
-		session.Values["access_token"] = lr.Token().AccessToken
-		session.Values["id_token"] = lr.Token().IDToken
-		err = session.Save(r, w)
-		if err != nil {
-			log.Fatalf("could not save access token: %s", err)
-		}
-		// redirect the user to /profile
-		http.Redirect(w, r, "/", http.StatusFound)
-		return
-	}
-	lr, err = lr.WhereAmI(r.Context())
-	if err != nil {
-		session.Values["Errors"] = err.Error()
-		session.Save(r, w)
-		http.Redirect(w, r, "/login", http.StatusFound)
+func (s *Server) handleLoginPhoneVerificationMethod(w http.ResponseWriter, r *http.Request) {
+	flow := s.flowState(w, r)
+	clr, found := flow.Get("loginResponse")
+	if !found {
+		s.renderError(w, r, expiredTransactionError())
 		return
 	}
-	s.cache.Set("loginResponse", lr, time.Minute*5)
-	http.Redirect(w, r, "/login/factors", http.StatusFound)
-}
-
-func (s *Server) handleLoginPhoneVerificationMethod(w http.ResponseWriter, r *http.Request) {
-	clr, _ := s.cache.Get("loginResponse")
 	lr := clr.(*idx.LoginResponse)
 	if lr.HasStep(idx.LoginStepPhoneInitialVerification) || lr.HasStep(idx.LoginStepPhoneVerification) {
-		if lr.HasStep(idx.LoginStepPhoneInitialVerification) {
-			s.ViewData["InitialPhoneSetup"] = true
-		} else {
-			s.ViewData["InitialPhoneSetup"] = false
+		data := &phoneMethodView{
+			InitialPhoneSetup: lr.HasStep(idx.LoginStepPhoneInitialVerification),
 		}
-		s.render("loginFactorPhoneMethod.gohtml", w, r)
+		s.render("loginFactorPhoneMethod.gohtml", w, r, data)
 		return
 	}
 	http.Redirect(w, r, "/login/factors", http.StatusFound)
 }
 
 func (s *Server) handleLoginPhoneVerification(w http.ResponseWriter, r *http.Request) {
-	clr, _ := s.cache.Get("loginResponse")
+	flow := s.flowState(w, r)
+	clr, found := flow.Get("loginResponse")
+	if !found {
+		s.renderError(w, r, expiredTransactionError())
+		return
+	}
 	lr := clr.(*idx.LoginResponse)
 	session, err := sessionStore.Get(r, "direct-auth")
 	if err != nil {
-		log.Fatalf("could not get store: %s", err)
+		s.renderError(w, r, internalError(err))
+		return
 	}
 	if lr.HasStep(idx.LoginStepPhoneInitialVerification) || lr.HasStep(idx.LoginStepPhoneVerification) {
 		// get method
 		_ = r.FormValue("voice")
 		_ = r.FormValue("sms")
-		invCode, ok := s.ViewData["InvalidPhoneCode"]
-		if !ok || !invCode.(bool) {
+		if _, rejected := flow.Get(loginPhoneStep.rejectedKey()); !rejected {
 			var err error
 			if lr.HasStep(idx.LoginStepPhoneInitialVerification) {
+				start := time.Now()
 				lr, err = lr.VerifyPhoneInitial(r.Context(), idx.PhoneMethodSMS, r.FormValue("phoneNumber"))
+				observeLoginStep(idx.LoginStepPhoneInitialVerification, start, err)
 			} else {
+				start := time.Now()
 				lr, err = lr.VerifyPhone(r.Context(), idx.PhoneMethodSMS)
+				observeLoginStep(idx.LoginStepPhoneVerification, start, err)
 			}
 			if err != nil {
 				session.Values["Errors"] = err.Error()
@@ -425,63 +368,17 @@ func (s *Server) handleLoginPhoneVerification(w http.ResponseWriter, r *http.Req
 				http.Redirect(w, r, "/login/factors/phone/method", http.StatusFound)
 				return
 			}
-			s.cache.Set("loginResponse", lr, time.Minute*5)
-		}
-		s.render("loginFactorPhone.gohtml", w, r)
-		return
-	}
-	http.Redirect(w, r, "/login/factors", http.StatusFound)
-}
-
-func (s *Server) handleLoginPhoneConfirmation(w http.ResponseWriter, r *http.Request) {
-	clr, _ := s.cache.Get("loginResponse")
-	lr := clr.(*idx.LoginResponse)
-	if !lr.HasStep(idx.LoginStepPhoneConfirmation) {
-		http.Redirect(w, r, "/login/factors", http.StatusFound)
-		return
-	}
-	session, err := sessionStore.Get(r, "direct-auth")
-	if err != nil {
-		log.Fatalf("could not get store: %s", err)
-	}
-	lr, err = lr.ConfirmPhone(r.Context(), r.FormValue("code"))
-	if err != nil {
-		s.ViewData["InvalidPhoneCode"] = true
-		session.Values["Errors"] = err.Error()
-		session.Save(r, w)
-		http.Redirect(w, r, "/login/factors/phone", http.StatusFound)
-		return
-	}
-	s.ViewData["InvalidPhoneCode"] = false
-	// If we have tokens we have success, so lets store tokens
-	if lr.Token() != nil {
-		session, err := sessionStore.Get(r, "direct-auth")
-		if err != nil {
-			log.Fatalf("could not get store: %s", err)
-		}
-		session.Values["access_token"] = lr.Token().AccessToken
-		session.Values["id_token"] = lr.Token().IDToken
-		err = session.Save(r, w)
-		if err != nil {
-			log.Fatalf("could not save access token: %s", err)
+			flow.Set("loginResponse", lr, time.Minute*5)
 		}
-		// redirect the user to /profile
-		http.Redirect(w, r, "/", http.StatusFound)
+		s.renderStep(w, r, &loginPhoneStep.stepForm, nil, false)
 		return
 	}
-	lr, err = lr.WhereAmI(r.Context())
-	if err != nil {
-		session.Values["Errors"] = err.Error()
-		session.Save(r, w)
-		http.Redirect(w, r, "/login", http.StatusFound)
-		return
-	}
-	s.cache.Set("loginResponse", lr, time.Minute*5)
 	http.Redirect(w, r, "/login/factors", http.StatusFound)
 }
 
 func (s *Server) handleLoginOktaVerify(w http.ResponseWriter, r *http.Request) {
-	clr, _ := s.cache.Get("loginResponse")
+	flow := s.flowState(w, r)
+	clr, _ := flow.Get("loginResponse")
 	if clr == nil {
 		http.Redirect(w, r, "/", http.StatusFound)
 		return
@@ -492,87 +389,25 @@ func (s *Server) handleLoginOktaVerify(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	s.ViewData["OktaVerifyTotp"] = false
-	s.ViewData["OktaVerifyPush"] = false
+	data := &oktaVerifyView{}
 	methodTypes, err := lr.OktaVerifyMethodTypes(r.Context())
 	if err == nil {
 		for _, mt := range methodTypes {
 			switch mt {
 			case "push":
-				s.ViewData["OktaVerifyPush"] = true
+				data.OktaVerifyPush = true
 			case "totp":
-				s.ViewData["OktaVerifyTotp"] = true
+				data.OktaVerifyTotp = true
 			}
 		}
 	}
 
-	s.render("loginOktaVerify.gohtml", w, r)
-}
-
-func (s *Server) handleLoginOktaVerifyTotp(w http.ResponseWriter, r *http.Request) {
-	clr, _ := s.cache.Get("loginResponse")
-	if clr == nil {
-		http.Redirect(w, r, "/", http.StatusFound)
-		return
-	}
-	lr := clr.(*idx.LoginResponse)
-	if !lr.HasStep(idx.LoginStepOktaVerify) {
-		http.Redirect(w, r, "/login/factors", http.StatusFound)
-		return
-	}
-	s.render("loginOktaVerifyTotp.gohtml", w, r)
-}
-
-func (s *Server) handleLoginOktaVerifyTotpConfirmation(w http.ResponseWriter, r *http.Request) {
-	clr, _ := s.cache.Get("loginResponse")
-	lr := clr.(*idx.LoginResponse)
-	if !lr.HasStep(idx.LoginStepOktaVerify) {
-		http.Redirect(w, r, "/login/factors", http.StatusFound)
-		return
-	}
-	session, err := sessionStore.Get(r, "direct-auth")
-	if err != nil {
-		log.Fatalf("could not get store: %s", err)
-	}
-	lr, err = lr.OktaVerifyConfirm(r.Context(), r.FormValue("code"))
-	if err != nil {
-		session.Values["Errors"] = err.Error()
-		session.Save(r, w)
-		http.Redirect(w, r, "/login/factors/okta-verify", http.StatusFound)
-		return
-	}
-	s.cache.Set("loginResponse", lr, time.Minute*5)
-
-	// If we have tokens we have success, so lets store tokens
-	if lr.Token() != nil {
-		session, err := sessionStore.Get(r, "direct-auth")
-		if err != nil {
-			log.Fatalf("could not get store: %s", err)
-		}
-		session.Values["access_token"] = lr.Token().AccessToken
-		session.Values["id_token"] = lr.Token().IDToken
-		err = session.Save(r, w)
-		if err != nil {
-			log.Fatalf("could not save access token: %s", err)
-		}
-		// redirect the user to /profile
-		http.Redirect(w, r, "/", http.StatusFound)
-		return
-	}
-
-	lr, err = lr.WhereAmI(r.Context())
-	if err != nil {
-		session.Values["Errors"] = err.Error()
-		session.Save(r, w)
-		http.Redirect(w, r, "/login", http.StatusFound)
-		return
-	}
-	s.cache.Set("loginResponse", lr, time.Minute*5)
-	http.Redirect(w, r, "/login/factors", http.StatusFound)
+	s.render("loginOktaVerify.gohtml", w, r, data)
 }
 
 func (s *Server) handleLoginOktaVerifyPush(w http.ResponseWriter, r *http.Request) {
-	clr, _ := s.cache.Get("loginResponse")
+	flow := s.flowState(w, r)
+	clr, _ := flow.Get("loginResponse")
 	if clr == nil {
 		http.Redirect(w, r, "/", http.StatusFound)
 		return
@@ -584,24 +419,30 @@ func (s *Server) handleLoginOktaVerifyPush(w http.ResponseWriter, r *http.Reques
 	}
 
 	// will block while push login notice sent to remote Okta Verify app
+	start := time.Now()
 	lr, err := lr.OktaVerify(r.Context())
+	observeLoginStep(idx.LoginStepOktaVerify, start, err)
 	if err != nil {
-		log.Fatalf("error initiating okta verify async: %s", err)
+		s.renderError(w, r, upstreamError(err))
+		return
 	}
 
-	s.cache.Set("loginResponse", lr, time.Minute*5)
+	flow.Set("loginResponse", lr, time.Minute*5)
 
 	// If we have tokens we have success, so lets store tokens
 	if lr.Token() != nil {
+		loginsCompleted.Inc()
 		session, err := sessionStore.Get(r, "direct-auth")
 		if err != nil {
-			log.Fatalf("could not get store: %s", err)
+			s.renderError(w, r, internalError(err))
+			return
 		}
 		session.Values["access_token"] = lr.Token().AccessToken
 		session.Values["id_token"] = lr.Token().IDToken
 		err = session.Save(r, w)
 		if err != nil {
-			log.Fatalf("could not save access token: %s", err)
+			s.renderError(w, r, internalError(err))
+			return
 		}
 		// redirect the user to /profile
 		http.Redirect(w, r, "/", http.StatusFound)
@@ -612,7 +453,8 @@ func (s *Server) handleLoginOktaVerifyPush(w http.ResponseWriter, r *http.Reques
 }
 
 func (s *Server) handleLoginGoogleAuth(w http.ResponseWriter, r *http.Request) {
-	clr, _ := s.cache.Get("loginResponse")
+	flow := s.flowState(w, r)
+	clr, _ := flow.Get("loginResponse")
 	if clr == nil {
 		http.Redirect(w, r, "/", http.StatusFound)
 		return
@@ -623,7 +465,7 @@ func (s *Server) handleLoginGoogleAuth(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	if lr.HasStep(idx.LoginStepGoogleAuthenticatorConfirmation) {
-		s.render("loginGoogleAuthCode.gohtml", w, r)
+		s.renderStep(w, r, &loginGoogleAuthStep.stepForm, nil, false)
 		return
 	}
 	if lr.HasStep(idx.LoginStepGoogleAuthenticatorInitialVerification) {
@@ -633,55 +475,9 @@ func (s *Server) handleLoginGoogleAuth(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, "/login/factors", http.StatusFound)
 }
 
-func (s *Server) handleLoginGoogleAuthConfirmation(w http.ResponseWriter, r *http.Request) {
-	clr, _ := s.cache.Get("loginResponse")
-	lr := clr.(*idx.LoginResponse)
-	if !lr.HasStep(idx.LoginStepGoogleAuthenticatorConfirmation) {
-		http.Redirect(w, r, "/login/factors", http.StatusFound)
-		return
-	}
-	session, err := sessionStore.Get(r, "direct-auth")
-	if err != nil {
-		log.Fatalf("could not get store: %s", err)
-	}
-	lr, err = lr.GoogleAuthConfirm(r.Context(), r.FormValue("code"))
-	if err != nil {
-		session.Values["Errors"] = err.Error()
-		session.Save(r, w)
-		http.Redirect(w, r, "/login/factors/google_auth", http.StatusFound)
-		return
-	}
-	s.cache.Set("loginResponse", lr, time.Minute*5)
-
-	// If we have tokens we have success, so lets store tokens
-	if lr.Token() != nil {
-		session, err := sessionStore.Get(r, "direct-auth")
-		if err != nil {
-			log.Fatalf("could not get store: %s", err)
-		}
-		session.Values["access_token"] = lr.Token().AccessToken
-		session.Values["id_token"] = lr.Token().IDToken
-		err = session.Save(r, w)
-		if err != nil {
-			log.Fatalf("could not save access token: %s", err)
-		}
-		// redirect the user to /profile
-		http.Redirect(w, r, "/", http.StatusFound)
-		return
-	}
-	lr, err = lr.WhereAmI(r.Context())
-	if err != nil {
-		session.Values["Errors"] = err.Error()
-		session.Save(r, w)
-		http.Redirect(w, r, "/login", http.StatusFound)
-		return
-	}
-	s.cache.Set("loginResponse", lr, time.Minute*5)
-	http.Redirect(w, r, "/login/factors", http.StatusFound)
-}
-
 func (s *Server) handleLoginGoogleAuthInit(w http.ResponseWriter, r *http.Request) {
-	clr, _ := s.cache.Get("loginResponse")
+	flow := s.flowState(w, r)
+	clr, _ := flow.Get("loginResponse")
 	if clr == nil {
 		http.Redirect(w, r, "/", http.StatusFound)
 		return
@@ -691,136 +487,83 @@ func (s *Server) handleLoginGoogleAuthInit(w http.ResponseWriter, r *http.Reques
 		http.Redirect(w, r, "/login/factors", http.StatusFound)
 		return
 	}
+	start := time.Now()
 	lr, err := lr.GoogleAuthInitialVerify(r.Context())
+	observeLoginStep(idx.LoginStepGoogleAuthenticatorInitialVerification, start, err)
 	if err != nil {
 		http.Redirect(w, r, "/enrollFactor", http.StatusFound)
 		return
 	}
-	s.cache.Set("loginResponse", lr, time.Minute*5)
-	s.ViewData["QRCode"] = template.URL(lr.ContextualData().QRcode.Href)
-	s.ViewData["SharedSecret"] = template.URL(lr.ContextualData().SharedSecret)
-	s.render("loginGoogleAuthInitial.gohtml", w, r)
-}
-
-func (s *Server) handleLoginWebAuthNChallenge(w http.ResponseWriter, r *http.Request) {
-	clr, _ := s.cache.Get("loginResponse")
-	if clr == nil {
-		http.Redirect(w, r, "/", http.StatusFound)
-		return
-	}
-	lr := clr.(*idx.LoginResponse)
-	if !lr.HasStep(idx.LoginStepWebAuthNSetup) && !lr.HasStep(idx.LoginStepWebAuthNChallenge) {
-		http.Redirect(w, r, "/login/factors", http.StatusFound)
-		return
-	}
-	if lr.HasStep(idx.LoginStepWebAuthNChallenge) {
-		lr, err := lr.WebAuthNChallenge(r.Context())
-		if err != nil {
-			http.Redirect(w, r, "/login/factors", http.StatusFound)
-			return
-		}
-		s.cache.Set("loginResponse", lr, time.Minute*5)
-
-		s.ViewData["Challenge"] = template.URL(lr.ContextualData().ChallengeData.Challenge)
-		s.ViewData["WebauthnCredentialID"] = template.URL(lr.ContextualData().ChallengeData.CredentialID)
-		s.render("loginWebAuthN.gohtml", w, r)
-		return
+	flow.Set("loginResponse", lr, time.Minute*5)
+	data := &googleAuthView{
+		QRCode:       template.URL(lr.ContextualData().QRcode.Href),
+		SharedSecret: template.URL(lr.ContextualData().SharedSecret),
 	}
-	if lr.HasStep(idx.LoginStepWebAuthNSetup) {
-		panic("not implemented yet")
-		return
-	}
-	http.Redirect(w, r, "/login/factors", http.StatusFound)
+	s.render("loginGoogleAuthInitial.gohtml", w, r, data)
 }
 
-func (s *Server) handleLoginWebAuthNVerify(w http.ResponseWriter, r *http.Request) {
-	clr, _ := s.cache.Get("loginResponse")
-	if clr == nil {
-		http.Redirect(w, r, "/", http.StatusFound)
-		return
-	}
-	lr := clr.(*idx.LoginResponse)
-	if !lr.HasStep(idx.LoginStepWebAuthNVerify) {
-		http.Redirect(w, r, "/login/factors", http.StatusFound)
-		return
-	}
+func (s *Server) handleLoginCallback(w http.ResponseWriter, r *http.Request) {
+	// Get session store so we can store our tokens
 	session, err := sessionStore.Get(r, "direct-auth")
 	if err != nil {
-		log.Fatalf("could not get store: %s", err)
-	}
-	reqBody, err := ioutil.ReadAll(r.Body)
-	if err != nil {
-		log.Fatalf("could not read request body: %v", err)
-	}
-	defer r.Body.Close()
-	var credentials idx.WebAuthNChallengeCredentials
-	if err := json.Unmarshal(reqBody, &credentials); err != nil {
-		log.Fatalf("could not unmarshal request body: %v", err)
-	}
-	lr, err = lr.WebAuthNVerify(r.Context(), &credentials)
-	if err != nil {
-		session.Values["Errors"] = err.Error()
-		session.Save(r, w)
-		http.Redirect(w, r, "/login/factors/web_authn", http.StatusFound)
+		s.renderError(w, r, internalError(err))
 		return
 	}
-	// If we have tokens we have success, so lets store tokens
-	if lr.Token() != nil {
-		session.Values["access_token"] = lr.Token().AccessToken
-		session.Values["id_token"] = lr.Token().IDToken
-		err = session.Save(r, w)
-		if err != nil {
-			log.Fatalf("could not save access token: %s", err)
+
+	otp := r.URL.Query().Get("otp")
+	state := r.URL.Query().Get("state")
+	if otp != "" && session.Values["idxContext.state"] != state {
+		// The magic link was opened in another browser than the one the login
+		// started in, so the login is resumed here from the transaction the
+		// link's state belongs to.
+		if txID, found := s.cache.Get(magicLinkKey(state)); found {
+			session.Values[transactionIDKey] = txID
+			session.Values["idxContext.state"] = state
+			if err := session.Save(r, w); err != nil {
+				s.renderError(w, r, internalError(err))
+				return
+			}
 		}
-		http.Redirect(w, r, "/", http.StatusFound)
+	}
+
+	flow := s.flowState(w, r)
+	clr, found := flow.Get("loginResponse")
+	if !found && otp != "" {
+		s.render("loginFactorEmailOtp.gohtml", w, r, &emailOTPView{OTP: otp})
 		return
 	}
-	lr, err = lr.WhereAmI(r.Context())
-	if err != nil {
-		session.Values["Errors"] = err.Error()
-		session.Save(r, w)
-		http.Redirect(w, r, "/login", http.StatusFound)
+	if !found {
+		s.renderError(w, r, expiredTransactionError())
 		return
 	}
-	s.cache.Set("loginResponse", lr, time.Minute*5)
-	http.Redirect(w, r, "/login/factors", http.StatusFound)
-}
-
-func (s *Server) handleLoginCallback(w http.ResponseWriter, r *http.Request) {
-	clr, _ := s.cache.Get("loginResponse")
-	s.cache.Delete("loginResponse")
+	flow.Delete("loginResponse")
 	lr := clr.(*idx.LoginResponse)
 
-	// Get session store so we can store our tokens
-	session, err := sessionStore.Get(r, "direct-auth")
-	if err != nil {
-		log.Fatalf("could not get store: %s", err)
-	}
-
-	if code, found := r.URL.Query()["otp"]; found {
-
-		// If the login callback is called with otp and state values we need to
-		// check if the idx state string in the session is the same as the login
-		// response's context. If not, just display the otp value in a page and
-		// ask the user to enter the code in the original browser where they
-		// started the login flow login session.
-		state, found := session.Values["idxContext.state"]
-		if !found || lr.Context().State != state {
+	if otp != "" {
+		// If the login response's context isn't the one of the link, like when
+		// the login it belongs to has already expired, just display the otp
+		// value in a page and ask the user to enter the code in the browser
+		// where they started the login flow login session.
+		if lr.Context().State != state {
 			// need to keep the login response resident
-			s.cache.Set("loginResponse", lr, time.Minute*5)
-			s.ViewData["OTP"] = code[0]
-			s.render("loginFactorEmailOtp.gohtml", w, r)
+			flow.Set("loginResponse", lr, time.Minute*5)
+			s.render("loginFactorEmailOtp.gohtml", w, r, &emailOTPView{OTP: otp})
 			return
 		}
 
-		lr, err = lr.ConfirmEmail(r.Context(), code[0])
+		start := time.Now()
+		lr, err = lr.ConfirmEmail(r.Context(), otp)
+		observeLoginStep(idx.LoginStepEmailConfirmation, start, err)
 		if err != nil {
-			log.Fatalf("could not confirm email with otp code %q: %s", code[0], err)
+			s.renderError(w, r, upstreamError(fmt.Errorf("could not confirm email with otp code %q: %w", otp, err)))
+			return
 		}
+		s.cache.Delete(magicLinkKey(state))
 	} else {
 		lr, err = lr.WhereAmI(r.Context())
 		if err != nil {
-			log.Fatalf("could not tell where I am: %s", err)
+			s.renderError(w, r, upstreamError(err))
+			return
 		}
 	}
 
@@ -837,12 +580,25 @@ func (s *Server) handleLoginCallback(w http.ResponseWriter, r *http.Request) {
 
 	// If we have tokens we have success, so lets store tokens
 	if lr.Token() != nil {
+		loginsCompleted.Inc()
 		session.Values["access_token"] = lr.Token().AccessToken
 		session.Values["id_token"] = lr.Token().IDToken
 		err = session.Save(r, w)
 		if err != nil {
-			log.Fatalf("could not save access token: %s", err)
+			s.renderError(w, r, internalError(err))
+			return
 		}
+	} else if otp != "" {
+		// the policy asks for more than the email, like with a passwordless
+		// login that needs a second factor
+		lr, err = lr.WhereAmI(r.Context())
+		if err != nil {
+			s.renderError(w, r, upstreamError(err))
+			return
+		}
+		flow.Set("loginResponse", lr, time.Minute*5)
+		http.Redirect(w, r, "/login/factors", http.StatusFound)
+		return
 	} else {
 		session.Values["Errors"] = "We expected tokens to be available here but were not. Authentication Failed."
 		session.Save(r, w)
@@ -853,3 +609,9 @@ func (s *Server) handleLoginCallback(w http.ResponseWriter, r *http.Request) {
 	// redirect the user to /profile
 	http.Redirect(w, r, "/", http.StatusFound)
 }
+
+// magicLinkKey is the server cache entry holding the transaction id of the
+// login whose IDX state is state.
+func magicLinkKey(state string) string {
+	return "magic-link:" + state
+}
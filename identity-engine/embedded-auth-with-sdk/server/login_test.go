@@ -0,0 +1,316 @@
+/**
+ * Copyright 2021 - Present Okta, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	idx "github.com/okta/okta-idx-golang"
+	"github.com/patrickmn/go-cache"
+)
+
+const (
+	fakeUsername    = "test.user@example.com"
+	fakeEmailCode   = "123456"
+	fakeAccessToken = "access-token"
+	fakeIDToken     = "id-token"
+)
+
+// fakeEmailRemediation is the IDX state of a user who can pick the email
+// authenticator, and answer its challenge. Other remediations can be put in
+// front of those.
+const fakeEmailRemediation = `{
+    "stateHandle": "a",
+    "remediation": {
+        "type": "array",
+        "value": [%[2]s
+            {
+                "rel": ["create-form"],
+                "name": "select-authenticator-authenticate",
+                "href": "http://%[1]s/idp/idx/challenge",
+                "method": "POST",
+                "value": [
+                    {
+                        "name": "authenticator",
+                        "type": "object",
+                        "options": [
+                            {
+                                "label": "Email",
+                                "value": {
+                                    "form": {
+                                        "value": [
+                                            {"name": "id", "required": true, "value": "autEmail", "mutable": false},
+                                            {"name": "methodType", "required": false, "value": "email", "mutable": false}
+                                        ]
+                                    }
+                                }
+                            }
+                        ]
+                    },
+                    {"name": "stateHandle", "required": true, "value": "a", "visible": false, "mutable": false}
+                ],
+                "accepts": "application/json; okta-version=1.0.0"
+            },
+            {
+                "rel": ["create-form"],
+                "name": "challenge-authenticator",
+                "href": "http://%[1]s/idp/idx/challenge/answer",
+                "method": "POST",
+                "value": [
+                    {
+                        "name": "credentials",
+                        "type": "object",
+                        "form": {"value": [{"name": "passcode", "label": "Enter code"}]},
+                        "required": true
+                    },
+                    {"name": "stateHandle", "required": true, "value": "a", "visible": false, "mutable": false}
+                ],
+                "accepts": "application/json; okta-version=1.0.0"
+            }
+        ]
+    },
+    "cancel": {
+        "rel": ["create-form"],
+        "name": "cancel",
+        "href": "http://%[1]s/idp/idx/cancel",
+        "method": "POST",
+        "value": [
+            {"name": "stateHandle", "required": true, "value": "a", "visible": false, "mutable": false}
+        ],
+        "accepts": "application/json; okta-version=1.0.0"
+    }
+}`
+
+const fakeIdentifyRemediation = `
+            {
+                "rel": ["create-form"],
+                "name": "identify",
+                "href": "http://%s/idp/idx/identify",
+                "method": "POST",
+                "value": [
+                    {"name": "identifier", "label": "Username", "required": true},
+                    {"name": "stateHandle", "required": true, "value": "a", "visible": false, "mutable": false}
+                ],
+                "accepts": "application/json; okta-version=1.0.0"
+            },`
+
+// newFakeIDX serves the part of the IDX API an email login goes through, and
+// returns a client of it. Only fakeUsername can sign in, with fakeEmailCode.
+func newFakeIDX(t *testing.T) *idx.Client {
+	t.Helper()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/oauth2/v1/interact", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"interaction_handle":"a"}`))
+	})
+	mux.HandleFunc("/idp/idx/introspect", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, fakeEmailRemediation, r.Host, fmt.Sprintf(fakeIdentifyRemediation, r.Host))
+	})
+	emailRemediation := func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, fakeEmailRemediation, r.Host, "")
+	}
+	mux.HandleFunc("/idp/idx/challenge", emailRemediation)
+	mux.HandleFunc("/idp/idx/identify", func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Identifier string `json:"identifier"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Identifier != fakeUsername {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"version": "1.0.0", "messages": {"type": "array", "value": [{"message": "There is no account with that username.", "class": "ERROR"}]}}`))
+			return
+		}
+		emailRemediation(w, r)
+	})
+	mux.HandleFunc("/idp/idx/challenge/answer", func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Credentials struct {
+				Passcode string `json:"passcode"`
+			} `json:"credentials"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Credentials.Passcode != fakeEmailCode {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"errorCode": "E0000001", "errorSummary": "Invalid code"}`))
+			return
+		}
+		fmt.Fprintf(w, `{
+		    "stateHandle": "a",
+		    "successWithInteractionCode": {
+		        "rel": ["create-form"],
+		        "name": "issue",
+		        "href": "http://%s/oauth2/v1/token",
+		        "method": "POST",
+		        "value": [
+		            {"name": "grant_type", "required": true, "value": "interaction_code"},
+		            {"name": "interaction_code", "required": true, "value": "code"},
+		            {"name": "client_id", "required": true, "value": "foo"},
+		            {"name": "client_secret", "required": true},
+		            {"name": "code_verifier", "required": true}
+		        ],
+		        "accepts": "application/x-www-form-urlencoded"
+		    }
+		}`, r.Host)
+	})
+	mux.HandleFunc("/oauth2/v1/token", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{
+		    "token_type": "Bearer",
+		    "expires_in": 3600,
+		    "scope": "openid profile",
+		    "access_token": %q,
+		    "refresh_token": "refresh-token",
+		    "id_token": %q
+		}`, fakeAccessToken, fakeIDToken)
+	})
+	ts := httptest.NewServer(mux)
+	t.Cleanup(ts.Close)
+
+	client, err := idx.NewClientWithSettings(
+		idx.WithClientID("foo"),
+		idx.WithClientSecret("bar"),
+		idx.WithIssuer(ts.URL),
+		idx.WithScopes([]string{"openid", "profile"}),
+		idx.WithRedirectURI("http://localhost:8000/login/callback"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client.WithHTTPClient(ts.Client())
+}
+
+func newLoginTestServer(t *testing.T) *Server {
+	tpl := template.New("")
+	template.Must(tpl.New("loginFactorEmailOtp.gohtml").Parse("{{.OTP}}"))
+	template.Must(tpl.New("error.gohtml").Parse("error"))
+	return &Server{
+		tpl:       tpl,
+		idxClient: newFakeIDX(t),
+		cache:     cache.New(time.Minute, time.Minute),
+	}
+}
+
+// startEmailLogin takes a login up to the email being sent, the way the
+// login pages do, and returns the cookies of the browser it started in and the
+// IDX state the magic link carries.
+func startEmailLogin(t *testing.T, s *Server) ([]*http.Cookie, string) {
+	t.Helper()
+	w := httptest.NewRecorder()
+	flow := s.flowState(w, httptest.NewRequest(http.MethodGet, "/login", nil))
+
+	lr, err := s.idxClient.InitLogin(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	lr, _, err = s.loginChallenge(context.Background(), flow, lr, loginEmailStep)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !lr.HasStep(idx.LoginStepEmailConfirmation) {
+		t.Fatalf("steps after the email was sent: %v", lr.AvailableSteps())
+	}
+	return w.Result().Cookies(), lr.Context().State
+}
+
+// openMagicLink follows the link of the email in a browser with cookies.
+func openMagicLink(s *Server, state string, cookies []*http.Cookie) *httptest.ResponseRecorder {
+	q := url.Values{"otp": {fakeEmailCode}, "state": {state}}
+	r := httptest.NewRequest(http.MethodGet, "/login/callback?"+q.Encode(), nil)
+	for _, c := range cookies {
+		r.AddCookie(c)
+	}
+	w := httptest.NewRecorder()
+	s.handleLoginCallback(w, r)
+	return w
+}
+
+// sessionTokens returns the tokens of the session the cookies carry. A
+// handler can save the session more than once, the browser keeps the last one.
+func sessionTokens(t *testing.T, cookies []*http.Cookie) (interface{}, interface{}) {
+	t.Helper()
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	for i := len(cookies) - 1; i >= 0; i-- {
+		if _, err := r.Cookie(cookies[i].Name); err != nil {
+			r.AddCookie(cookies[i])
+		}
+	}
+	session, err := sessionStore.Get(r, "direct-auth")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return session.Values["access_token"], session.Values["id_token"]
+}
+
+func TestMagicLinkInAnotherBrowser(t *testing.T) {
+	s := newLoginTestServer(t)
+	_, state := startEmailLogin(t, s)
+	if _, found := s.cache.Get(magicLinkKey(state)); !found {
+		t.Fatal("sending the email didn't remember the transaction of the link")
+	}
+
+	w := openMagicLink(s, state, nil)
+
+	if w.Code != http.StatusFound || w.Header().Get("Location") != "/" {
+		t.Fatalf("callback answered %d to %q, want a redirect to /: %s", w.Code, w.Header().Get("Location"), w.Body)
+	}
+	access, id := sessionTokens(t, w.Result().Cookies())
+	if access != fakeAccessToken || id != fakeIDToken {
+		t.Errorf("session of the other browser has tokens %v and %v", access, id)
+	}
+}
+
+func TestMagicLinkIsUsedOnce(t *testing.T) {
+	s := newLoginTestServer(t)
+	_, state := startEmailLogin(t, s)
+
+	if w := openMagicLink(s, state, nil); w.Code != http.StatusFound {
+		t.Fatalf("first use of the link answered %d: %s", w.Code, w.Body)
+	}
+	if _, found := s.cache.Get(magicLinkKey(state)); found {
+		t.Error("the link's transaction is still remembered after the login")
+	}
+
+	// The link opened again elsewhere only shows the code
+	w := openMagicLink(s, state, nil)
+	if w.Code != http.StatusOK || w.Body.String() != fakeEmailCode {
+		t.Errorf("second use of the link answered %d with %q, want the code page", w.Code, w.Body)
+	}
+	if access, _ := sessionTokens(t, w.Result().Cookies()); access != nil {
+		t.Errorf("second use of the link signed in with %v", access)
+	}
+}
+
+func TestMagicLinkInTheSameBrowser(t *testing.T) {
+	s := newLoginTestServer(t)
+	cookies, state := startEmailLogin(t, s)
+
+	w := openMagicLink(s, state, cookies)
+
+	if w.Code != http.StatusFound || w.Header().Get("Location") != "/" {
+		t.Fatalf("callback answered %d to %q, want a redirect to /: %s", w.Code, w.Header().Get("Location"), w.Body)
+	}
+	access, _ := sessionTokens(t, append(cookies, w.Result().Cookies()...))
+	if access != fakeAccessToken {
+		t.Errorf("session has access token %v", access)
+	}
+	if _, found := s.cache.Get(magicLinkKey(state)); found {
+		t.Error("the link's transaction is still remembered after the login")
+	}
+}
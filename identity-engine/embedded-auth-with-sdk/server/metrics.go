@@ -0,0 +1,199 @@
+/**
+ * Copyright 2021 - Present Okta, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package server
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	idx "github.com/okta/okta-idx-golang"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// The metrics served on /metrics of the metrics address. Steps are labeled with the String() of
+// the idx.LoginStep, idx.EnrollmentStep and idx.ResetPasswordStep the
+// handlers check, outcomes are "success" or "error".
+var (
+	loginsStarted = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "idx_logins_started_total",
+		Help: "Logins started.",
+	})
+	loginsCompleted = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "idx_logins_completed_total",
+		Help: "Logins that ended with tokens.",
+	})
+	loginSteps = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "idx_login_steps_total",
+		Help: "Login steps taken, by step and outcome.",
+	}, []string{"step", "outcome"})
+
+	enrollmentsStarted = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "idx_enrollments_started_total",
+		Help: "Registrations started.",
+	})
+	enrollmentsCompleted = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "idx_enrollments_completed_total",
+		Help: "Registrations that ended with tokens.",
+	})
+	enrollmentSteps = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "idx_enrollment_steps_total",
+		Help: "Enrollment steps taken, by step and outcome.",
+	}, []string{"step", "outcome"})
+
+	passwordResetsStarted = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "idx_password_resets_started_total",
+		Help: "Password resets started.",
+	})
+	passwordResetsCompleted = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "idx_password_resets_completed_total",
+		Help: "Password resets that ended with tokens.",
+	})
+	passwordResetSteps = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "idx_password_reset_steps_total",
+		Help: "Password reset steps taken, by step and outcome.",
+	}, []string{"step", "outcome"})
+
+	stepDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "idx_step_duration_seconds",
+		Help:    "Time the IDX calls of a step took, by flow and step.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"flow", "step"})
+
+	apiRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "idx_api_request_duration_seconds",
+		Help:    "Latency of the requests made to the Okta API, by route and status code.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"route", "code"})
+	apiErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "idx_api_errors_total",
+		Help: "Errors returned by the IDX API, by flow, step and Okta error code.",
+	}, []string{"flow", "step", "error_code"})
+)
+
+func observeLoginStep(step idx.LoginStep, start time.Time, err error) {
+	observeStep("login", step.String(), loginSteps, start, err)
+}
+
+func observeEnrollmentStep(step idx.EnrollmentStep, start time.Time, err error) {
+	observeStep("enrollment", step.String(), enrollmentSteps, start, err)
+}
+
+func observePasswordResetStep(step idx.ResetPasswordStep, start time.Time, err error) {
+	observeStep("password_reset", step.String(), passwordResetSteps, start, err)
+}
+
+func observeStep(flow, step string, steps *prometheus.CounterVec, start time.Time, err error) {
+	stepDuration.WithLabelValues(flow, step).Observe(time.Since(start).Seconds())
+	if err == nil {
+		steps.WithLabelValues(step, "success").Inc()
+		return
+	}
+	steps.WithLabelValues(step, "error").Inc()
+
+	code := "unknown"
+	var respErr *idx.ResponseError
+	if errors.As(err, &respErr) {
+		switch {
+		case respErr.ErrorCode != "":
+			code = respErr.ErrorCode
+		case respErr.ErrorType != "":
+			code = respErr.ErrorType
+		}
+	}
+	apiErrors.WithLabelValues(flow, step, code).Inc()
+}
+
+// instrumentedTransport times the requests the IDX client makes.
+type instrumentedTransport struct {
+	next http.RoundTripper
+}
+
+func (t *instrumentedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := t.next.RoundTrip(req)
+	code := "error"
+	if err == nil {
+		code = strconv.Itoa(resp.StatusCode)
+	}
+	apiRequestDuration.WithLabelValues(apiRoute(req.URL.Path), code).Observe(time.Since(start).Seconds())
+	return resp, err
+}
+
+// The Okta endpoints the IDX SDK calls, by the part of their path after
+// /oauth2[/{authorizationServerId}]/v1/ and /idp/idx/.
+var (
+	oauth2Routes = map[string]bool{
+		"interact": true, "token": true, "revoke": true, "introspect": true,
+		"userinfo": true, "keys": true, "logout": true,
+	}
+	idxRoutes = map[string]bool{
+		"introspect": true, "identify": true, "enroll": true, "enroll/new": true,
+		"enroll/update": true, "challenge": true, "challenge/answer": true,
+		"challenge/poll": true, "challenge/resend": true, "credential/enroll": true,
+		"authenticators/poll": true, "recover": true, "skip": true, "cancel": true,
+	}
+)
+
+// apiRoute names the Okta endpoint of path, like oauth2/token or
+// idx/challenge/answer, so that the route label only takes a fixed set of
+// values whatever the ids in the paths. Other paths are "other".
+func apiRoute(path string) string {
+	if rest := strings.TrimPrefix(path, "/idp/idx/"); rest != path && idxRoutes[rest] {
+		return "idx/" + rest
+	}
+	if strings.HasPrefix(path, "/oauth2/") {
+		if i := strings.Index(path, "/v1/"); i >= 0 && oauth2Routes[path[i+len("/v1/"):]] {
+			return "oauth2/" + path[i+len("/v1/"):]
+		}
+	}
+	return "other"
+}
+
+// serveMetrics serves the Prometheus metrics on /metrics of addr.
+func serveMetrics(addr string) {
+	log.Printf("metrics served at %s/metrics", addr)
+	if err := http.ListenAndServe(addr, metricsHandler()); err != nil {
+		log.Printf("the metrics server failed to start: %s", err)
+	}
+}
+
+func metricsHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	return mux
+}
+
+// instrumentHTTPClient returns a copy of client, or of a default client,
+// whose requests are recorded in idx_api_request_duration_seconds.
+func instrumentHTTPClient(client *http.Client) *http.Client {
+	instrumented := &http.Client{Timeout: 30 * time.Second}
+	if client != nil {
+		*instrumented = *client
+	}
+	next := instrumented.Transport
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	instrumented.Transport = &instrumentedTransport{next: next}
+	return instrumented
+}
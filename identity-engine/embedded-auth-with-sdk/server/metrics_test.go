@@ -0,0 +1,141 @@
+/**
+ * Copyright 2021 - Present Okta, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package server
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	idx "github.com/okta/okta-idx-golang"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestAPIRoute(t *testing.T) {
+	tests := []struct {
+		path string
+		want string
+	}{
+		{path: "/oauth2/v1/interact", want: "oauth2/interact"},
+		{path: "/oauth2/default/v1/token", want: "oauth2/token"},
+		{path: "/oauth2/aus1a2b3c4d5e6f7g8h9/v1/revoke", want: "oauth2/revoke"},
+		{path: "/idp/idx/introspect", want: "idx/introspect"},
+		{path: "/idp/idx/challenge/answer", want: "idx/challenge/answer"},
+		{path: "/idp/idx/authenticators/poll", want: "idx/authenticators/poll"},
+		{path: "/oauth2/default/v1/nothing", want: "other"},
+		{path: "/idp/idx/users/00u1a2b3c4d5e6f7g8h9", want: "other"},
+		{path: "/api/v1/users/00u1a2b3c4d5e6f7g8h9", want: "other"},
+		{path: "/", want: "other"},
+	}
+	for _, tt := range tests {
+		if got := apiRoute(tt.path); got != tt.want {
+			t.Errorf("apiRoute(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
+
+// requestCount returns how many requests apiRequestDuration recorded for
+// route and code.
+func requestCount(t *testing.T, route, code string) uint64 {
+	t.Helper()
+	var m dto.Metric
+	if err := apiRequestDuration.WithLabelValues(route, code).(prometheus.Histogram).Write(&m); err != nil {
+		t.Fatal(err)
+	}
+	return m.GetHistogram().GetSampleCount()
+}
+
+func TestInstrumentHTTPClient(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/oauth2/aus1a2b3c4d5e6f7g8h9/v1/token" {
+			http.NotFound(w, r)
+		}
+	}))
+	defer ts.Close()
+	client := instrumentHTTPClient(nil)
+
+	tokens := requestCount(t, "oauth2/token", "200")
+	others := requestCount(t, "other", "404")
+	errs := requestCount(t, "idx/introspect", "error")
+
+	for _, path := range []string{"/oauth2/aus1a2b3c4d5e6f7g8h9/v1/token", "/users/00u1a2b3c4d5e6f7g8h9"} {
+		resp, err := client.Get(ts.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+	if _, err := client.Get("http://127.0.0.1:0/idp/idx/introspect"); err == nil {
+		t.Fatal("request to port 0 succeeded")
+	}
+
+	if n := requestCount(t, "oauth2/token", "200") - tokens; n != 1 {
+		t.Errorf("recorded %d token requests, want 1", n)
+	}
+	if n := requestCount(t, "other", "404") - others; n != 1 {
+		t.Errorf("recorded %d requests of other routes, want 1", n)
+	}
+	if n := requestCount(t, "idx/introspect", "error") - errs; n != 1 {
+		t.Errorf("recorded %d failed requests, want 1", n)
+	}
+}
+
+func TestObserveStep(t *testing.T) {
+	step := idx.LoginStepEmailConfirmation
+	successes := testutil.ToFloat64(loginSteps.WithLabelValues(step.String(), "success"))
+	failures := testutil.ToFloat64(loginSteps.WithLabelValues(step.String(), "error"))
+	rejected := testutil.ToFloat64(apiErrors.WithLabelValues("login", step.String(), "E0000004"))
+	unknown := testutil.ToFloat64(apiErrors.WithLabelValues("login", step.String(), "unknown"))
+
+	observeLoginStep(step, time.Now(), nil)
+	observeLoginStep(step, time.Now(), &idx.ResponseError{ErrorCode: "E0000004"})
+	observeLoginStep(step, time.Now(), errors.New("connection refused"))
+
+	if n := testutil.ToFloat64(loginSteps.WithLabelValues(step.String(), "success")) - successes; n != 1 {
+		t.Errorf("counted %v successful steps, want 1", n)
+	}
+	if n := testutil.ToFloat64(loginSteps.WithLabelValues(step.String(), "error")) - failures; n != 2 {
+		t.Errorf("counted %v failed steps, want 2", n)
+	}
+	if n := testutil.ToFloat64(apiErrors.WithLabelValues("login", step.String(), "E0000004")) - rejected; n != 1 {
+		t.Errorf("counted %v errors with Okta's error code, want 1", n)
+	}
+	if n := testutil.ToFloat64(apiErrors.WithLabelValues("login", step.String(), "unknown")) - unknown; n != 1 {
+		t.Errorf("counted %v errors without a code, want 1", n)
+	}
+}
+
+func TestMetricsHandler(t *testing.T) {
+	loginsStarted.Inc()
+
+	w := httptest.NewRecorder()
+	metricsHandler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "idx_logins_started_total") {
+		t.Errorf("/metrics answered %d: %s", w.Code, w.Body)
+	}
+
+	w = httptest.NewRecorder()
+	metricsHandler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("/ of the metrics address answered %d, want %d", w.Code, http.StatusNotFound)
+	}
+}
@@ -2,7 +2,6 @@ package server
 
 import (
 	"context"
-	"log"
 	"net/http"
 	"time"
 
@@ -10,22 +9,25 @@ import (
 )
 
 func (s *Server) passwordReset(w http.ResponseWriter, r *http.Request) {
-	s.render("resetPassword.gohtml", w, r)
+	s.render("resetPassword.gohtml", w, r, &ViewData{})
 }
 
 func (s *Server) handlePasswordReset(w http.ResponseWriter, r *http.Request) {
+	flow := s.flowState(w, r)
 	// Get session store so we can store our tokens
 	session, err := sessionStore.Get(r, "direct-auth")
 	if err != nil {
-		log.Fatalf("could not get store: %s", err)
+		s.renderError(w, r, internalError(err))
+		return
 	}
-	invEmail, ok := s.ViewData["InvalidEmail"]
+	invEmail, ok := flow.Get("InvalidEmail")
 	var rpr *idx.ResetPasswordResponse
 	if !ok || !invEmail.(bool) {
 		ir := &idx.IdentifyRequest{
 			Identifier: r.FormValue("identifier"),
 		}
 		var err error
+		passwordResetsStarted.Inc()
 		rpr, err = s.idxClient.InitPasswordReset(context.TODO(), ir)
 		if err != nil {
 			session.Values["Errors"] = err.Error()
@@ -34,7 +36,11 @@ func (s *Server) handlePasswordReset(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 	} else {
-		tmp, _ := s.cache.Get("resetPasswordFlow")
+		tmp, found := flow.Get("resetPasswordFlow")
+		if !found {
+			s.renderError(w, r, expiredTransactionError())
+			return
+		}
 		rpr = tmp.(*idx.ResetPasswordResponse)
 	}
 	// At this point, we expect to be able to send an email
@@ -47,17 +53,19 @@ func (s *Server) handlePasswordReset(w http.ResponseWriter, r *http.Request) {
 		http.Redirect(w, r, "/passwordRecovery", http.StatusFound)
 		return
 	}
-	s.cache.Set("resetPasswordFlow", rpr, time.Minute*5)
+	flow.Set("resetPasswordFlow", rpr, time.Minute*5)
 
+	start := time.Now()
 	rpr, err = rpr.VerifyEmail(context.TODO())
+	observePasswordResetStep(idx.ResetPasswordStepEmailVerification, start, err)
 	if err != nil {
-		s.ViewData["InvalidEmail"] = true
+		flow.Set("InvalidEmail", true, time.Minute*5)
 		session.Values["Errors"] = err.Error()
 		session.Save(r, w)
 		http.Redirect(w, r, "/passwordRecovery", http.StatusFound)
 		return
 	}
-	s.ViewData["InvalidEmail"] = false
+	flow.Delete("InvalidEmail")
 	if !rpr.HasStep(idx.ResetPasswordStepEmailConfirmation) {
 		session.Values["Errors"] = "We encountered an unexpected error, please try again"
 		session.Save(r, w)
@@ -65,23 +73,31 @@ func (s *Server) handlePasswordReset(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	s.cache.Set("resetPasswordFlow", rpr, time.Minute*5)
+	flow.Set("resetPasswordFlow", rpr, time.Minute*5)
 
 	http.Redirect(w, r, "/passwordRecovery/code", http.StatusFound)
 	return
 }
 
 func (s *Server) handlePasswordResetCode(w http.ResponseWriter, r *http.Request) {
-	tmp, _ := s.cache.Get("resetPasswordFlow")
+	flow := s.flowState(w, r)
+	tmp, found := flow.Get("resetPasswordFlow")
+	if !found {
+		s.renderError(w, r, expiredTransactionError())
+		return
+	}
 	rpr := tmp.(*idx.ResetPasswordResponse)
 
 	// Get session store so we can store our tokens
 	session, err := sessionStore.Get(r, "direct-auth")
 	if err != nil {
-		log.Fatalf("could not get store: %s", err)
+		s.renderError(w, r, internalError(err))
+		return
 	}
 
+	start := time.Now()
 	rpr, err = rpr.ConfirmEmail(context.TODO(), r.FormValue("code"))
+	observePasswordResetStep(idx.ResetPasswordStepEmailConfirmation, start, err)
 	if err != nil {
 		session.Values["Errors"] = err.Error()
 		session.Save(r, w)
@@ -97,25 +113,27 @@ func (s *Server) handlePasswordResetCode(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	s.cache.Set("resetPasswordFlow", rpr, time.Minute*5)
+	flow.Set("resetPasswordFlow", rpr, time.Minute*5)
 
 	http.Redirect(w, r, "/passwordRecovery/newPassword", http.StatusFound)
 	return
 }
 
 func (s *Server) passwordResetCode(w http.ResponseWriter, r *http.Request) {
-	s.render("resetPasswordCode.gohtml", w, r)
+	s.render("resetPasswordCode.gohtml", w, r, &ViewData{})
 }
 
 func (s *Server) passwordResetNewPassword(w http.ResponseWriter, r *http.Request) {
-	s.render("resetPasswordNewPassword.gohtml", w, r)
+	s.render("resetPasswordNewPassword.gohtml", w, r, &ViewData{})
 }
 
 func (s *Server) handlePasswordResetNewPassword(w http.ResponseWriter, r *http.Request) {
+	flow := s.flowState(w, r)
 	// Get session store so we can store our tokens
 	session, err := sessionStore.Get(r, "direct-auth")
 	if err != nil {
-		log.Fatalf("could not get store: %s", err)
+		s.renderError(w, r, internalError(err))
+		return
 	}
 
 	newPassword := r.FormValue("newPassword")
@@ -128,10 +146,16 @@ func (s *Server) handlePasswordResetNewPassword(w http.ResponseWriter, r *http.R
 		return
 	}
 
-	tmp, _ := s.cache.Get("resetPasswordFlow")
+	tmp, found := flow.Get("resetPasswordFlow")
+	if !found {
+		s.renderError(w, r, expiredTransactionError())
+		return
+	}
 	rpr := tmp.(*idx.ResetPasswordResponse)
 
+	start := time.Now()
 	rpr, err = rpr.SetNewPassword(context.TODO(), newPassword)
+	observePasswordResetStep(idx.ResetPasswordStepNewPassword, start, err)
 	if err != nil {
 		session.Values["Errors"] = err.Error()
 		session.Save(r, w)
@@ -149,11 +173,13 @@ func (s *Server) handlePasswordResetNewPassword(w http.ResponseWriter, r *http.R
 
 	// If we have tokens we have success, so lets store tokens
 	if rpr.Token() != nil {
+		passwordResetsCompleted.Inc()
 		session.Values["access_token"] = rpr.Token().AccessToken
 		session.Values["id_token"] = rpr.Token().IDToken
 		err = session.Save(r, w)
 		if err != nil {
-			log.Fatalf("could not save access token: %s", err)
+			s.renderError(w, r, internalError(err))
+			return
 		}
 	} else {
 		session.Values["Errors"] = "This sample does not support this use case, please review your policy setup and try again."
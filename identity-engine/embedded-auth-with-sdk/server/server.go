@@ -18,6 +18,7 @@ package server
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"html/template"
@@ -26,7 +27,6 @@ import (
 	"net/http"
 	"os"
 	"path"
-	"strings"
 	"time"
 
 	"github.com/gorilla/handlers"
@@ -36,8 +36,10 @@ import (
 	idx "github.com/okta/okta-idx-golang"
 	"github.com/patrickmn/go-cache"
 
+	"github.com/okta/samples-golang/discovery"
 	"github.com/okta/samples-golang/identity-engine/embedded-auth-with-sdk/config"
 	"github.com/okta/samples-golang/identity-engine/embedded-auth-with-sdk/views"
+	"github.com/okta/samples-golang/utils/serve"
 )
 
 type Server struct {
@@ -46,14 +48,11 @@ type Server struct {
 	idxClient *idx.Client
 	session   *sessions.CookieStore
 	view      *views.ViewConfig
-	ViewData  ViewData
 	cache     *cache.Cache
 	svc       *http.Server
 	address   string
 }
 
-type ViewData map[string]interface{}
-
 var sessionStore = sessions.NewCookieStore([]byte("okta-direct-auth-session-store"))
 
 func NewServer(c *config.Config) *Server {
@@ -66,19 +65,14 @@ func NewServer(c *config.Config) *Server {
 	// remain operational needs to be throttled so it doesn't get rate limited
 	// by too many concurrent requests in tests. The idx client allows the
 	// ability to set a custom http client and we make use of that feature here.
-	if c.HttpClient != nil {
-		idx = idx.WithHTTPClient(c.HttpClient)
-	}
+	// Either way the client is wrapped to time the requests made to Okta.
+	idx = idx.WithHTTPClient(instrumentHTTPClient(c.HttpClient))
 
 	return &Server{
 		config:    c,
 		idxClient: idx,
 		session:   sessionStore,
 		cache:     cache.New(5*time.Minute, 10*time.Minute),
-		ViewData: map[string]interface{}{
-			"Authenticated": false,
-			"Errors":        "",
-		},
 	}
 }
 
@@ -94,36 +88,36 @@ func (s *Server) Address() string {
 	return s.address
 }
 
-func (s *Server) Run() {
+// Shutdown stops the server, waiting for in-flight requests until ctx is
+// done.
+func (s *Server) Shutdown(ctx context.Context) error {
+	return s.svc.Shutdown(ctx)
+}
+
+func (s *Server) Run() error {
 	s.parseTemplates()
 
 	go s.watchForTemplates()
 
 	r := mux.NewRouter()
 	r.Use(s.loggingMiddleware)
+	r.Use(s.csrfMiddleware)
 
 	r.HandleFunc("/showView/{view}", s.showView).Methods("GET")
 
 	r.HandleFunc("/login", s.login).Methods("GET")
 	r.HandleFunc("/login", s.handleLogin).Methods("POST")
+	r.HandleFunc("/login/passwordless", s.loginPasswordless).Methods("GET")
+	r.HandleFunc("/login/passwordless", s.handleLoginPasswordless).Methods("POST")
 	r.HandleFunc("/login/factors", s.handleLoginSecondaryFactors).Methods("GET")
 	r.HandleFunc("/login/factors/proceed", s.handleLoginSecondaryFactorsProceed).Methods("POST")
-	r.HandleFunc("/login/factors/email", s.handleLoginEmailVerification).Methods("GET")
-	r.HandleFunc("/login/factors/email", s.handleLoginEmailConfirmation).Methods("POST")
-	r.HandleFunc("/login/factors/security_question", s.handleLoginSecurityQuestion).Methods("GET")
-	r.HandleFunc("/login/factors/security_question", s.handleLoginSecurityQuestionSetup).Methods("POST")
 	r.HandleFunc("/login/factors/phone/method", s.handleLoginPhoneVerificationMethod).Methods("GET")
 	r.HandleFunc("/login/factors/phone", s.handleLoginPhoneVerification).Methods("GET")
-	r.HandleFunc("/login/factors/phone", s.handleLoginPhoneConfirmation).Methods("POST")
 	r.HandleFunc("/login/factors/okta-verify", s.handleLoginOktaVerify).Methods("GET")
-	r.HandleFunc("/login/factors/okta-verify/totp", s.handleLoginOktaVerifyTotp).Methods("GET")
-	r.HandleFunc("/login/factors/okta-verify/totp", s.handleLoginOktaVerifyTotpConfirmation).Methods("POST")
 	r.HandleFunc("/login/factors/okta-verify/push", s.handleLoginOktaVerifyPush).Methods("GET")
 	r.HandleFunc("/login/factors/google_auth", s.handleLoginGoogleAuth).Methods("GET")
-	r.HandleFunc("/login/factors/google_auth", s.handleLoginGoogleAuthConfirmation).Methods("POST")
 	r.HandleFunc("/login/factors/google_auth/init", s.handleLoginGoogleAuthInit).Methods("GET")
-	r.HandleFunc("/login/factors/web_authn", s.handleLoginWebAuthNChallenge).Methods("GET")
-	r.HandleFunc("/login/factors/web_authn", s.handleLoginWebAuthNVerify).Methods("POST")
+	s.registerLoginSteps(r)
 
 	r.HandleFunc("/login/callback", s.handleLoginCallback).Methods("GET")
 
@@ -131,11 +125,7 @@ func (s *Server) Run() {
 	r.HandleFunc("/register", s.handleRegister).Methods("POST")
 	r.HandleFunc("/enrollFactor", s.enrollFactor).Methods("GET")
 	r.HandleFunc("/enrollFactor", s.handleEnrollFactor).Methods("POST")
-	r.HandleFunc("/enrollEmail", s.enrollEmail).Methods("GET")
-	r.HandleFunc("/enrollEmail", s.handleEnrollEmail).Methods("POST")
 	r.HandleFunc("/enrollGoogleAuth", s.enrollGoogleAuth).Methods("GET")
-	r.HandleFunc("/enrollGoogleAuth", s.handleEnrollGoogleAuthQRCode).Methods("POST")
-	r.HandleFunc("/enrollGoogleAuth/code", s.handleEnrollGoogleAuthCode).Methods("POST")
 	r.HandleFunc("/enrollOktaVerify", s.enrollOktaVerify).Methods("GET")
 	r.HandleFunc("/enrollOktaVerify/qr", s.enrollOktaVerifyQR).Methods("GET")
 	r.HandleFunc("/enrollOktaVerify/qr/poll", s.handleEnrollOktaVerifyQR).Methods("POST")
@@ -145,17 +135,13 @@ func (s *Server) Run() {
 	r.HandleFunc("/enrollOktaVerify/email", s.enrollOktaVerifyEmail).Methods("GET")
 	r.HandleFunc("/enrollOktaVerify/email/address", s.handleEnrollOktaVerifyEmailAddress).Methods("POST")
 	r.HandleFunc("/enrollOktaVerify/email/poll", s.handleEnrollOktaVerifyEmail).Methods("POST")
-	r.HandleFunc("/enrollWebAuthN", s.enrollWebAuthN).Methods("GET")
-	r.HandleFunc("/enrollWebAuthN", s.handleEnrollWebAuthN).Methods("POST")
-	r.HandleFunc("/enrollSecurityQuestion", s.enrollSecurityQuestion).Methods("GET")
-	r.HandleFunc("/enrollSecurityQuestion", s.handleEnrollSecurityQuestion).Methods("POST")
 
 	r.HandleFunc("/enrollPhone", s.enrollPhone).Methods("GET")
 	r.HandleFunc("/enrollPhone", s.enrollPhoneMethod).Methods("POST")
 	r.HandleFunc("/enrollPhone/method", s.handleEnrollPhoneMethod).Methods("GET")
-	r.HandleFunc("/enrollPhone/code", s.handleEnrollPhoneCode).Methods("POST")
 	r.HandleFunc("/enrollPassword", s.enrollPassword).Methods("GET")
 	r.HandleFunc("/enrollPassword", s.handleEnrollPassword).Methods("POST")
+	s.registerEnrollmentSteps(r)
 
 	r.HandleFunc("/passwordRecovery", s.passwordReset).Methods("GET")
 	r.HandleFunc("/passwordRecovery", s.handlePasswordReset).Methods("POST")
@@ -164,21 +150,11 @@ func (s *Server) Run() {
 	r.HandleFunc("/passwordRecovery/newPassword", s.passwordResetNewPassword).Methods("GET")
 	r.HandleFunc("/passwordRecovery/newPassword", s.handlePasswordResetNewPassword).Methods("POST")
 
+	s.registerAPIRoutes(r)
+
 	// General Pages
 	r.HandleFunc("/", s.home)
-	r.HandleFunc("/logout", func(w http.ResponseWriter, r *http.Request) {
-		session, err := sessionStore.Get(r, "direct-auth")
-		if err == nil {
-			s.logout(r)
-			delete(session.Values, "id_token")
-			delete(session.Values, "access_token")
-			delete(session.Values, "Errors")
-			session.Save(r, w)
-		}
-		s.cache.Flush()
-
-		http.Redirect(w, r, "/", http.StatusFound)
-	}).Methods("POST")
+	r.HandleFunc("/logout", s.handleLogout).Methods("POST")
 	r.HandleFunc("/logout", func(w http.ResponseWriter, r *http.Request) {
 		// allow GET when not logged in since it is a flow listed in the possilies on the index page
 		if session, err := sessionStore.Get(r, "direct-auth"); err == nil {
@@ -188,18 +164,33 @@ func (s *Server) Run() {
 		http.Redirect(w, r, "/", http.StatusFound)
 	}).Methods("GET")
 	r.HandleFunc("/profile", func(w http.ResponseWriter, r *http.Request) {
-		s.ViewData["Profile"] = s.getProfileData(r)
-		s.render("profile.gohtml", w, r)
+		s.render("profile.gohtml", w, r, &profileView{Profile: s.getProfileData(r)})
 	}).Methods("GET")
 
-	addr := "127.0.0.1:8000"
+	addr := s.config.Address
+	if addr == "" {
+		addr = "127.0.0.1:8000"
+	}
 	logger := log.New(os.Stderr, "http: ", log.LstdFlags)
 
-	credentials := handlers.AllowCredentials()
-	methods := handlers.AllowedMethods([]string{"POST", "GET", "PUT", "DELETE"})
-	origins := handlers.AllowedOrigins([]string{"*"})
+	// Only the configured origins, like a single page app using the JSON API,
+	// may make credentialed requests from other sites.
+	var handler http.Handler = r
+	if len(s.config.AllowedOrigins) > 0 {
+		handler = handlers.CORS(
+			handlers.AllowCredentials(),
+			handlers.AllowedMethods([]string{"POST", "GET", "PUT", "DELETE"}),
+			handlers.AllowedOrigins(s.config.AllowedOrigins),
+			handlers.AllowedHeaders([]string{"Content-Type", csrfHeaderName}),
+			handlers.ExposedHeaders([]string{csrfHeaderName}),
+		)(r)
+	}
 
-	handler := handlers.CORS(credentials, methods, origins)(r)
+	// The metrics get a listener of their own, so they aren't served to
+	// every visitor of the sample.
+	if s.config.MetricsAddress != "" {
+		go serveMetrics(s.config.MetricsAddress)
+	}
 
 	srv := &http.Server{
 		Handler:      handler,
@@ -210,17 +201,30 @@ func (s *Server) Run() {
 	}
 
 	s.svc = srv
-	s.address = srv.Addr
 
-	log.Printf("running sample on addr %q\n", addr)
+	ln, err := serve.Listen(srv, s.config.TLS)
+	if err != nil {
+		return fmt.Errorf("could not listen on %q: %w", addr, err)
+	}
+	s.address = ln.Addr().String()
+
+	scheme := "http"
+	if s.config.TLS.Enabled() {
+		scheme = "https"
+	}
+	log.Printf("running sample on %s://%s\n", scheme, s.address)
 
-	if !s.config.Testing {
-		log.Fatal(srv.ListenAndServe())
-	} else {
+	if s.config.Testing {
+		// The listener is open, so the tests can make requests as soon as
+		// Run returns.
 		go func() {
-			log.Fatal(srv.ListenAndServe())
+			if err := srv.Serve(ln); err != http.ErrServerClosed {
+				log.Fatal(err)
+			}
 		}()
+		return nil
 	}
+	return serve.Serve(srv, ln, s.config.ShutdownTimeout)
 }
 
 func (s *Server) loggingMiddleware(next http.Handler) http.Handler {
@@ -233,17 +237,11 @@ func (s *Server) loggingMiddleware(next http.Handler) http.Handler {
 }
 
 func (s *Server) home(w http.ResponseWriter, r *http.Request) {
-	session, _ := sessionStore.Get(r, "direct-auth")
-	if session.Values["Errors"] != nil {
-		s.ViewData["Errors"] = session.Values["Errors"]
-		delete(session.Values, "Errors")
-		session.Save(r, w)
-	}
-
+	data := &profileView{}
 	if s.IsAuthenticated(r) {
-		s.ViewData["Profile"] = s.getProfileData(r)
+		data.Profile = s.getProfileData(r)
 	}
-	s.render("home.gohtml", w, r)
+	s.render("home.gohtml", w, r, data)
 }
 
 func (s *Server) parseTemplates() {
@@ -300,24 +298,48 @@ func viewPath(filename string) string {
 	return path.Join("views/", filename)
 }
 
-func (s *Server) render(t string, w http.ResponseWriter, r *http.Request) {
+// render executes template t with the request's own view model, filling in
+// the authentication status and any error flashed into the session.
+func (s *Server) render(t string, w http.ResponseWriter, r *http.Request, data viewModel) {
+	s.renderStatus(t, http.StatusOK, w, r, data)
+}
+
+func (s *Server) renderStatus(t string, status int, w http.ResponseWriter, r *http.Request, data viewModel) {
 	session, _ := sessionStore.Get(r, "direct-auth")
 	w.Header().Add("Cache-Control", "no-cache")
 
-	s.ViewData["Authenticated"] = s.IsAuthenticated(r)
+	vd := data.base()
+	vd.Authenticated = s.IsAuthenticated(r)
+	token, err := s.csrfToken(w, r)
+	if err != nil {
+		log.Printf("could not create the CSRF token: %s", err)
+	}
+	vd.CSRFToken = token
 
 	if session.Values["Errors"] != nil {
 		log.Printf("ERROR: %s", session.Values["Errors"])
-		s.ViewData["Errors"] = session.Values["Errors"]
+		if vd.Errors == "" {
+			vd.Errors, _ = session.Values["Errors"].(string)
+		}
 		delete(session.Values, "Errors")
 		session.Save(r, w)
 	}
 
-	if err := s.tpl.ExecuteTemplate(w, t, s.ViewData); err != nil {
-		log.Fatalf("execute templates error: %+v", err)
+	// execute into a buffer so a broken template doesn't leave a half written
+	// page behind and the error page can still be served
+	var buf bytes.Buffer
+	if err := s.tpl.ExecuteTemplate(&buf, t, data); err != nil {
+		if t == "error.gohtml" {
+			log.Printf("execute templates error: %+v", err)
+			http.Error(w, http.StatusText(status), status)
+			return
+		}
+		s.renderError(w, r, internalError(fmt.Errorf("execute template %q: %w", t, err)))
+		return
 	}
 
-	s.ViewData["Errors"] = ""
+	w.WriteHeader(status)
+	buf.WriteTo(w)
 }
 
 func (s *Server) getProfileData(r *http.Request) map[string]string {
@@ -329,30 +351,26 @@ func (s *Server) getProfileData(r *http.Request) map[string]string {
 		return m
 	}
 
-	var reqUrl string
-	issuer := s.idxClient.Config().Okta.IDX.Issuer
-	if strings.Contains(issuer, "oauth2") {
-		reqUrl = issuer + "/v1/userinfo"
-	} else {
-		reqUrl = issuer + "/oauth2/v1/userinfo"
+	oidc, err := discovery.Get(r.Context(), s.idxClient.Config().Okta.IDX.Issuer)
+	if err != nil {
+		log.Printf("could not get the userinfo endpoint: %s", err)
+		return m
 	}
 
-	req, _ := http.NewRequest("GET", reqUrl, bytes.NewReader([]byte("")))
+	req, _ := http.NewRequest("GET", oidc.UserinfoEndpoint, bytes.NewReader([]byte("")))
 	h := req.Header
 	h.Add("Authorization", "Bearer "+session.Values["access_token"].(string))
 	h.Add("Accept", "application/json")
 
 	client := &http.Client{Timeout: time.Second * 30}
-	resp, _ := client.Do(req)
-	body, _ := ioutil.ReadAll(resp.Body)
+	resp, err := client.Do(req)
+	if err != nil {
+		log.Printf("could not get the profile: %s", err)
+		return m
+	}
 	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
 	json.Unmarshal(body, &m)
 
 	return m
 }
-
-func (s *Server) showView(w http.ResponseWriter, r *http.Request) {
-	view := mux.Vars(r)["view"]
-
-	s.render(fmt.Sprintf("%s.gohtml", view), w, r)
-}
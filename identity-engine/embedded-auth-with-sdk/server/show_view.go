@@ -0,0 +1,80 @@
+/**
+ * Copyright 2021 - Present Okta, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package server
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// viewAccess is who may see a view served by /showView/{view}.
+type viewAccess int
+
+const (
+	viewPublic viewAccess = iota
+	// The views of a flow need the flow to be in progress.
+	viewEnrollmentFlow
+	viewPasswordResetFlow
+)
+
+// routableViews are the templates /showView/{view} may render, by view name.
+// Only pages whose template needs nothing but ViewData belong here, partials
+// and pages built by their own handler, like the signed in user's profile,
+// never do.
+var routableViews = map[string]viewAccess{
+	"register":                 viewPublic,
+	"resetPassword":            viewPublic,
+	"enrollPassword":           viewEnrollmentFlow,
+	"enrollPhone":              viewEnrollmentFlow,
+	"enrollPhoneMethod":        viewEnrollmentFlow,
+	"enrollOktaVerify":         viewEnrollmentFlow,
+	"enrollOktaVerifySMS":      viewEnrollmentFlow,
+	"enrollOktaVerifyEmail":    viewEnrollmentFlow,
+	"resetPasswordCode":        viewPasswordResetFlow,
+	"resetPasswordNewPassword": viewPasswordResetFlow,
+}
+
+func (s *Server) showView(w http.ResponseWriter, r *http.Request) {
+	view := mux.Vars(r)["view"]
+	access, ok := routableViews[view]
+	if !ok || s.tpl.Lookup(view+".gohtml") == nil {
+		s.renderError(w, r, notFoundError(fmt.Sprintf("There is no %q page", view)))
+		return
+	}
+	if !s.canView(w, r, access) {
+		http.Redirect(w, r, "/login", http.StatusFound)
+		return
+	}
+	s.render(view+".gohtml", w, r, &ViewData{})
+}
+
+// canView reports whether the caller has the access a view needs.
+func (s *Server) canView(w http.ResponseWriter, r *http.Request, access viewAccess) bool {
+	flowKey := ""
+	switch access {
+	case viewPublic:
+		return true
+	case viewEnrollmentFlow:
+		flowKey = "enrollResponse"
+	case viewPasswordResetFlow:
+		flowKey = "resetPasswordFlow"
+	}
+	_, found := s.flowState(w, r).Get(flowKey)
+	return found
+}
@@ -0,0 +1,104 @@
+/**
+ * Copyright 2021 - Present Okta, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package server
+
+import (
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/patrickmn/go-cache"
+)
+
+func TestRoutableViewsHaveTemplates(t *testing.T) {
+	for view := range routableViews {
+		if strings.HasPrefix(view, "_") {
+			t.Errorf("%s: partials can't be shown on their own", view)
+		}
+		if _, err := os.Stat(filepath.Join("..", "views", view+".gohtml")); err != nil {
+			t.Errorf("%s: %s", view, err)
+		}
+	}
+}
+
+// newShowViewTestServer serves /showView with a template named after each
+// view that prints the name, instead of the real pages.
+func newShowViewTestServer() (*Server, http.Handler) {
+	tpl := template.New("")
+	for _, name := range []string{"register", "enrollPassword", "resetPasswordCode", "_csrf", "profile", "error"} {
+		template.Must(tpl.New(name + ".gohtml").Parse(name))
+	}
+	s := &Server{tpl: tpl, cache: cache.New(time.Minute, time.Minute)}
+
+	r := mux.NewRouter()
+	r.HandleFunc("/showView/{view}", s.showView).Methods("GET")
+	return s, r
+}
+
+func TestShowView(t *testing.T) {
+	tests := []struct {
+		name       string
+		view       string
+		flow       string
+		wantStatus int
+		wantBody   string
+	}{
+		{name: "public view", view: "register", wantStatus: http.StatusOK, wantBody: "register"},
+		{name: "unknown view", view: "nothing", wantStatus: http.StatusNotFound},
+		{name: "partial", view: "_csrf", wantStatus: http.StatusNotFound},
+		{name: "page with a handler of its own", view: "profile", wantStatus: http.StatusNotFound},
+		{name: "allowed view without a template", view: "enrollPhone", wantStatus: http.StatusNotFound},
+		{name: "enrollment view outside the flow", view: "enrollPassword", wantStatus: http.StatusFound},
+		{name: "enrollment view in the flow", view: "enrollPassword", flow: "enrollResponse", wantStatus: http.StatusOK, wantBody: "enrollPassword"},
+		{name: "enrollment view in another flow", view: "enrollPassword", flow: "resetPasswordFlow", wantStatus: http.StatusFound},
+		{name: "password reset view in the flow", view: "resetPasswordCode", flow: "resetPasswordFlow", wantStatus: http.StatusOK, wantBody: "resetPasswordCode"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, handler := newShowViewTestServer()
+
+			r := httptest.NewRequest(http.MethodGet, "/showView/"+tt.view, nil)
+			if tt.flow != "" {
+				// Start the flow in a session of its own
+				w := httptest.NewRecorder()
+				s.flowState(w, httptest.NewRequest(http.MethodGet, "/", nil)).Set(tt.flow, true, time.Minute)
+				for _, c := range w.Result().Cookies() {
+					r.AddCookie(c)
+				}
+			}
+
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if tt.wantStatus == http.StatusFound && w.Header().Get("Location") != "/login" {
+				t.Errorf("redirected to %q, want /login", w.Header().Get("Location"))
+			}
+			if tt.wantBody != "" && w.Body.String() != tt.wantBody {
+				t.Errorf("rendered %q, want %q", w.Body.String(), tt.wantBody)
+			}
+		})
+	}
+}
@@ -0,0 +1,317 @@
+/**
+ * Copyright 2021 - Present Okta, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package server
+
+import (
+	"context"
+	"html/template"
+
+	idx "github.com/okta/okta-idx-golang"
+)
+
+// The authenticator steps served by the engine in steps.go. To support
+// another authenticator add its definition to loginStepDefs or
+// enrollmentStepDefs, with a template of its own when step.gohtml's form of
+// inputs won't do.
+
+func codeFields(label string) []formField {
+	return []formField{{Name: "code", Label: label, Required: true}}
+}
+
+var securityQuestionFields = []formField{
+	{Name: "question", Label: "Choose a security question", Required: true},
+	{Name: "custom_question", Label: "Create my own security question"},
+	{Name: "answer", Label: "Answer", Required: true},
+}
+
+func securityQuestion(in stepInput) *idx.SecurityQuestion {
+	return &idx.SecurityQuestion{
+		QuestionKey: in["question"],
+		Question:    in["custom_question"],
+		Answer:      in["answer"],
+	}
+}
+
+func securityQuestionOptionsView(data interface{}, _ bool) viewModel {
+	questions, _ := data.(idx.SecurityQuestions)
+	return &securityQuestionView{Questions: questions}
+}
+
+// BEGIN: Login
+
+var (
+	loginEmailStep = &loginStepDef{
+		stepForm: stepForm{
+			path:     "/login/factors/email",
+			template: "loginFactorEmail.gohtml",
+			fields:   codeFields("Enter the Code from your Email"),
+			view: func(_ interface{}, rejected bool) viewModel {
+				return &emailCodeView{InvalidEmailCode: rejected}
+			},
+			apiChallenge: "/email",
+			apiSubmit:    "/email/confirm",
+		},
+		challengeStep: idx.LoginStepEmailVerification,
+		challenge: func(ctx context.Context, lr *idx.LoginResponse) (*idx.LoginResponse, interface{}, error) {
+			lr, err := lr.VerifyEmail(ctx)
+			return lr, nil, err
+		},
+		step: idx.LoginStepEmailConfirmation,
+		submit: func(ctx context.Context, lr *idx.LoginResponse, in stepInput) (*idx.LoginResponse, error) {
+			return lr.ConfirmEmail(ctx, in["code"])
+		},
+	}
+
+	// The phone page is handleLoginPhoneVerification, which sends the code
+	// by the method the user picked.
+	loginPhoneStep = &loginStepDef{
+		stepForm: stepForm{
+			path:      "/login/factors/phone",
+			page:      "/login/factors/phone",
+			title:     "Factor Login",
+			fields:    codeFields("Enter the Code we sent to your phone by SMS or Voice"),
+			apiSubmit: "/phone/confirm",
+		},
+		step: idx.LoginStepPhoneConfirmation,
+		submit: func(ctx context.Context, lr *idx.LoginResponse, in stepInput) (*idx.LoginResponse, error) {
+			return lr.ConfirmPhone(ctx, in["code"])
+		},
+	}
+
+	loginOktaVerifyTotpStep = &loginStepDef{
+		stepForm: stepForm{
+			path:      "/login/factors/okta-verify/totp",
+			title:     "Factor Login",
+			fields:    codeFields("Enter the Code from your Okta Verify App"),
+			apiSubmit: "/okta-verify/totp",
+		},
+		step: idx.LoginStepOktaVerify,
+		submit: func(ctx context.Context, lr *idx.LoginResponse, in stepInput) (*idx.LoginResponse, error) {
+			return lr.OktaVerifyConfirm(ctx, in["code"])
+		},
+	}
+
+	// The Google Authenticator page is handleLoginGoogleAuth, which first
+	// shows the QR code when the authenticator is still to be set up.
+	loginGoogleAuthStep = &loginStepDef{
+		stepForm: stepForm{
+			path:      "/login/factors/google_auth",
+			page:      "/login/factors/google_auth",
+			title:     "Factor Login",
+			fields:    codeFields("Enter the Code from your Google Auth App"),
+			apiSubmit: "/google-auth/confirm",
+		},
+		step: idx.LoginStepGoogleAuthenticatorConfirmation,
+		submit: func(ctx context.Context, lr *idx.LoginResponse, in stepInput) (*idx.LoginResponse, error) {
+			return lr.GoogleAuthConfirm(ctx, in["code"])
+		},
+	}
+
+	loginSecurityQuestionStep = &loginStepDef{
+		stepForm: stepForm{
+			path:         "/login/factors/security_question",
+			template:     "loginSetupSecurityQuestion.gohtml",
+			fields:       securityQuestionFields,
+			view:         securityQuestionOptionsView,
+			apiChallenge: "/security-question/options",
+			apiSubmit:    "/security-question",
+		},
+		challengeStep: idx.LoginStepSecurityQuestionOptions,
+		challenge: func(ctx context.Context, lr *idx.LoginResponse) (*idx.LoginResponse, interface{}, error) {
+			return lr.SecurityQuestionOptions(ctx)
+		},
+		step: idx.LoginStepSecurityQuestionSetup,
+		submit: func(ctx context.Context, lr *idx.LoginResponse, in stepInput) (*idx.LoginResponse, error) {
+			return lr.SecurityQuestionSetup(ctx, securityQuestion(in))
+		},
+	}
+
+	// The WebAuthn page posts the assertion of the authenticator as JSON.
+	loginWebAuthNStep = &loginStepDef{
+		stepForm: stepForm{
+			path:     "/login/factors/web_authn",
+			template: "loginWebAuthN.gohtml",
+			fields: []formField{
+				{Name: "clientData", Type: "hidden", Required: true},
+				{Name: "authenticatorData", Type: "hidden", Required: true},
+				{Name: "signatureData", Type: "hidden", Required: true},
+			},
+			view: func(data interface{}, _ bool) viewModel {
+				cd, _ := data.(*idx.ChallengeData)
+				if cd == nil {
+					cd = &idx.ChallengeData{}
+				}
+				return &webAuthNChallengeView{
+					Challenge:            template.URL(cd.Challenge),
+					WebauthnCredentialID: template.URL(cd.CredentialID),
+				}
+			},
+			apiChallenge: "/webauthn/challenge",
+			apiSubmit:    "/webauthn/verify",
+		},
+		challengeStep: idx.LoginStepWebAuthNChallenge,
+		challenge: func(ctx context.Context, lr *idx.LoginResponse) (*idx.LoginResponse, interface{}, error) {
+			lr, err := lr.WebAuthNChallenge(ctx)
+			if err != nil {
+				return lr, nil, err
+			}
+			return lr, lr.ContextualData().ChallengeData, nil
+		},
+		step: idx.LoginStepWebAuthNVerify,
+		submit: func(ctx context.Context, lr *idx.LoginResponse, in stepInput) (*idx.LoginResponse, error) {
+			return lr.WebAuthNVerify(ctx, &idx.WebAuthNChallengeCredentials{
+				ClientData:        in["clientData"],
+				AuthenticatorData: in["authenticatorData"],
+				SignatureData:     in["signatureData"],
+			})
+		},
+	}
+)
+
+var loginStepDefs = []*loginStepDef{
+	loginEmailStep,
+	loginPhoneStep,
+	loginOktaVerifyTotpStep,
+	loginGoogleAuthStep,
+	loginSecurityQuestionStep,
+	loginWebAuthNStep,
+}
+
+// END: Login
+
+// BEGIN: Registration
+
+var (
+	enrollEmailStep = &enrollmentStepDef{
+		stepForm: stepForm{
+			path:         "/enrollEmail",
+			title:        "Factor Enrollment",
+			fields:       codeFields("Enter the Code from your Email"),
+			apiChallenge: "/email",
+			apiSubmit:    "/email/confirm",
+		},
+		challengeStep: idx.EnrollmentStepEmailVerification,
+		challenge: func(ctx context.Context, er *idx.EnrollmentResponse) (*idx.EnrollmentResponse, interface{}, error) {
+			er, err := er.VerifyEmail(ctx)
+			return er, nil, err
+		},
+		step: idx.EnrollmentStepEmailConfirmation,
+		submit: func(ctx context.Context, er *idx.EnrollmentResponse, in stepInput) (*idx.EnrollmentResponse, error) {
+			return er.ConfirmEmail(ctx, in["code"])
+		},
+	}
+
+	// The phone page is handleEnrollPhoneMethod, which sends the code to
+	// the number and by the method the user picked.
+	enrollPhoneStep = &enrollmentStepDef{
+		stepForm: stepForm{
+			path:      "/enrollPhone/code",
+			page:      "/enrollPhone/method",
+			title:     "Factor Enrollment",
+			fields:    codeFields("Enter the Code we sent to your phone by SMS or Voice"),
+			apiSubmit: "/phone/confirm",
+		},
+		step: idx.EnrollmentStepPhoneConfirmation,
+		submit: func(ctx context.Context, er *idx.EnrollmentResponse, in stepInput) (*idx.EnrollmentResponse, error) {
+			return er.ConfirmPhone(ctx, in["code"])
+		},
+	}
+
+	// The QR code to scan is shown by enrollGoogleAuth.
+	enrollGoogleAuthStep = &enrollmentStepDef{
+		stepForm: stepForm{
+			path:      "/enrollGoogleAuth/code",
+			title:     "Factor Enrollment",
+			fields:    codeFields("Enter the Code from your Google Auth App"),
+			apiSubmit: "/google-auth/confirm",
+		},
+		step: idx.EnrollmentStepGoogleAuthenticatorConfirmation,
+		submit: func(ctx context.Context, er *idx.EnrollmentResponse, in stepInput) (*idx.EnrollmentResponse, error) {
+			return er.GoogleAuthConfirm(ctx, in["code"])
+		},
+	}
+
+	enrollSecurityQuestionStep = &enrollmentStepDef{
+		stepForm: stepForm{
+			path:         "/enrollSecurityQuestion",
+			template:     "enrollSecurityQuestion.gohtml",
+			fields:       securityQuestionFields,
+			view:         securityQuestionOptionsView,
+			apiChallenge: "/security-question/options",
+			apiSubmit:    "/security-question",
+		},
+		challengeStep: idx.EnrollmentStepSecurityQuestionOptions,
+		challenge: func(ctx context.Context, er *idx.EnrollmentResponse) (*idx.EnrollmentResponse, interface{}, error) {
+			return er.SecurityQuestionOptions(ctx)
+		},
+		step: idx.EnrollmentStepSecurityQuestionSetup,
+		submit: func(ctx context.Context, er *idx.EnrollmentResponse, in stepInput) (*idx.EnrollmentResponse, error) {
+			return er.SetupSecurityQuestion(ctx, securityQuestion(in))
+		},
+	}
+
+	// The WebAuthn page posts the attestation of the new credential as JSON.
+	enrollWebAuthNStep = &enrollmentStepDef{
+		stepForm: stepForm{
+			path:     "/enrollWebAuthN",
+			template: "enrollWebAuthN.gohtml",
+			fields: []formField{
+				{Name: "attestation", Type: "hidden", Required: true},
+				{Name: "clientData", Type: "hidden", Required: true},
+			},
+			view: func(data interface{}, _ bool) viewModel {
+				ad, _ := data.(*idx.ActivationData)
+				if ad == nil {
+					ad = &idx.ActivationData{}
+				}
+				return &webAuthNSetupView{
+					Challenge:   ad.Challenge,
+					UserID:      ad.User.ID,
+					Username:    ad.User.Name,
+					DisplayName: ad.User.DisplayName,
+				}
+			},
+			apiChallenge: "/webauthn/setup",
+			apiSubmit:    "/webauthn/verify",
+		},
+		challengeStep: idx.EnrollmentStepWebAuthNSetup,
+		challenge: func(ctx context.Context, er *idx.EnrollmentResponse) (*idx.EnrollmentResponse, interface{}, error) {
+			er, err := er.WebAuthNSetup(ctx)
+			if err != nil {
+				return er, nil, err
+			}
+			return er, er.ContextualData().ActivationData, nil
+		},
+		step: idx.EnrollmentStepWebAuthNVerify,
+		submit: func(ctx context.Context, er *idx.EnrollmentResponse, in stepInput) (*idx.EnrollmentResponse, error) {
+			return er.WebAuthNVerify(ctx, &idx.WebAuthNVerifyCredentials{
+				Attestation: in["attestation"],
+				ClientData:  in["clientData"],
+			})
+		},
+	}
+)
+
+var enrollmentStepDefs = []*enrollmentStepDef{
+	enrollEmailStep,
+	enrollPhoneStep,
+	enrollGoogleAuthStep,
+	enrollSecurityQuestionStep,
+	enrollWebAuthNStep,
+}
+
+// END: Registration
@@ -0,0 +1,178 @@
+/**
+ * Copyright 2021 - Present Okta, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// checkStepForms checks what the engine relies on in the stepForm of every
+// definition of a table.
+func checkStepForms(t *testing.T, forms []*stepForm) {
+	t.Helper()
+	paths := map[string]bool{}
+	apiPaths := map[string]bool{}
+	for _, f := range forms {
+		if f.path == "" || !strings.HasPrefix(f.path, "/") {
+			t.Errorf("step has path %q, want an absolute path", f.path)
+			continue
+		}
+		if paths[f.path] {
+			t.Errorf("%s: path is used by two steps", f.path)
+		}
+		paths[f.path] = true
+
+		for _, api := range []string{f.apiChallenge, f.apiSubmit} {
+			if api == "" {
+				continue
+			}
+			if apiPaths[api] {
+				t.Errorf("%s: API path %s is used by two steps", f.path, api)
+			}
+			apiPaths[api] = true
+		}
+		if f.apiSubmit == "" {
+			t.Errorf("%s: has no API path", f.path)
+		}
+
+		if len(f.fields) == 0 {
+			t.Errorf("%s: has no fields", f.path)
+		}
+		if f.template == "" && f.title == "" {
+			t.Errorf("%s: step.gohtml needs a title", f.path)
+		}
+		if f.template != "" {
+			if _, err := os.Stat(filepath.Join("..", "views", f.template)); err != nil {
+				t.Errorf("%s: template: %s", f.path, err)
+			}
+		}
+	}
+}
+
+func TestLoginStepDefs(t *testing.T) {
+	var forms []*stepForm
+	for _, def := range loginStepDefs {
+		forms = append(forms, &def.stepForm)
+		if def.step == 0 || def.submit == nil {
+			t.Errorf("%s: needs a step and a submit", def.path)
+		}
+		if (def.challenge == nil) != (def.challengeStep == 0) {
+			t.Errorf("%s: challenge and challengeStep go together", def.path)
+		}
+		if (def.challenge == nil) != (def.apiChallenge == "") {
+			t.Errorf("%s: the JSON API serves the challenge under apiChallenge", def.path)
+		}
+	}
+	checkStepForms(t, forms)
+}
+
+func TestEnrollmentStepDefs(t *testing.T) {
+	var forms []*stepForm
+	for _, def := range enrollmentStepDefs {
+		forms = append(forms, &def.stepForm)
+		if def.step == 0 || def.submit == nil {
+			t.Errorf("%s: needs a step and a submit", def.path)
+		}
+		if (def.challenge == nil) != (def.challengeStep == 0) {
+			t.Errorf("%s: challenge and challengeStep go together", def.path)
+		}
+		if (def.challenge == nil) != (def.apiChallenge == "") {
+			t.Errorf("%s: the JSON API serves the challenge under apiChallenge", def.path)
+		}
+	}
+	checkStepForms(t, forms)
+}
+
+func TestStepFormInput(t *testing.T) {
+	f := &stepForm{
+		path:   "/test",
+		fields: securityQuestionFields,
+	}
+
+	tests := []struct {
+		name    string
+		form    url.Values
+		json    string
+		want    stepInput
+		wantErr bool
+	}{
+		{
+			name: "form",
+			form: url.Values{"question": {"disliked_food"}, "answer": {"kale"}, "other": {"ignored"}},
+			want: stepInput{"question": "disliked_food", "custom_question": "", "answer": "kale"},
+		},
+		{
+			name:    "form missing a required field",
+			form:    url.Values{"question": {"disliked_food"}},
+			wantErr: true,
+		},
+		{
+			name: "json",
+			json: `{"question": "custom", "custom_question": "Why?", "answer": "Because", "other": "ignored"}`,
+			want: stepInput{"question": "custom", "custom_question": "Why?", "answer": "Because"},
+		},
+		{
+			name:    "json missing a required field",
+			json:    `{"question": "custom"}`,
+			wantErr: true,
+		},
+		{
+			name:    "json with a number",
+			json:    `{"question": "custom", "answer": 42}`,
+			wantErr: true,
+		},
+		{
+			name:    "invalid json",
+			json:    `{"question":`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var r *http.Request
+			if tt.json != "" {
+				r = httptest.NewRequest(http.MethodPost, f.path, strings.NewReader(tt.json))
+				r.Header.Set("Content-Type", "application/json")
+			} else {
+				r = httptest.NewRequest(http.MethodPost, f.path, strings.NewReader(tt.form.Encode()))
+				r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			}
+
+			in, err := f.input(httptest.NewRecorder(), r, tt.json != "")
+			if tt.wantErr {
+				ae, ok := err.(*appError)
+				if !ok || ae.kind != errorKindInvalidInput {
+					t.Errorf("input() error = %v, want an invalid input error", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(in, tt.want) {
+				t.Errorf("input() = %v, want %v", in, tt.want)
+			}
+		})
+	}
+}
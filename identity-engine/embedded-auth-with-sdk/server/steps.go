@@ -0,0 +1,542 @@
+/**
+ * Copyright 2021 - Present Okta, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	idx "github.com/okta/okta-idx-golang"
+)
+
+// The authenticator steps of the login and the registration are served from
+// the definitions in step_defs.go. A definition names the step its form
+// submits, the fields of the form and the SDK call that takes the step, and
+// optionally a challenge that has to come first, like having Okta send a
+// code. The engine below serves the page, takes the step when the form is
+// posted, stores the tokens once the flow is done and otherwise sends the
+// user on to the next factor. The JSON API serves the same definitions.
+
+// formField is an input of a step's form. HTML forms post it under Name, JSON
+// API clients send it under the same name.
+type formField struct {
+	Name     string
+	Label    string
+	Type     string
+	Required bool
+}
+
+// InputType is the type of the field's input element.
+func (f formField) InputType() string {
+	if f.Type == "" {
+		return "text"
+	}
+	return f.Type
+}
+
+// stepInput holds the values a step was submitted with, by field name.
+type stepInput map[string]string
+
+// stepForm is the part of a step definition logins and registrations share.
+type stepForm struct {
+	// path serves the page of the step and takes its form posts.
+	path string
+	// page is set when a handler of its own shows the form, the engine then
+	// only takes the posts to path. Rejected posts are sent back to page.
+	page string
+	// template renders the page. When empty step.gohtml renders title and
+	// fields.
+	template string
+	title    string
+	fields   []formField
+	// view builds the view model of template from the challenge's data.
+	// rejected is set when the page is shown again because Okta turned down
+	// the last post.
+	view func(data interface{}, rejected bool) viewModel
+	// apiChallenge and apiSubmit are where the JSON API takes the challenge
+	// and the step, under the API path of the flow.
+	apiChallenge string
+	apiSubmit    string
+}
+
+type stepView struct {
+	ViewData
+	Title  string
+	Action string
+	Fields []formField
+}
+
+func (f *stepForm) pagePath() string {
+	if f.page != "" {
+		return f.page
+	}
+	return f.path
+}
+
+// rejectedKey is the flow state set while the last post of the step was
+// turned down.
+func (f *stepForm) rejectedKey() string {
+	return f.path + ":rejected"
+}
+
+// dataKey is the flow state holding what the challenge returned, so the page
+// can be shown again without taking the challenge twice.
+func (f *stepForm) dataKey() string {
+	return f.path + ":data"
+}
+
+// input reads the fields of the step from the form post, or from the JSON
+// body when fromJSON is set.
+func (f *stepForm) input(w http.ResponseWriter, r *http.Request, fromJSON bool) (stepInput, error) {
+	in := stepInput{}
+	if fromJSON {
+		var body map[string]interface{}
+		defer r.Body.Close()
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&body); err != nil {
+			return nil, invalidInputError("The request body is not valid JSON for this step", err)
+		}
+		for _, field := range f.fields {
+			if v, ok := body[field.Name].(string); ok {
+				in[field.Name] = v
+			}
+		}
+	} else {
+		for _, field := range f.fields {
+			in[field.Name] = r.FormValue(field.Name)
+		}
+	}
+
+	for _, field := range f.fields {
+		if field.Required && in[field.Name] == "" {
+			return nil, invalidInputError(fmt.Sprintf("The %s field is required", field.Name), nil)
+		}
+	}
+	return in, nil
+}
+
+// renderStep shows the form of a step.
+func (s *Server) renderStep(w http.ResponseWriter, r *http.Request, f *stepForm, data interface{}, rejected bool) {
+	if f.template == "" {
+		s.render("step.gohtml", w, r, &stepView{Title: f.title, Action: f.path, Fields: f.fields})
+		return
+	}
+	var vm viewModel = &ViewData{}
+	if f.view != nil {
+		vm = f.view(data, rejected)
+	}
+	s.render(f.template, w, r, vm)
+}
+
+// isJSON reports whether the body of r is JSON, like the posts of the
+// WebAuthn pages.
+func isJSON(r *http.Request) bool {
+	mt, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return mt == "application/json"
+}
+
+// BEGIN: Login
+
+// loginStepDef is an authenticator step of the login.
+type loginStepDef struct {
+	stepForm
+	step   idx.LoginStep
+	submit func(ctx context.Context, lr *idx.LoginResponse, in stepInput) (*idx.LoginResponse, error)
+	// challenge takes challengeStep when the page is shown and the flow
+	// can't take step yet. What it returns is handed to the view.
+	challengeStep idx.LoginStep
+	challenge     func(ctx context.Context, lr *idx.LoginResponse) (*idx.LoginResponse, interface{}, error)
+}
+
+func (s *Server) registerLoginSteps(r *mux.Router) {
+	for _, def := range loginStepDefs {
+		if def.page == "" {
+			r.HandleFunc(def.path, s.loginStepPage(def)).Methods("GET")
+		}
+		r.HandleFunc(def.path, s.loginStepSubmit(def)).Methods("POST")
+	}
+}
+
+func (s *Server) loginStepPage(def *loginStepDef) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		flow := s.flowState(w, r)
+		clr, found := flow.Get("loginResponse")
+		if !found {
+			s.renderError(w, r, expiredTransactionError())
+			return
+		}
+		lr := clr.(*idx.LoginResponse)
+		canChallenge := def.challenge != nil && lr.HasStep(def.challengeStep)
+		if !lr.HasStep(def.step) && !canChallenge {
+			http.Redirect(w, r, "/login/factors", http.StatusFound)
+			return
+		}
+
+		data, found := flow.Get(def.dataKey())
+		if canChallenge && (!lr.HasStep(def.step) || !found) {
+			var err error
+			lr, data, err = s.loginChallenge(r.Context(), flow, lr, def)
+			if err != nil {
+				s.flashError(w, r, rejectedStepError(err))
+				http.Redirect(w, r, "/login/factors", http.StatusFound)
+				return
+			}
+		}
+
+		// set the idx state string in the session for inspection for otp login callback comparison.
+		session, err := sessionStore.Get(r, "direct-auth")
+		if err != nil {
+			s.renderError(w, r, internalError(err))
+			return
+		}
+		session.Values["idxContext.state"] = lr.Context().State
+		if err := session.Save(r, w); err != nil {
+			s.renderError(w, r, internalError(err))
+			return
+		}
+
+		_, rejected := flow.Get(def.rejectedKey())
+		s.renderStep(w, r, &def.stepForm, data, rejected)
+	}
+}
+
+func (s *Server) loginStepSubmit(def *loginStepDef) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		flow := s.flowState(w, r)
+		clr, found := flow.Get("loginResponse")
+		if !found {
+			s.renderError(w, r, expiredTransactionError())
+			return
+		}
+		lr := clr.(*idx.LoginResponse)
+		if !lr.HasStep(def.step) {
+			http.Redirect(w, r, "/login/factors", http.StatusFound)
+			return
+		}
+
+		in, err := def.input(w, r, isJSON(r))
+		if err == nil {
+			lr, err = s.takeLoginStep(r.Context(), flow, lr, def, in)
+		}
+		if err != nil {
+			s.flashError(w, r, err)
+			http.Redirect(w, r, def.pagePath(), http.StatusFound)
+			return
+		}
+
+		// If we have tokens we have success, so lets store tokens
+		if lr.Token() != nil {
+			if err := s.storeTokens(w, r, lr.Token()); err != nil {
+				s.renderError(w, r, internalError(err))
+				return
+			}
+			http.Redirect(w, r, "/", http.StatusFound)
+			return
+		}
+		http.Redirect(w, r, "/login/factors", http.StatusFound)
+	}
+}
+
+func (s *Server) apiLoginChallenge(def *loginStepDef) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		flow := s.flowState(w, r)
+		clr, found := flow.Get("loginResponse")
+		if !found {
+			s.writeAPIError(w, r, expiredTransactionError())
+			return
+		}
+		lr := clr.(*idx.LoginResponse)
+		if !lr.HasStep(def.challengeStep) {
+			s.writeAPIError(w, r, unavailableStepError(def.challengeStep.String(), loginStepNames(lr)))
+			return
+		}
+		lr, data, err := s.loginChallenge(r.Context(), flow, lr, def)
+		if err != nil {
+			s.writeAPIError(w, r, rejectedStepError(err))
+			return
+		}
+		s.writeJSON(w, http.StatusOK, loginFlowResponse(lr, data))
+	}
+}
+
+func (s *Server) apiLoginSubmit(def *loginStepDef) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		flow := s.flowState(w, r)
+		clr, found := flow.Get("loginResponse")
+		if !found {
+			s.writeAPIError(w, r, expiredTransactionError())
+			return
+		}
+		lr := clr.(*idx.LoginResponse)
+		if !lr.HasStep(def.step) {
+			s.writeAPIError(w, r, unavailableStepError(def.step.String(), loginStepNames(lr)))
+			return
+		}
+		in, err := def.input(w, r, true)
+		if err != nil {
+			s.writeAPIError(w, r, err)
+			return
+		}
+		lr, err = s.takeLoginStep(r.Context(), flow, lr, def, in)
+		if err != nil {
+			s.writeAPIError(w, r, err)
+			return
+		}
+		if lr.Token() != nil {
+			if err := s.storeTokens(w, r, lr.Token()); err != nil {
+				s.writeAPIError(w, r, internalError(err))
+				return
+			}
+		}
+		s.writeJSON(w, http.StatusOK, loginFlowResponse(lr, nil))
+	}
+}
+
+// loginChallenge takes the challenge of def and keeps its result in flow.
+func (s *Server) loginChallenge(ctx context.Context, flow *flowState, lr *idx.LoginResponse, def *loginStepDef) (*idx.LoginResponse, interface{}, error) {
+	start := time.Now()
+	next, data, err := def.challenge(ctx, lr)
+	observeLoginStep(def.challengeStep, start, err)
+	if err != nil {
+		return lr, nil, err
+	}
+	flow.Set("loginResponse", next, time.Minute*5)
+	flow.Set(def.dataKey(), data, time.Minute*5)
+	flow.Delete(def.rejectedKey())
+	// the magic link of an email may be opened in another browser, see
+	// handleLoginCallback. Other challenges send no link to follow.
+	if def.challengeStep == idx.LoginStepEmailVerification {
+		s.cache.Set(magicLinkKey(next.Context().State), flow.transactionID, time.Minute*5)
+	}
+	return next, data, nil
+}
+
+// takeLoginStep submits in to the step of def. Unless that ends the login,
+// the flow moves on to the steps that follow.
+func (s *Server) takeLoginStep(ctx context.Context, flow *flowState, lr *idx.LoginResponse, def *loginStepDef, in stepInput) (*idx.LoginResponse, error) {
+	start := time.Now()
+	next, err := def.submit(ctx, lr, in)
+	observeLoginStep(def.step, start, err)
+	if err != nil {
+		flow.Set(def.rejectedKey(), true, time.Minute*5)
+		return nil, rejectedStepError(err)
+	}
+	flow.Delete(def.rejectedKey())
+	flow.Delete(def.dataKey())
+
+	if next.Token() != nil {
+		loginsCompleted.Inc()
+		flow.Delete("loginResponse")
+		return next, nil
+	}
+	next, err = next.WhereAmI(ctx)
+	if err != nil {
+		return nil, upstreamError(err)
+	}
+	flow.Set("loginResponse", next, time.Minute*5)
+	return next, nil
+}
+
+// END: Login
+
+// BEGIN: Registration
+
+// enrollmentStepDef is an authenticator step of the registration.
+type enrollmentStepDef struct {
+	stepForm
+	step          idx.EnrollmentStep
+	submit        func(ctx context.Context, er *idx.EnrollmentResponse, in stepInput) (*idx.EnrollmentResponse, error)
+	challengeStep idx.EnrollmentStep
+	challenge     func(ctx context.Context, er *idx.EnrollmentResponse) (*idx.EnrollmentResponse, interface{}, error)
+}
+
+func (s *Server) registerEnrollmentSteps(r *mux.Router) {
+	for _, def := range enrollmentStepDefs {
+		if def.page == "" {
+			r.HandleFunc(def.path, s.enrollmentStepPage(def)).Methods("GET")
+		}
+		r.HandleFunc(def.path, s.enrollmentStepSubmit(def)).Methods("POST")
+	}
+}
+
+func (s *Server) enrollmentStepPage(def *enrollmentStepDef) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		flow := s.flowState(w, r)
+		cer, found := flow.Get("enrollResponse")
+		if !found {
+			s.renderError(w, r, expiredTransactionError())
+			return
+		}
+		er := cer.(*idx.EnrollmentResponse)
+		canChallenge := def.challenge != nil && er.HasStep(def.challengeStep)
+		if !er.HasStep(def.step) && !canChallenge {
+			http.Redirect(w, r, "/enrollFactor", http.StatusFound)
+			return
+		}
+
+		data, found := flow.Get(def.dataKey())
+		if canChallenge && (!er.HasStep(def.step) || !found) {
+			var err error
+			er, data, err = s.enrollmentChallenge(r.Context(), flow, er, def)
+			if err != nil {
+				s.flashError(w, r, rejectedStepError(err))
+				http.Redirect(w, r, "/enrollFactor", http.StatusFound)
+				return
+			}
+		}
+
+		_, rejected := flow.Get(def.rejectedKey())
+		s.renderStep(w, r, &def.stepForm, data, rejected)
+	}
+}
+
+func (s *Server) enrollmentStepSubmit(def *enrollmentStepDef) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		flow := s.flowState(w, r)
+		cer, found := flow.Get("enrollResponse")
+		if !found {
+			s.renderError(w, r, expiredTransactionError())
+			return
+		}
+		er := cer.(*idx.EnrollmentResponse)
+		if !er.HasStep(def.step) {
+			http.Redirect(w, r, "/enrollFactor", http.StatusFound)
+			return
+		}
+
+		in, err := def.input(w, r, isJSON(r))
+		if err == nil {
+			er, err = s.takeEnrollmentStep(r.Context(), flow, er, def, in)
+		}
+		if err != nil {
+			s.flashError(w, r, err)
+			http.Redirect(w, r, def.pagePath(), http.StatusFound)
+			return
+		}
+
+		// If we have tokens we have success, so lets store tokens
+		if er.Token() != nil {
+			if err := s.storeTokens(w, r, er.Token()); err != nil {
+				s.renderError(w, r, internalError(err))
+				return
+			}
+			http.Redirect(w, r, "/", http.StatusFound)
+			return
+		}
+		http.Redirect(w, r, "/enrollFactor", http.StatusFound)
+	}
+}
+
+func (s *Server) apiEnrollmentChallenge(def *enrollmentStepDef) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		flow := s.flowState(w, r)
+		cer, found := flow.Get("enrollResponse")
+		if !found {
+			s.writeAPIError(w, r, expiredTransactionError())
+			return
+		}
+		er := cer.(*idx.EnrollmentResponse)
+		if !er.HasStep(def.challengeStep) {
+			s.writeAPIError(w, r, unavailableStepError(def.challengeStep.String(), enrollmentStepNames(er)))
+			return
+		}
+		er, data, err := s.enrollmentChallenge(r.Context(), flow, er, def)
+		if err != nil {
+			s.writeAPIError(w, r, rejectedStepError(err))
+			return
+		}
+		s.writeJSON(w, http.StatusOK, enrollmentFlowResponse(er, data))
+	}
+}
+
+func (s *Server) apiEnrollmentSubmit(def *enrollmentStepDef) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		flow := s.flowState(w, r)
+		cer, found := flow.Get("enrollResponse")
+		if !found {
+			s.writeAPIError(w, r, expiredTransactionError())
+			return
+		}
+		er := cer.(*idx.EnrollmentResponse)
+		if !er.HasStep(def.step) {
+			s.writeAPIError(w, r, unavailableStepError(def.step.String(), enrollmentStepNames(er)))
+			return
+		}
+		in, err := def.input(w, r, true)
+		if err != nil {
+			s.writeAPIError(w, r, err)
+			return
+		}
+		er, err = s.takeEnrollmentStep(r.Context(), flow, er, def, in)
+		if err != nil {
+			s.writeAPIError(w, r, err)
+			return
+		}
+		if er.Token() != nil {
+			if err := s.storeTokens(w, r, er.Token()); err != nil {
+				s.writeAPIError(w, r, internalError(err))
+				return
+			}
+		}
+		s.writeJSON(w, http.StatusOK, enrollmentFlowResponse(er, nil))
+	}
+}
+
+// enrollmentChallenge is loginChallenge for registrations.
+func (s *Server) enrollmentChallenge(ctx context.Context, flow *flowState, er *idx.EnrollmentResponse, def *enrollmentStepDef) (*idx.EnrollmentResponse, interface{}, error) {
+	start := time.Now()
+	next, data, err := def.challenge(ctx, er)
+	observeEnrollmentStep(def.challengeStep, start, err)
+	if err != nil {
+		return er, nil, err
+	}
+	flow.Set("enrollResponse", next, time.Minute*5)
+	flow.Set(def.dataKey(), data, time.Minute*5)
+	flow.Delete(def.rejectedKey())
+	return next, data, nil
+}
+
+// takeEnrollmentStep is takeLoginStep for registrations.
+func (s *Server) takeEnrollmentStep(ctx context.Context, flow *flowState, er *idx.EnrollmentResponse, def *enrollmentStepDef, in stepInput) (*idx.EnrollmentResponse, error) {
+	start := time.Now()
+	next, err := def.submit(ctx, er, in)
+	observeEnrollmentStep(def.step, start, err)
+	if err != nil {
+		flow.Set(def.rejectedKey(), true, time.Minute*5)
+		return nil, rejectedStepError(err)
+	}
+	flow.Delete(def.rejectedKey())
+	flow.Delete(def.dataKey())
+
+	if next.Token() != nil {
+		enrollmentsCompleted.Inc()
+		flow.Delete("enrollResponse")
+		return next, nil
+	}
+	next, err = next.WhereAmI(ctx)
+	if err != nil {
+		return nil, upstreamError(err)
+	}
+	flow.Set("enrollResponse", next, time.Minute*5)
+	return next, nil
+}
+
+// END: Registration
@@ -0,0 +1,55 @@
+/**
+ * Copyright 2021 - Present Okta, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package server
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	idx "github.com/okta/okta-idx-golang"
+	"github.com/patrickmn/go-cache"
+)
+
+func TestLoginChallengeWithoutMagicLink(t *testing.T) {
+	s := &Server{cache: cache.New(time.Minute, time.Minute)}
+	flow := &flowState{transactionID: "tx", cache: s.cache}
+	def := &loginStepDef{
+		stepForm:      stepForm{path: "/login/factors/test"},
+		challengeStep: idx.LoginStepSecurityQuestionOptions,
+		challenge: func(ctx context.Context, lr *idx.LoginResponse) (*idx.LoginResponse, interface{}, error) {
+			return &idx.LoginResponse{}, "options", nil
+		},
+	}
+
+	_, data, err := s.loginChallenge(context.Background(), flow, &idx.LoginResponse{}, def)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data != "options" {
+		t.Errorf("challenge data = %v, want options", data)
+	}
+	if got, _ := flow.Get(def.dataKey()); got != "options" {
+		t.Errorf("flow keeps %v, want the challenge data", got)
+	}
+	for key := range s.cache.Items() {
+		if strings.HasPrefix(key, magicLinkKey("")) {
+			t.Errorf("a challenge without an email set %s", key)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+/**
+ * Copyright 2021 - Present Okta, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package server
+
+import (
+	"html/template"
+
+	idx "github.com/okta/okta-idx-golang"
+)
+
+// viewModel is implemented by every template's data so render can fill in
+// the fields common to all pages.
+type viewModel interface {
+	base() *ViewData
+}
+
+// ViewData is built for each request and holds the fields every template
+// uses. Page specific view models embed it.
+type ViewData struct {
+	Authenticated bool
+	Errors        string
+	// CSRFToken is posted back by every form, see csrf.go.
+	CSRFToken string
+}
+
+func (vd *ViewData) base() *ViewData {
+	return vd
+}
+
+type profileView struct {
+	ViewData
+	Profile map[string]string
+}
+
+type loginView struct {
+	ViewData
+	// Passwordless shows the identifier-first form, without a password.
+	Passwordless bool
+	IDPs         []idx.IdentityProvider
+	IdpCount     func() int
+}
+
+// factorsView lists the authenticators offered by loginSecondaryFactors.gohtml
+// and enroll.gohtml.
+type factorsView struct {
+	ViewData
+	FactorEmail            bool
+	FactorPhone            bool
+	FactorGoogleAuth       bool
+	FactorOktaVerify       bool
+	FactorSkip             bool
+	FactorWebAuthN         bool
+	FactorSecurityQuestion bool
+}
+
+type emailCodeView struct {
+	ViewData
+	InvalidEmailCode bool
+}
+
+type emailOTPView struct {
+	ViewData
+	OTP string
+}
+
+type phoneMethodView struct {
+	ViewData
+	InitialPhoneSetup bool
+}
+
+type oktaVerifyView struct {
+	ViewData
+	OktaVerifyPush bool
+	OktaVerifyTotp bool
+}
+
+type oktaVerifyQRView struct {
+	ViewData
+	QRCode string
+}
+
+type googleAuthView struct {
+	ViewData
+	QRCode       template.URL
+	SharedSecret template.URL
+}
+
+type securityQuestionView struct {
+	ViewData
+	Questions idx.SecurityQuestions
+}
+
+type webAuthNChallengeView struct {
+	ViewData
+	Challenge            template.URL
+	WebauthnCredentialID template.URL
+}
+
+type webAuthNSetupView struct {
+	ViewData
+	Challenge   string
+	UserID      string
+	Username    string
+	DisplayName string
+}
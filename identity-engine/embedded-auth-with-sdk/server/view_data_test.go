@@ -0,0 +1,106 @@
+/**
+ * Copyright 2021 - Present Okta, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package server
+
+import (
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+func newViewTestServer() *Server {
+	tpl := template.Must(template.New("profile.gohtml").Parse(
+		"{{.Authenticated}}|{{.Errors}}|{{.Profile.name}}"))
+	return &Server{tpl: tpl}
+}
+
+// signIn gives the browser a session holding values, as the login
+// callbacks do.
+func (b *browser) signIn(t *testing.T, values map[string]interface{}) {
+	t.Helper()
+	r := b.request(http.MethodGet, "/")
+	session, err := sessionStore.Get(r, "direct-auth")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k, v := range values {
+		session.Values[k] = v
+	}
+	w := httptest.NewRecorder()
+	if err := session.Save(r, w); err != nil {
+		t.Fatal(err)
+	}
+	b.keep(w)
+}
+
+func (b *browser) render(s *Server, data viewModel) string {
+	w := httptest.NewRecorder()
+	s.render("profile.gohtml", w, b.request(http.MethodGet, "/profile"), data)
+	b.keep(w)
+	return w.Body.String()
+}
+
+func TestRenderFillsInTheRequestsSession(t *testing.T) {
+	s := newViewTestServer()
+	alice := newBrowser()
+	alice.signIn(t, map[string]interface{}{"id_token": "id-token", "Errors": "Something went wrong."})
+
+	if got, want := alice.render(s, &profileView{Profile: map[string]string{"name": "Alice"}}), "true|Something went wrong.|Alice"; got != want {
+		t.Errorf("first page = %q, want %q", got, want)
+	}
+	// The error was flashed, the next page doesn't show it again
+	if got, want := alice.render(s, &profileView{}), "true||"; got != want {
+		t.Errorf("second page = %q, want %q", got, want)
+	}
+
+	alice.signIn(t, map[string]interface{}{"Errors": "Flashed."})
+	data := &profileView{ViewData: ViewData{Errors: "The page's own error."}}
+	if got, want := alice.render(s, data), "true|The page&#39;s own error.|"; got != want {
+		t.Errorf("page with its own error = %q, want %q", got, want)
+	}
+}
+
+func TestRenderConcurrentRequests(t *testing.T) {
+	s := newViewTestServer()
+	alice, bob := newBrowser(), newBrowser()
+	alice.signIn(t, map[string]interface{}{"id_token": "id-token"})
+
+	// Pages of different sessions rendered at once keep their own data
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			w := httptest.NewRecorder()
+			s.render("profile.gohtml", w, alice.request(http.MethodGet, "/profile"), &profileView{Profile: map[string]string{"name": "Alice"}})
+			if got := w.Body.String(); got != "true||Alice" {
+				t.Errorf("alice's page = %q", got)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			w := httptest.NewRecorder()
+			s.render("profile.gohtml", w, bob.request(http.MethodGet, "/profile"), &profileView{})
+			if got := w.Body.String(); got != "false||" {
+				t.Errorf("bob's page = %q", got)
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -16,6 +16,21 @@
 
 package config
 
+import (
+	"time"
+
+	"github.com/okta/samples-golang/utils/serve"
+)
+
 type Config struct {
 	Testing bool
+
+	// Address is the host:port the sample listens on.
+	Address string
+	// TLS makes the sample serve HTTPS, with the given certificate or a
+	// self-signed one.
+	TLS serve.TLS
+	// ShutdownTimeout is how long in-flight requests get to finish once the
+	// sample receives SIGINT or SIGTERM.
+	ShutdownTimeout time.Duration
 }
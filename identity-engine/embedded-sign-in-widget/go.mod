@@ -9,7 +9,12 @@ require (
 	github.com/gorilla/sessions v1.2.1
 	github.com/okta/okta-idx-golang v0.2.3-0.20220211190246-75f2bf55928c
 	github.com/okta/okta-sdk-golang/v2 v2.3.1-0.20210519105407-20ace51aad26
+	github.com/okta/samples-golang/discovery v0.0.0
+	github.com/okta/samples-golang/utils v0.0.0
 	github.com/patrickmn/go-cache v0.0.0-20180815053127-5633e0862627
 	github.com/spf13/pflag v1.0.5
 	github.com/tebeka/selenium v0.9.9
 )
+
+replace github.com/okta/samples-golang/discovery => ../../discovery
+replace github.com/okta/samples-golang/utils => ../../utils
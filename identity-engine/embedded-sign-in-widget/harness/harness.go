@@ -94,10 +94,17 @@ func (th *TestHarness) InitializeTestSuite(ctx *godog.TestSuiteContext) {
 
 		th.depopulateMary()
 
-		srv.Run()
+		if err := srv.Run(); err != nil {
+			log.Fatalf("failed to start the sample: %v", err)
+		}
 	})
 
 	ctx.AfterSuite(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := th.server.Shutdown(ctx); err != nil {
+			log.Printf("failed to shut down the sample: %v", err)
+		}
 	})
 }
 
@@ -17,8 +17,13 @@
 package main
 
 import (
+	"log"
+
+	flag "github.com/spf13/pflag"
+
 	"github.com/okta/samples-golang/identity-engine/embedded-sign-in-widget/config"
 	"github.com/okta/samples-golang/identity-engine/embedded-sign-in-widget/server"
+	"github.com/okta/samples-golang/utils/serve"
 )
 
 type application struct {
@@ -31,7 +36,16 @@ var App *application
 func main() {
 	App = &application{}
 	cfg := &config.Config{}
+	flag.StringVar(&cfg.Address, "addr", "localhost:8000", "host:port to listen on")
+	flag.StringVar(&cfg.TLS.CertFile, "tls-cert", "", "PEM certificate file, serves HTTPS together with --tls-key")
+	flag.StringVar(&cfg.TLS.KeyFile, "tls-key", "", "PEM private key file of --tls-cert")
+	flag.BoolVar(&cfg.TLS.SelfSigned, "tls-self-signed", false, "serve HTTPS with a generated self-signed certificate, for development")
+	flag.DurationVar(&cfg.ShutdownTimeout, "shutdown-timeout", serve.DefaultShutdownTimeout, "how long in-flight requests get to finish on SIGINT or SIGTERM")
+	flag.Parse()
+
 	server := server.NewServer(cfg)
 
-	server.Run()
+	if err := server.Run(); err != nil {
+		log.Fatal(err)
+	}
 }
@@ -0,0 +1,130 @@
+/**
+ * Copyright 2021 - Present Okta, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package server
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+)
+
+type errorKind int
+
+const (
+	errorKindInternal errorKind = iota
+	errorKindUpstream
+	errorKindExpiredTransaction
+	errorKindInvalidInput
+)
+
+// appError is an error a handler can't recover from. It is rendered on the
+// error page instead of taking the whole server down.
+type appError struct {
+	kind    errorKind
+	message string
+	err     error
+}
+
+func (e *appError) Error() string {
+	if e.err == nil {
+		return e.message
+	}
+	return fmt.Sprintf("%s: %s", e.message, e.err)
+}
+
+func (e *appError) Unwrap() error {
+	return e.err
+}
+
+// StatusCode is the HTTP status the error page is served with.
+func (e *appError) StatusCode() int {
+	switch e.kind {
+	case errorKindUpstream:
+		return http.StatusBadGateway
+	case errorKindExpiredTransaction:
+		return http.StatusGone
+	case errorKindInvalidInput:
+		return http.StatusBadRequest
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// upstreamError is returned when Okta, or the IdP behind it, rejects a call.
+func upstreamError(err error) *appError {
+	return &appError{
+		kind:    errorKindUpstream,
+		message: "The identity provider could not complete the request",
+		err:     err,
+	}
+}
+
+// expiredTransactionError is returned when the cached login response is gone,
+// usually because it timed out before the widget redirected back.
+func expiredTransactionError() *appError {
+	return &appError{
+		kind:    errorKindExpiredTransaction,
+		message: "Your login has expired or was never started, please sign in again",
+	}
+}
+
+func invalidInputError(message string, err error) *appError {
+	return &appError{
+		kind:    errorKindInvalidInput,
+		message: message,
+		err:     err,
+	}
+}
+
+func internalError(err error) *appError {
+	return &appError{
+		kind:    errorKindInternal,
+		message: "Something went wrong on our side, please try again",
+		err:     err,
+	}
+}
+
+// renderError logs err with the request it belongs to and serves the error
+// page. Errors that aren't an appError are treated as internal errors.
+func (s *Server) renderError(w http.ResponseWriter, r *http.Request, err error) {
+	var ae *appError
+	if !errors.As(err, &ae) {
+		ae = internalError(err)
+	}
+
+	log.Printf("ERROR: %s %s (remote %s): %s", r.Method, r.URL.Path, r.RemoteAddr, ae)
+
+	data := struct {
+		Profile         map[string]string
+		IsAuthenticated bool
+		StatusCode      int
+		StatusText      string
+		Message         string
+	}{
+		IsAuthenticated: s.isAuthenticated(r),
+		StatusCode:      ae.StatusCode(),
+		StatusText:      http.StatusText(ae.StatusCode()),
+		Message:         ae.message,
+	}
+
+	w.Header().Add("Cache-Control", "no-cache")
+	w.WriteHeader(ae.StatusCode())
+	if err := s.tpl.ExecuteTemplate(w, "error.gohtml", data); err != nil {
+		log.Printf("execute templates error: %+v", err)
+	}
+}
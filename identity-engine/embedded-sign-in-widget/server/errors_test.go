@@ -0,0 +1,65 @@
+/**
+ * Copyright 2021 - Present Okta, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package server
+
+import (
+	"errors"
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/sessions"
+)
+
+func TestRenderError(t *testing.T) {
+	tests := []struct {
+		name        string
+		err         error
+		wantStatus  int
+		wantMessage string
+	}{
+		{name: "upstream", err: upstreamError(errors.New("E0000011")), wantStatus: http.StatusBadGateway, wantMessage: "The identity provider could not complete the request"},
+		{name: "expired", err: expiredTransactionError(), wantStatus: http.StatusGone, wantMessage: "Your login has expired"},
+		{name: "invalid input", err: invalidInputError("The state is missing", nil), wantStatus: http.StatusBadRequest, wantMessage: "The state is missing"},
+		{name: "internal", err: internalError(errors.New("disk full")), wantStatus: http.StatusInternalServerError, wantMessage: "Something went wrong on our side"},
+		{name: "not an appError", err: errors.New("disk full"), wantStatus: http.StatusInternalServerError, wantMessage: "Something went wrong on our side"},
+	}
+	s := &Server{
+		tpl:          template.Must(template.New("error.gohtml").Parse("{{.StatusCode}} {{.StatusText}}: {{.Message}}")),
+		sessionStore: sessions.NewCookieStore([]byte("test-session-store")),
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			s.renderError(w, httptest.NewRequest(http.MethodGet, "/login/callback", nil), tt.err)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			body := w.Body.String()
+			if !strings.Contains(body, http.StatusText(tt.wantStatus)) || !strings.Contains(body, tt.wantMessage) {
+				t.Errorf("page = %q, want %q", body, tt.wantMessage)
+			}
+			// What went wrong inside is logged, not shown
+			if strings.Contains(body, "disk full") || strings.Contains(body, "E0000011") {
+				t.Errorf("page shows the wrapped error: %q", body)
+			}
+		})
+	}
+}
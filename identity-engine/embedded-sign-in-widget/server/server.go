@@ -18,6 +18,7 @@ package server
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"html/template"
@@ -26,7 +27,6 @@ import (
 	"net/http"
 	"net/url"
 	"os"
-	"strings"
 	"time"
 
 	"github.com/gorilla/mux"
@@ -34,7 +34,9 @@ import (
 	idx "github.com/okta/okta-idx-golang"
 	"github.com/patrickmn/go-cache"
 
+	"github.com/okta/samples-golang/discovery"
 	"github.com/okta/samples-golang/identity-engine/embedded-sign-in-widget/config"
+	"github.com/okta/samples-golang/utils/serve"
 )
 
 const (
@@ -84,7 +86,13 @@ func (s *Server) Address() string {
 	return s.address
 }
 
-func (s *Server) Run() {
+// Shutdown stops the server, waiting for in-flight requests until ctx is
+// done.
+func (s *Server) Shutdown(ctx context.Context) error {
+	return s.svc.Shutdown(ctx)
+}
+
+func (s *Server) Run() error {
 	r := mux.NewRouter()
 	r.Use(s.loggingMiddleware)
 
@@ -96,7 +104,10 @@ func (s *Server) Run() {
 	r.HandleFunc("/logout", s.LogoutHandler).Methods("POST")
 	r.HandleFunc("/logout", s.LogoutHandler).Methods("GET")
 
-	addr := "localhost:8000"
+	addr := s.config.Address
+	if addr == "" {
+		addr = "localhost:8000"
+	}
 	logger := log.New(os.Stderr, "http: ", log.LstdFlags)
 	srv := &http.Server{
 		Handler:      r,
@@ -107,17 +118,30 @@ func (s *Server) Run() {
 	}
 
 	s.svc = srv
-	s.address = srv.Addr
 
-	log.Printf("running sample on addr %q\n", addr)
+	ln, err := serve.Listen(srv, s.config.TLS)
+	if err != nil {
+		return fmt.Errorf("could not listen on %q: %w", addr, err)
+	}
+	s.address = ln.Addr().String()
+
+	scheme := "http"
+	if s.config.TLS.Enabled() {
+		scheme = "https"
+	}
+	log.Printf("running sample on %s://%s\n", scheme, s.address)
 
-	if !s.config.Testing {
-		log.Fatal(srv.ListenAndServe())
-	} else {
+	if s.config.Testing {
+		// The listener is open, so the tests can make requests as soon as
+		// Run returns.
 		go func() {
-			log.Fatal(srv.ListenAndServe())
+			if err := srv.Serve(ln); err != http.ErrServerClosed {
+				log.Fatal(err)
+			}
 		}()
+		return nil
 	}
+	return serve.Serve(srv, ln, s.config.ShutdownTimeout)
 }
 
 func (s *Server) HomeHandler(w http.ResponseWriter, r *http.Request) {
@@ -137,14 +161,16 @@ func (s *Server) LoginHandler(w http.ResponseWriter, r *http.Request) {
 
 	lr, err := s.idxClient.InitLogin(r.Context())
 	if err != nil {
-		log.Fatalf("error idx client init login: %+v", err)
+		s.renderError(w, r, upstreamError(err))
+		return
 	}
 	s.cache.Set("loginResponse", lr, time.Minute*5)
 
 	issuerURL := s.idxClient.Config().Okta.IDX.Issuer
 	issuerParts, err := url.Parse(issuerURL)
 	if err != nil {
-		log.Fatalf("error: %s\n", err.Error())
+		s.renderError(w, r, internalError(err))
+		return
 	}
 	baseUrl := issuerParts.Scheme + "://" + issuerParts.Hostname()
 	s.LoginData = LoginData{
@@ -179,17 +205,19 @@ func (s *Server) LoginCallbackHandler(w http.ResponseWriter, r *http.Request) {
 
 	clr, found := s.cache.Get("loginResponse")
 	if !found {
-		log.Fatalln("loginResponse is not cached")
+		s.renderError(w, r, expiredTransactionError())
+		return
 	}
 	lr := clr.(*idx.LoginResponse)
 	lr, err := lr.WhereAmI(r.Context())
-	if !found {
-		log.Fatalf("loginRespons WhereAmI error: %s", err.Error())
+	if err != nil {
+		s.renderError(w, r, upstreamError(err))
+		return
 	}
 
 	// Check the state that was returned in the query string is the same as the above state
 	if r.URL.Query().Get("state") != lr.Context().State {
-		fmt.Fprintf(w, "The state was not as expected, got %q, expected %q", r.URL.Query().Get("state"), lr.Context().State)
+		s.renderError(w, r, invalidInputError("The state was not as expected", fmt.Errorf("got %q, expected %q", r.URL.Query().Get("state"), lr.Context().State)))
 		return
 	}
 
@@ -207,18 +235,20 @@ func (s *Server) LoginCallbackHandler(w http.ResponseWriter, r *http.Request) {
 
 	// Check that the interaction_code was provided
 	if r.URL.Query().Get("interaction_code") == "" {
-		fmt.Fprintln(w, "The interaction_code was not returned or is not accessible")
+		s.renderError(w, r, invalidInputError("The interaction_code was not returned or is not accessible", nil))
 		return
 	}
 
 	session, err := s.sessionStore.Get(r, SESSION_STORE_NAME)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		s.renderError(w, r, internalError(err))
+		return
 	}
 
 	accessToken, err := s.idxClient.RedeemInteractionCode(r.Context(), lr.Context(), r.URL.Query().Get("interaction_code"))
 	if err != nil {
-		log.Fatalf("access token error: %+v\n", err)
+		s.renderError(w, r, upstreamError(err))
+		return
 	}
 	session.Values["id_token"] = accessToken.IDToken
 	session.Values["access_token"] = accessToken.AccessToken
@@ -257,7 +287,11 @@ func (s *Server) LogoutHandler(w http.ResponseWriter, r *http.Request) {
 				"post_logout_redirect_uri": {redirect.String()},
 			}
 			// server must redirect out to the Okta API to perform a proper logout
-			logoutURL = s.oAuthEndPoint(fmt.Sprintf("logout?%s", params.Encode()))
+			if oidc, err := discovery.Get(r.Context(), s.idxClient.Config().Okta.IDX.Issuer); err == nil {
+				logoutURL = fmt.Sprintf("%s?%s", oidc.EndSessionEndpoint, params.Encode())
+			} else {
+				fmt.Printf("logout error: %+v\n", err)
+			}
 		}
 
 		delete(session.Values, "id_token")
@@ -284,16 +318,24 @@ func (s *Server) getProfileData(r *http.Request) map[string]string {
 
 	session, _ := s.sessionStore.Get(r, SESSION_STORE_NAME)
 	if accessToken, found := session.Values["access_token"]; found {
-		reqUrl := s.oAuthEndPoint("userinfo")
-		req, _ := http.NewRequest("GET", reqUrl, bytes.NewReader([]byte("")))
+		oidc, err := discovery.Get(r.Context(), s.idxClient.Config().Okta.IDX.Issuer)
+		if err != nil {
+			log.Printf("could not get the userinfo endpoint: %s", err)
+			return m
+		}
+		req, _ := http.NewRequest("GET", oidc.UserinfoEndpoint, bytes.NewReader([]byte("")))
 		h := req.Header
 		h.Add("Authorization", fmt.Sprintf("Bearer %s", accessToken))
 		h.Add("Accept", "application/json")
 
 		client := &http.Client{Timeout: time.Second * 30}
-		resp, _ := client.Do(req)
-		body, _ := ioutil.ReadAll(resp.Body)
+		resp, err := client.Do(req)
+		if err != nil {
+			log.Printf("could not get the profile: %s", err)
+			return m
+		}
 		defer resp.Body.Close()
+		body, _ := ioutil.ReadAll(resp.Body)
 		json.Unmarshal(body, &m)
 	}
 
@@ -305,14 +347,3 @@ func (s *Server) isAuthenticated(r *http.Request) bool {
 	_, found := session.Values["id_token"]
 	return found
 }
-
-func (s *Server) oAuthEndPoint(operation string) string {
-	var endPoint string
-	issuer := s.idxClient.Config().Okta.IDX.Issuer
-	if strings.Contains(issuer, "oauth2") {
-		endPoint = fmt.Sprintf("%s/v1/%s", issuer, operation)
-	} else {
-		endPoint = fmt.Sprintf("%s/oauth2/v1/%s", issuer, operation)
-	}
-	return endPoint
-}
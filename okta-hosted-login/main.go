@@ -11,22 +11,59 @@ import (
 	"io"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
-	"github.com/gorilla/sessions"
 	verifier "github.com/okta/okta-jwt-verifier-golang/v2"
+	"github.com/okta/samples-golang/discovery"
 	oktaUtils "github.com/okta/samples-golang/okta-hosted-login/utils"
+	"github.com/okta/samples-golang/utils/config"
+	"github.com/okta/samples-golang/utils/session"
 )
 
-var (
+// defaults are the settings used when neither the config file, the
+// environment nor the flags set them.
+var defaults = config.Config{
+	ListenAddr:   "localhost:8080",
+	BaseURL:      "http://localhost:8080",
+	RedirectPath: "/auth/okta/callback",
+	// offline_access gets us a refresh token.
+	Scopes: []string{"openid", "profile", "email", "offline_access"},
+	Cookie: config.Cookie{
+		Name:     "okta-hosted-login-session-store",
+		Path:     "/",
+		MaxAge:   12 * 60 * 60,
+		SameSite: "lax",
+	},
+	// Server-side sessions keep the tokens out of the cookie, which they
+	// would easily grow past the 4KB browsers accept.
+	Session: config.Session{
+		Store:           "memory",
+		IdleTimeout:     30 * time.Minute,
+		AbsoluteTimeout: 12 * time.Hour,
+	},
+}
+
+type Server struct {
+	config       *config.Config
 	tpl          *template.Template
-	sessionStore = sessions.NewCookieStore([]byte("okta-hosted-login-session-store"))
-	state        = generateState()
-	nonce        = "NonceNotSetYet"
-)
+	sessionStore *session.Store
+}
+
+func NewServer(c *config.Config) (*Server, error) {
+	sessionStore, err := session.NewStore(c)
+	if err != nil {
+		return nil, err
+	}
 
-func init() {
-	tpl = template.Must(template.ParseGlob("templates/*"))
+	return &Server{
+		config:       c,
+		tpl:          template.Must(template.ParseGlob("templates/*")),
+		sessionStore: sessionStore,
+	}, nil
 }
 
 func generateState() string {
@@ -37,58 +74,121 @@ func generateState() string {
 }
 
 func main() {
-	oktaUtils.ParseEnvironment()
+	cfg, err := config.Load(defaults, os.Args[1:])
+	if err == nil {
+		err = cfg.Validate("CLIENT_ID", "CLIENT_SECRET", "ISSUER", "SESSION_SECRET")
+	}
+	if err != nil {
+		log.Print(err)
+		os.Exit(1)
+	}
+
+	s, err := NewServer(cfg)
+	if err != nil {
+		log.Printf("could not create the session store: %s", err)
+		os.Exit(1)
+	}
 
-	http.HandleFunc("/", HomeHandler)
-	http.HandleFunc("/login", LoginHandler)
-	http.HandleFunc("/auth/okta/callback", AuthCodeCallbackHandler)
-	http.HandleFunc("/profile", ProfileHandler)
-	http.HandleFunc("/logout", LogoutHandler)
+	http.HandleFunc("/", s.HomeHandler)
+	http.HandleFunc("/login", s.LoginHandler)
+	http.HandleFunc(cfg.RedirectPath, s.AuthCodeCallbackHandler)
+	http.HandleFunc("/profile", s.ProfileHandler)
+	http.HandleFunc("/logout", s.LogoutHandler)
 
-	log.Print("server starting at localhost:8080 ... ")
-	err := http.ListenAndServe("localhost:8080", nil)
+	log.Printf("server starting at %s ... ", cfg.ListenAddr)
+	err = http.ListenAndServe(cfg.ListenAddr, s.refreshTokensMiddleware(http.DefaultServeMux))
 	if err != nil {
 		log.Printf("the HTTP server failed to start: %s", err)
 		os.Exit(1)
 	}
 }
 
-func HomeHandler(w http.ResponseWriter, r *http.Request) {
+func (s *Server) HomeHandler(w http.ResponseWriter, r *http.Request) {
 	type customData struct {
 		Profile         map[string]string
 		IsAuthenticated bool
 	}
 
 	data := customData{
-		Profile:         getProfileData(r),
-		IsAuthenticated: isAuthenticated(r),
+		Profile:         s.getProfileData(r),
+		IsAuthenticated: s.isAuthenticated(r),
 	}
-	tpl.ExecuteTemplate(w, "home.gohtml", data)
+	s.tpl.ExecuteTemplate(w, "home.gohtml", data)
 }
 
-func LoginHandler(w http.ResponseWriter, r *http.Request) {
+func (s *Server) LoginHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Add("Cache-Control", "no-cache") // See https://github.com/okta/samples-golang/issues/20
 
-	nonce, _ = oktaUtils.GenerateNonce()
+	// Every login gets its own state, nonce and PKCE code verifier, kept in
+	// the session until the callback consumes them.
+	state := generateState()
+	nonce, err := oktaUtils.GenerateNonce()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	codeVerifier, err := oktaUtils.GenerateCodeVerifier()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	session, err := s.sessionStore.Get(r, s.config.Cookie.Name)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	session.Values["state"] = state
+	session.Values["nonce"] = nonce
+	session.Values["code_verifier"] = codeVerifier
+	if err := session.Save(r, w); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	oidc, err := discovery.Get(r.Context(), s.config.Issuer)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
 	var redirectPath string
 
 	q := r.URL.Query()
-	q.Add("client_id", os.Getenv("CLIENT_ID"))
+	q.Add("client_id", s.config.ClientID)
 	q.Add("response_type", "code")
 	q.Add("response_mode", "query")
-	q.Add("scope", "openid profile email")
-	q.Add("redirect_uri", "http://localhost:8080/auth/okta/callback")
+	q.Add("scope", strings.Join(s.config.Scopes, " "))
+	q.Add("redirect_uri", s.config.RedirectURI())
 	q.Add("state", state)
 	q.Add("nonce", nonce)
+	q.Add("code_challenge", oktaUtils.CodeChallengeS256(codeVerifier))
+	q.Add("code_challenge_method", "S256")
 
-	redirectPath = os.Getenv("ISSUER") + "/oauth2/v1/authorize?" + q.Encode()
+	redirectPath = oidc.AuthorizationEndpoint + "?" + q.Encode()
 
 	http.Redirect(w, r, redirectPath, http.StatusFound)
 }
 
-func AuthCodeCallbackHandler(w http.ResponseWriter, r *http.Request) {
-	// Check the state that was returned in the query string is the same as the above state
-	if r.URL.Query().Get("state") != state {
+func (s *Server) AuthCodeCallbackHandler(w http.ResponseWriter, r *http.Request) {
+	session, err := s.sessionStore.Get(r, s.config.Cookie.Name)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// The login's state, nonce and code verifier are single use, drop them
+	// from the session whatever the outcome of the callback is.
+	state, _ := session.Values["state"].(string)
+	nonce, _ := session.Values["nonce"].(string)
+	codeVerifier, _ := session.Values["code_verifier"].(string)
+	delete(session.Values, "state")
+	delete(session.Values, "nonce")
+	delete(session.Values, "code_verifier")
+	session.Save(r, w)
+
+	// Check the state that was returned in the query string is the same as the one stored at login
+	if state == "" || r.URL.Query().Get("state") != state {
 		fmt.Fprintln(w, "The state was not as expected")
 		return
 	}
@@ -98,73 +198,160 @@ func AuthCodeCallbackHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	exchange := exchangeCode(r.URL.Query().Get("code"), r)
+	exchange := s.exchangeCode(r.URL.Query().Get("code"), codeVerifier, r)
 	if exchange.Error != "" {
 		fmt.Println(exchange.Error)
 		fmt.Println(exchange.ErrorDescription)
 		return
 	}
 
-	session, err := sessionStore.Get(r, "okta-hosted-login-session-store")
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	// The session is only created once both tokens check out, the ID token
+	// against the nonce sent with this login.
+	idToken, verificationError := s.verifyIdToken(exchange.IdToken, nonce)
+	if verificationError == nil {
+		_, verificationError = s.verifyToken(exchange.AccessToken)
 	}
 
-	_, verificationError := verifyToken(exchange.AccessToken)
-
 	if verificationError != nil {
 		fmt.Printf("verification error: %s", verificationError)
 	}
 
 	if verificationError == nil {
+		profile, err := json.Marshal(profileFromClaims(idToken.Claims))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
 		session.Values["id_token"] = exchange.IdToken
 		session.Values["access_token"] = exchange.AccessToken
-
-		session.Save(r, w)
+		session.Values["refresh_token"] = exchange.RefreshToken
+		session.Values["expires_at"] = expiresAt(exchange.ExpiresIn)
+		session.Values["profile"] = string(profile)
+
+		// A new session ID, so one planted before the login is of no use
+		if err := s.sessionStore.Renew(r, w, session); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
 		fmt.Println("session saved !!!")
 	}
 	http.Redirect(w, r, "/", http.StatusFound)
 }
 
-func ProfileHandler(w http.ResponseWriter, r *http.Request) {
+func (s *Server) ProfileHandler(w http.ResponseWriter, r *http.Request) {
 	type customData struct {
 		Profile         map[string]string
 		IsAuthenticated bool
 	}
 
 	data := customData{
-		Profile:         getProfileData(r),
-		IsAuthenticated: isAuthenticated(r),
+		Profile:         s.getProfileData(r),
+		IsAuthenticated: s.isAuthenticated(r),
 	}
-	tpl.ExecuteTemplate(w, "profile.gohtml", data)
+	s.tpl.ExecuteTemplate(w, "profile.gohtml", data)
 }
 
-func LogoutHandler(w http.ResponseWriter, r *http.Request) {
-	session, err := sessionStore.Get(r, "okta-hosted-login-session-store")
+// LogoutHandler revokes the session's tokens, clears them from the cookie
+// and ends the Okta session through the issuer's logout endpoint, which sends
+// the browser on to POST_LOGOUT_REDIRECT_URI.
+func (s *Server) LogoutHandler(w http.ResponseWriter, r *http.Request) {
+	session, err := s.sessionStore.Get(r, s.config.Cookie.Name)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	idToken, _ := session.Values["id_token"].(string)
+	if refreshToken, ok := session.Values["refresh_token"].(string); ok && refreshToken != "" {
+		if err := s.revokeToken(r, refreshToken, "refresh_token"); err != nil {
+			log.Printf("revoke error: %s", err)
+		}
+	}
+	if accessToken, ok := session.Values["access_token"].(string); ok && accessToken != "" {
+		if err := s.revokeToken(r, accessToken, "access_token"); err != nil {
+			log.Printf("revoke error: %s", err)
+		}
 	}
 
 	delete(session.Values, "id_token")
 	delete(session.Values, "access_token")
-
+	delete(session.Values, "refresh_token")
+	delete(session.Values, "expires_at")
+	delete(session.Values, "profile")
 	session.Save(r, w)
 
-	http.Redirect(w, r, "/", http.StatusFound)
+	if idToken == "" {
+		http.Redirect(w, r, "/", http.StatusFound)
+		return
+	}
+
+	// post_logout_redirect_uri must match one of the "Sign-out redirect URIs"
+	// defined on the Okta application
+	q := url.Values{}
+	q.Add("id_token_hint", idToken)
+	q.Add("post_logout_redirect_uri", s.config.PostLogoutRedirectURI)
+
+	oidc, err := discovery.Get(r.Context(), s.config.Issuer)
+	if err != nil {
+		log.Printf("could not end the Okta session: %s", err)
+		http.Redirect(w, r, "/", http.StatusFound)
+		return
+	}
+
+	http.Redirect(w, r, oidc.EndSessionEndpoint+"?"+q.Encode(), http.StatusFound)
 }
 
-func exchangeCode(code string, r *http.Request) Exchange {
+func (s *Server) revokeToken(r *http.Request, token string, tokenTypeHint string) error {
+	oidc, err := discovery.Get(r.Context(), s.config.Issuer)
+	if err != nil {
+		return err
+	}
+
 	authHeader := base64.StdEncoding.EncodeToString(
-		[]byte(os.Getenv("CLIENT_ID") + ":" + os.Getenv("CLIENT_SECRET")))
+		[]byte(s.config.ClientID + ":" + s.config.ClientSecret))
+
+	form := url.Values{}
+	form.Add("token", token)
+	form.Add("token_type_hint", tokenTypeHint)
+
+	req, _ := http.NewRequest("POST", oidc.RevocationEndpoint, strings.NewReader(form.Encode()))
+	h := req.Header
+	h.Add("Authorization", "Basic "+authHeader)
+	h.Add("Accept", "application/json")
+	h.Add("Content-Type", "application/x-www-form-urlencoded")
+
+	client := &http.Client{}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("revoking %s failed with status %s", tokenTypeHint, resp.Status)
+	}
+	return nil
+}
+
+func (s *Server) exchangeCode(code string, codeVerifier string, r *http.Request) Exchange {
+	oidc, err := discovery.Get(r.Context(), s.config.Issuer)
+	if err != nil {
+		return Exchange{Error: "discovery_failed", ErrorDescription: err.Error()}
+	}
+
+	authHeader := base64.StdEncoding.EncodeToString(
+		[]byte(s.config.ClientID + ":" + s.config.ClientSecret))
 
 	q := r.URL.Query()
 	q.Add("grant_type", "authorization_code")
 	q.Set("code", code)
-	q.Add("redirect_uri", "http://localhost:8080/auth/okta/callback")
+	q.Add("redirect_uri", s.config.RedirectURI())
+	q.Add("code_verifier", codeVerifier)
 
-	url := os.Getenv("ISSUER") + "/oauth2/v1/token?" + q.Encode()
+	tokenUrl := oidc.TokenEndpoint + "?" + q.Encode()
 
-	req, _ := http.NewRequest("POST", url, bytes.NewReader([]byte("")))
+	req, _ := http.NewRequest("POST", tokenUrl, bytes.NewReader([]byte("")))
 	h := req.Header
 	h.Add("Authorization", "Basic "+authHeader)
 	h.Add("Accept", "application/json")
@@ -173,17 +360,123 @@ func exchangeCode(code string, r *http.Request) Exchange {
 	h.Add("Content-Length", "0")
 
 	client := &http.Client{}
-	resp, _ := client.Do(req)
+	resp, err := client.Do(req)
+	if err != nil {
+		return Exchange{Error: "request_failed", ErrorDescription: err.Error()}
+	}
+	defer resp.Body.Close()
 	body, _ := io.ReadAll(resp.Body)
+	var exchange Exchange
+	json.Unmarshal(body, &exchange)
+
+	return exchange
+}
+
+// refreshWindow is how long before the access token expires that
+// refreshTokensMiddleware renews it.
+const refreshWindow = 60 * time.Second
+
+func expiresAt(expiresIn int) int64 {
+	return time.Now().Add(time.Duration(expiresIn) * time.Second).Unix()
+}
+
+// refreshTokensMiddleware renews the session's access token with its refresh
+// token shortly before it expires. If the refresh fails the tokens are removed
+// from the session, logging the user out, and they are sent back home.
+func (s *Server) refreshTokensMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/logout" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		session, err := s.sessionStore.Get(r, s.config.Cookie.Name)
+		if err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// Keep sessions in use from reaching the idle timeout
+		if err := s.sessionStore.Touch(r, w, session); err != nil {
+			log.Printf("could not save the session: %s", err)
+		}
+
+		expires, ok := session.Values["expires_at"].(int64)
+		if !ok || time.Until(time.Unix(expires, 0)) > refreshWindow {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		refreshToken, _ := session.Values["refresh_token"].(string)
+		var exchange Exchange
+		if refreshToken != "" {
+			exchange = s.refreshTokens(r, refreshToken)
+		}
+
+		if refreshToken == "" || exchange.Error != "" || exchange.AccessToken == "" {
+			log.Printf("could not refresh the access token: %s %s", exchange.Error, exchange.ErrorDescription)
+			for _, k := range []string{"id_token", "access_token", "refresh_token", "expires_at", "profile"} {
+				delete(session.Values, k)
+			}
+			session.Save(r, w)
+			if r.URL.Path != "/" {
+				http.Redirect(w, r, "/", http.StatusFound)
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		session.Values["access_token"] = exchange.AccessToken
+		session.Values["expires_at"] = expiresAt(exchange.ExpiresIn)
+		if exchange.IdToken != "" {
+			session.Values["id_token"] = exchange.IdToken
+		}
+		// Refresh tokens may be rotated on every use
+		if exchange.RefreshToken != "" {
+			session.Values["refresh_token"] = exchange.RefreshToken
+		}
+		session.Save(r, w)
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *Server) refreshTokens(r *http.Request, refreshToken string) Exchange {
+	oidc, err := discovery.Get(r.Context(), s.config.Issuer)
+	if err != nil {
+		return Exchange{Error: "discovery_failed", ErrorDescription: err.Error()}
+	}
+
+	authHeader := base64.StdEncoding.EncodeToString(
+		[]byte(s.config.ClientID + ":" + s.config.ClientSecret))
+
+	form := url.Values{}
+	form.Add("grant_type", "refresh_token")
+	form.Add("refresh_token", refreshToken)
+	form.Add("scope", strings.Join(s.config.Scopes, " "))
+
+	req, _ := http.NewRequest("POST", oidc.TokenEndpoint, strings.NewReader(form.Encode()))
+	h := req.Header
+	h.Add("Authorization", "Basic "+authHeader)
+	h.Add("Accept", "application/json")
+	h.Add("Content-Type", "application/x-www-form-urlencoded")
+
+	client := &http.Client{}
+	resp, err := client.Do(req)
+	if err != nil {
+		return Exchange{Error: "request_failed", ErrorDescription: err.Error()}
+	}
 	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
 	var exchange Exchange
 	json.Unmarshal(body, &exchange)
 
 	return exchange
 }
 
-func isAuthenticated(r *http.Request) bool {
-	session, err := sessionStore.Get(r, "okta-hosted-login-session-store")
+func (s *Server) isAuthenticated(r *http.Request) bool {
+	session, err := s.sessionStore.Get(r, s.config.Cookie.Name)
 
 	if err != nil || session.Values["id_token"] == nil || session.Values["id_token"] == "" {
 		return false
@@ -192,37 +485,53 @@ func isAuthenticated(r *http.Request) bool {
 	return true
 }
 
-func getProfileData(r *http.Request) map[string]string {
+// getProfileData returns the claims of the verified ID token, saved in the
+// session at login, so no call to the userinfo endpoint is needed.
+func (s *Server) getProfileData(r *http.Request) map[string]string {
 	m := make(map[string]string)
 
-	session, err := sessionStore.Get(r, "okta-hosted-login-session-store")
+	session, err := s.sessionStore.Get(r, s.config.Cookie.Name)
 
-	if err != nil || session.Values["access_token"] == nil || session.Values["access_token"] == "" {
+	if err != nil || session.Values["profile"] == nil || session.Values["profile"] == "" {
 		return m
 	}
 
-	reqUrl := os.Getenv("ISSUER") + "/oauth2/v1/userinfo"
+	json.Unmarshal([]byte(session.Values["profile"].(string)), &m)
 
-	req, _ := http.NewRequest("GET", reqUrl, bytes.NewReader([]byte("")))
-	h := req.Header
-	h.Add("Authorization", "Bearer "+session.Values["access_token"].(string))
-	h.Add("Accept", "application/json")
+	return m
+}
 
-	client := &http.Client{}
-	resp, _ := client.Do(req)
-	body, _ := io.ReadAll(resp.Body)
-	defer resp.Body.Close()
-	json.Unmarshal(body, &m)
+// tokenClaims are the ID token claims that describe the token itself rather
+// than the user, they are left off the profile page.
+var tokenClaims = map[string]bool{
+	"amr": true, "at_hash": true, "aud": true, "auth_time": true, "exp": true,
+	"iat": true, "idp": true, "iss": true, "jti": true, "nonce": true, "ver": true,
+}
 
+func profileFromClaims(claims map[string]interface{}) map[string]string {
+	m := make(map[string]string)
+	for k, v := range claims {
+		if tokenClaims[k] {
+			continue
+		}
+		switch c := v.(type) {
+		case string:
+			m[k] = c
+		case float64:
+			m[k] = strconv.FormatFloat(c, 'f', -1, 64)
+		default:
+			m[k] = fmt.Sprint(c)
+		}
+	}
 	return m
 }
 
-func verifyToken(t string) (*verifier.Jwt, error) {
+func (s *Server) verifyToken(t string) (*verifier.Jwt, error) {
 	tv := map[string]string{}
-	tv["aud"] = os.Getenv("ISSUER")
-	tv["cid"] = os.Getenv("CLIENT_ID")
+	tv["aud"] = s.config.Issuer
+	tv["cid"] = s.config.ClientID
 	jv := verifier.JwtVerifier{
-		Issuer:           os.Getenv("ISSUER"),
+		Issuer:           s.config.Issuer,
 		ClaimsToValidate: tv,
 	}
 
@@ -231,6 +540,31 @@ func verifyToken(t string) (*verifier.Jwt, error) {
 		return nil, fmt.Errorf("creating new verifier error: %s", err)
 	}
 	token, err := v.VerifyAccessToken(t)
+	if err != nil {
+		return nil, fmt.Errorf("verifying access token error: %s", err)
+	}
+
+	if token != nil {
+		return token, nil
+	}
+
+	return nil, fmt.Errorf("token could not be verified: %s", "")
+}
+
+func (s *Server) verifyIdToken(t string, nonce string) (*verifier.Jwt, error) {
+	tv := map[string]string{}
+	tv["nonce"] = nonce
+	tv["aud"] = s.config.ClientID
+	jv := verifier.JwtVerifier{
+		Issuer:           s.config.Issuer,
+		ClaimsToValidate: tv,
+	}
+
+	v, err := jv.New()
+	if err != nil {
+		return nil, fmt.Errorf("creating new verifier error: %s", err)
+	}
+	token, err := v.VerifyIdToken(t)
 	if err != nil {
 		return nil, fmt.Errorf("verifying ID token error: %s", err)
 	}
@@ -250,4 +584,5 @@ type Exchange struct {
 	ExpiresIn        int    `json:"expires_in,omitempty"`
 	Scope            string `json:"scope,omitempty"`
 	IdToken          string `json:"id_token,omitempty"`
+	RefreshToken     string `json:"refresh_token,omitempty"`
 }
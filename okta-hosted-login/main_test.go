@@ -0,0 +1,669 @@
+package main
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	oktaUtils "github.com/okta/samples-golang/okta-hosted-login/utils"
+)
+
+// fakeIssuer is an Okta authorization server that records the requests made
+// to its endpoints, answers token requests with tokenResponse and revocation
+// requests with revokeStatus. Its tokens
+// are signed with key.
+type fakeIssuer struct {
+	*httptest.Server
+	issuer string
+	key    *rsa.PrivateKey
+
+	mu            sync.Mutex
+	requests      map[string][]url.Values
+	tokenResponse map[string]interface{}
+	revokeStatus  int
+}
+
+func newFakeIssuer(t *testing.T) *fakeIssuer {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &fakeIssuer{key: key, requests: map[string][]url.Values{}, revokeStatus: http.StatusOK}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/oauth2/default/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 f.issuer,
+			"authorization_endpoint": f.issuer + "/v1/authorize",
+			"token_endpoint":         f.issuer + "/v1/token",
+			"revocation_endpoint":    f.issuer + "/v1/revoke",
+			"end_session_endpoint":   f.issuer + "/v1/logout",
+			"jwks_uri":               f.issuer + "/v1/keys",
+		})
+	})
+	mux.HandleFunc("/oauth2/default/v1/keys", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"alg": "RS256",
+				"use": "sig",
+				"kid": "key",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("/oauth2/default/v1/token", func(w http.ResponseWriter, r *http.Request) {
+		f.record(r)
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		if f.tokenResponse == nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		json.NewEncoder(w).Encode(f.tokenResponse)
+	})
+	mux.HandleFunc("/oauth2/default/v1/revoke", func(w http.ResponseWriter, r *http.Request) {
+		f.record(r)
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		w.WriteHeader(f.revokeStatus)
+	})
+	f.Server = httptest.NewServer(mux)
+	f.issuer = f.URL + "/oauth2/default"
+	t.Cleanup(f.Close)
+	return f
+}
+
+// record keeps the query and form of a request made to the issuer, with the
+// client it authenticated as.
+func (f *fakeIssuer) record(r *http.Request) {
+	r.ParseForm()
+	values := r.Form
+	if id, secret, ok := r.BasicAuth(); ok {
+		values.Set("basic_auth", id+":"+secret)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.requests[r.URL.Path] = append(f.requests[r.URL.Path], values)
+}
+
+// sign returns a JWT of claims signed with key.
+func sign(t *testing.T, key *rsa.PrivateKey, claims map[string]interface{}) string {
+	t.Helper()
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": "key"})
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	sum := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, sum[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+// tokens returns the token response of a login of Alice, whose ID token
+// carries nonce. edit can change the ID token's claims before it is signed.
+func (f *fakeIssuer) tokens(t *testing.T, nonce string, edit func(claims map[string]interface{})) map[string]interface{} {
+	t.Helper()
+	now := time.Now().Unix()
+	idClaims := map[string]interface{}{
+		"iss":   f.issuer,
+		"aud":   "client-id",
+		"sub":   "00u1a2b3c4d5e6f7g8h9",
+		"iat":   now,
+		"exp":   now + 3600,
+		"nonce": nonce,
+		"name":  "Alice Example",
+		"email": "alice@example.com",
+	}
+	if edit != nil {
+		edit(idClaims)
+	}
+	accessClaims := map[string]interface{}{
+		"iss": f.issuer,
+		"aud": f.issuer,
+		"cid": "client-id",
+		"sub": "alice@example.com",
+		"iat": now,
+		"exp": now + 3600,
+	}
+	return map[string]interface{}{
+		"token_type":    "Bearer",
+		"expires_in":    3600,
+		"scope":         "openid profile email offline_access",
+		"access_token":  sign(t, f.key, accessClaims),
+		"id_token":      sign(t, f.key, idClaims),
+		"refresh_token": "refresh-token",
+	}
+}
+
+func (f *fakeIssuer) requestsTo(endpoint string) []url.Values {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.requests["/oauth2/default/v1/"+endpoint]
+}
+
+func newTestServer(t *testing.T, issuer *fakeIssuer) *Server {
+	t.Helper()
+	c := defaults
+	c.ClientID = "client-id"
+	c.ClientSecret = "client-secret"
+	c.Issuer = issuer.issuer
+	c.SessionSecret = "session-secret"
+	c.PostLogoutRedirectURI = "http://localhost:8080/"
+	s, err := NewServer(&c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// browser keeps the cookies the server gave it, like a browser does.
+type browser struct {
+	cookies map[string]*http.Cookie
+}
+
+func newBrowser() *browser {
+	return &browser{cookies: map[string]*http.Cookie{}}
+}
+
+func (b *browser) do(handler http.Handler, path string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodGet, path, nil)
+	for _, c := range b.cookies {
+		r.AddCookie(c)
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	for _, c := range w.Result().Cookies() {
+		b.cookies[c.Name] = c
+	}
+	return w
+}
+
+// session returns the values of the browser's session.
+func (b *browser) session(t *testing.T, s *Server) map[interface{}]interface{} {
+	t.Helper()
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	for _, c := range b.cookies {
+		r.AddCookie(c)
+	}
+	session, err := s.sessionStore.Get(r, s.config.Cookie.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return session.Values
+}
+
+// login starts a login and returns the query of the authorize request it
+// redirected to.
+func (b *browser) login(t *testing.T, s *Server) url.Values {
+	t.Helper()
+	w := b.do(http.HandlerFunc(s.LoginHandler), "/login")
+	if w.Code != http.StatusFound {
+		t.Fatalf("login answered %d: %s", w.Code, w.Body)
+	}
+	location, err := url.Parse(w.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := location.Scheme+"://"+location.Host+location.Path, s.config.Issuer+"/v1/authorize"; got != want {
+		t.Fatalf("login redirected to %s, want %s", got, want)
+	}
+	return location.Query()
+}
+
+func TestLogin(t *testing.T) {
+	issuer := newFakeIssuer(t)
+	s := newTestServer(t, issuer)
+	b := newBrowser()
+
+	q := b.login(t, s)
+
+	session := b.session(t, s)
+	for param, key := range map[string]string{"state": "state", "nonce": "nonce"} {
+		if q.Get(param) == "" || q.Get(param) != session[key] {
+			t.Errorf("%s = %q, the session has %q", param, q.Get(param), session[key])
+		}
+	}
+	verifier, _ := session["code_verifier"].(string)
+	if len(verifier) < 43 {
+		t.Errorf("code verifier %q is shorter than RFC 7636 allows", verifier)
+	}
+	if q.Get("code_challenge") != oktaUtils.CodeChallengeS256(verifier) || q.Get("code_challenge_method") != "S256" {
+		t.Errorf("code challenge %q (%s) isn't the S256 of the session's verifier", q.Get("code_challenge"), q.Get("code_challenge_method"))
+	}
+	if q.Get("client_id") != "client-id" || q.Get("redirect_uri") != "http://localhost:8080/auth/okta/callback" {
+		t.Errorf("authorize request %v", q)
+	}
+
+	// Every login starts over with new values
+	again := b.login(t, s)
+	for _, param := range []string{"state", "nonce", "code_challenge"} {
+		if again.Get(param) == q.Get(param) {
+			t.Errorf("second login reused the %s", param)
+		}
+	}
+}
+
+func TestCallbackChecksTheState(t *testing.T) {
+	tests := []struct {
+		name  string
+		login bool
+		state string
+	}{
+		{name: "no login", state: "state"},
+		{name: "other state", login: true, state: "other"},
+		{name: "no state", login: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			issuer := newFakeIssuer(t)
+			s := newTestServer(t, issuer)
+			b := newBrowser()
+			if tt.login {
+				b.login(t, s)
+			}
+
+			w := b.do(http.HandlerFunc(s.AuthCodeCallbackHandler), "/auth/okta/callback?"+url.Values{"code": {"code"}, "state": {tt.state}}.Encode())
+
+			if !strings.Contains(w.Body.String(), "The state was not as expected") {
+				t.Errorf("callback answered %d: %s", w.Code, w.Body)
+			}
+			if n := len(issuer.requestsTo("token")); n != 0 {
+				t.Errorf("callback exchanged the code %d times", n)
+			}
+		})
+	}
+}
+
+func TestCallbackExchangesTheCodeOnce(t *testing.T) {
+	issuer := newFakeIssuer(t)
+	s := newTestServer(t, issuer)
+	b := newBrowser()
+	q := b.login(t, s)
+	verifier := b.session(t, s)["code_verifier"]
+	callback := "/auth/okta/callback?" + url.Values{"code": {"code"}, "state": {q.Get("state")}}.Encode()
+
+	b.do(http.HandlerFunc(s.AuthCodeCallbackHandler), callback)
+
+	requests := issuer.requestsTo("token")
+	if len(requests) != 1 {
+		t.Fatalf("callback made %d token requests, want 1", len(requests))
+	}
+	want := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {"code"},
+		"redirect_uri":  {"http://localhost:8080/auth/okta/callback"},
+		"code_verifier": {verifier.(string)},
+		"basic_auth":    {"client-id:client-secret"},
+	}
+	for k, v := range want {
+		if got := requests[0][k]; len(got) != 1 || got[0] != v[0] {
+			t.Errorf("token request has %s %v, want %v", k, got, v)
+		}
+	}
+
+	// The state, nonce and verifier are gone, a replayed callback fails
+	session := b.session(t, s)
+	for _, key := range []string{"state", "nonce", "code_verifier"} {
+		if _, found := session[key]; found {
+			t.Errorf("the session still has the %s", key)
+		}
+	}
+	if w := b.do(http.HandlerFunc(s.AuthCodeCallbackHandler), callback); !strings.Contains(w.Body.String(), "The state was not as expected") {
+		t.Errorf("replayed callback answered %d: %s", w.Code, w.Body)
+	}
+}
+
+// signIn goes through a login whose token response is built by tokens, and
+// returns the callback's answer.
+func (b *browser) signIn(t *testing.T, s *Server, issuer *fakeIssuer, edit func(claims map[string]interface{})) *httptest.ResponseRecorder {
+	t.Helper()
+	q := b.login(t, s)
+	nonce, _ := b.session(t, s)["nonce"].(string)
+	issuer.mu.Lock()
+	issuer.tokenResponse = issuer.tokens(t, nonce, edit)
+	issuer.mu.Unlock()
+	return b.do(http.HandlerFunc(s.AuthCodeCallbackHandler), "/auth/okta/callback?"+url.Values{"code": {"code"}, "state": {q.Get("state")}}.Encode())
+}
+
+func TestCallbackVerifiesTheIDToken(t *testing.T) {
+	tests := []struct {
+		name       string
+		edit       func(claims map[string]interface{})
+		wantSignIn bool
+	}{
+		{name: "valid", wantSignIn: true},
+		{name: "nonce of another login", edit: func(c map[string]interface{}) { c["nonce"] = "other" }},
+		{name: "no nonce", edit: func(c map[string]interface{}) { delete(c, "nonce") }},
+		{name: "other client", edit: func(c map[string]interface{}) { c["aud"] = "other-client" }},
+		{name: "other issuer", edit: func(c map[string]interface{}) { c["iss"] = "https://evil.example.com" }},
+		{name: "expired", edit: func(c map[string]interface{}) { c["exp"] = time.Now().Add(-time.Hour).Unix() }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			issuer := newFakeIssuer(t)
+			s := newTestServer(t, issuer)
+			b := newBrowser()
+
+			w := b.signIn(t, s, issuer, tt.edit)
+
+			if w.Code != http.StatusFound || w.Header().Get("Location") != "/" {
+				t.Errorf("callback answered %d to %q: %s", w.Code, w.Header().Get("Location"), w.Body)
+			}
+			session := b.session(t, s)
+			if _, signedIn := session["id_token"]; signedIn != tt.wantSignIn {
+				t.Errorf("signed in %v, want %v", signedIn, tt.wantSignIn)
+			}
+			if _, found := session["access_token"]; found != tt.wantSignIn {
+				t.Errorf("session has an access token %v, want %v", found, tt.wantSignIn)
+			}
+		})
+	}
+}
+
+func TestCallbackRejectsTokensOfAnotherKey(t *testing.T) {
+	issuer := newFakeIssuer(t)
+	s := newTestServer(t, issuer)
+	b := newBrowser()
+	// The tokens are signed with a key the issuer doesn't publish
+	other, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	issuer.key = other
+
+	b.signIn(t, s, issuer, nil)
+
+	if _, signedIn := b.session(t, s)["id_token"]; signedIn {
+		t.Error("signed in with tokens the issuer didn't sign")
+	}
+}
+
+func TestProfileComesFromTheIDToken(t *testing.T) {
+	issuer := newFakeIssuer(t)
+	s := newTestServer(t, issuer)
+	b := newBrowser()
+	b.signIn(t, s, issuer, nil)
+
+	r := httptest.NewRequest(http.MethodGet, "/profile", nil)
+	for _, c := range b.cookies {
+		r.AddCookie(c)
+	}
+	profile := s.getProfileData(r)
+
+	want := map[string]string{"sub": "00u1a2b3c4d5e6f7g8h9", "name": "Alice Example", "email": "alice@example.com"}
+	if len(profile) != len(want) {
+		t.Errorf("profile = %v, want %v", profile, want)
+	}
+	for k, v := range want {
+		if profile[k] != v {
+			t.Errorf("profile has %s %q, want %q", k, profile[k], v)
+		}
+	}
+	if n := len(issuer.requestsTo("userinfo")); n != 0 {
+		t.Errorf("the profile made %d userinfo requests", n)
+	}
+}
+
+// setSession saves values into the browser's session.
+func (b *browser) setSession(t *testing.T, s *Server, values map[string]interface{}) {
+	t.Helper()
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	for _, c := range b.cookies {
+		r.AddCookie(c)
+	}
+	session, err := s.sessionStore.Get(r, s.config.Cookie.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k, v := range values {
+		session.Values[k] = v
+	}
+	w := httptest.NewRecorder()
+	if err := session.Save(r, w); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range w.Result().Cookies() {
+		b.cookies[c.Name] = c
+	}
+}
+
+func TestLoginAsksForARefreshToken(t *testing.T) {
+	s := newTestServer(t, newFakeIssuer(t))
+
+	q := newBrowser().login(t, s)
+
+	if scopes := strings.Fields(q.Get("scope")); !contains(scopes, "offline_access") {
+		t.Errorf("login asked for scopes %v, without offline_access", scopes)
+	}
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func TestRefreshTokensMiddleware(t *testing.T) {
+	signedIn := map[string]interface{}{
+		"id_token":      "id-token",
+		"access_token":  "access-token",
+		"refresh_token": "refresh-token",
+		"profile":       `{"name": "Alice Example"}`,
+	}
+	tests := []struct {
+		name          string
+		path          string
+		expiresIn     time.Duration
+		tokenResponse map[string]interface{}
+		wantRefresh   bool
+		wantSession   map[string]interface{}
+		wantRedirect  bool
+	}{
+		{
+			name:        "far from expiring",
+			path:        "/profile",
+			expiresIn:   time.Hour,
+			wantSession: map[string]interface{}{"access_token": "access-token", "refresh_token": "refresh-token"},
+		},
+		{
+			name:          "about to expire",
+			path:          "/profile",
+			expiresIn:     30 * time.Second,
+			tokenResponse: map[string]interface{}{"access_token": "new-access-token", "refresh_token": "new-refresh-token", "expires_in": 3600},
+			wantRefresh:   true,
+			wantSession:   map[string]interface{}{"access_token": "new-access-token", "refresh_token": "new-refresh-token", "id_token": "id-token"},
+		},
+		{
+			name:          "expired, refresh token not rotated",
+			path:          "/profile",
+			expiresIn:     -time.Minute,
+			tokenResponse: map[string]interface{}{"access_token": "new-access-token", "id_token": "new-id-token", "expires_in": 3600},
+			wantRefresh:   true,
+			wantSession:   map[string]interface{}{"access_token": "new-access-token", "refresh_token": "refresh-token", "id_token": "new-id-token"},
+		},
+		{
+			name:         "refresh rejected",
+			path:         "/profile",
+			expiresIn:    30 * time.Second,
+			wantRefresh:  true,
+			wantSession:  map[string]interface{}{},
+			wantRedirect: true,
+		},
+		{
+			name:        "refresh rejected on the home page",
+			path:        "/",
+			expiresIn:   30 * time.Second,
+			wantRefresh: true,
+			wantSession: map[string]interface{}{},
+		},
+		{
+			name:        "logout",
+			path:        "/logout",
+			expiresIn:   30 * time.Second,
+			wantSession: map[string]interface{}{"access_token": "access-token", "refresh_token": "refresh-token"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			issuer := newFakeIssuer(t)
+			issuer.tokenResponse = tt.tokenResponse
+			s := newTestServer(t, issuer)
+			b := newBrowser()
+			b.setSession(t, s, signedIn)
+			b.setSession(t, s, map[string]interface{}{"expires_at": time.Now().Add(tt.expiresIn).Unix()})
+
+			served := false
+			w := b.do(s.refreshTokensMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				served = true
+			})), tt.path)
+
+			if served == tt.wantRedirect {
+				t.Errorf("page served %v, want %v", served, !tt.wantRedirect)
+			}
+			if tt.wantRedirect && (w.Code != http.StatusFound || w.Header().Get("Location") != "/") {
+				t.Errorf("answered %d to %q, want a redirect home", w.Code, w.Header().Get("Location"))
+			}
+
+			requests := issuer.requestsTo("token")
+			if refreshed := len(requests) > 0; refreshed != tt.wantRefresh {
+				t.Fatalf("refreshed %v, want %v", refreshed, tt.wantRefresh)
+			}
+			if tt.wantRefresh {
+				want := url.Values{
+					"grant_type":    {"refresh_token"},
+					"refresh_token": {"refresh-token"},
+					"scope":         {"openid profile email offline_access"},
+					"basic_auth":    {"client-id:client-secret"},
+				}
+				for k, v := range want {
+					if got := requests[0][k]; len(got) != 1 || got[0] != v[0] {
+						t.Errorf("refresh request has %s %v, want %v", k, got, v)
+					}
+				}
+			}
+
+			session := b.session(t, s)
+			for _, k := range []string{"id_token", "access_token", "refresh_token"} {
+				if want, ok := tt.wantSession[k]; ok && session[k] != want {
+					t.Errorf("session has %s %v, want %v", k, session[k], want)
+				}
+			}
+			if len(tt.wantSession) == 0 {
+				for _, k := range []string{"id_token", "access_token", "refresh_token", "expires_at", "profile"} {
+					if _, found := session[k]; found {
+						t.Errorf("the session still has the %s after a failed refresh", k)
+					}
+				}
+			}
+			if tt.tokenResponse != nil {
+				expires, _ := session["expires_at"].(int64)
+				if time.Until(time.Unix(expires, 0)) < 59*time.Minute {
+					t.Errorf("the refreshed token expires at %v", time.Unix(expires, 0))
+				}
+			}
+		})
+	}
+}
+
+func TestLogout(t *testing.T) {
+	tests := []struct {
+		name         string
+		session      map[string]interface{}
+		revokeStatus int
+		wantRevoked  []string
+		wantEndOkta  bool
+	}{
+		{
+			name: "signed in",
+			session: map[string]interface{}{
+				"id_token":      "id-token",
+				"access_token":  "access-token",
+				"refresh_token": "refresh-token",
+				"expires_at":    time.Now().Add(time.Hour).Unix(),
+				"profile":       `{"name": "Alice Example"}`,
+			},
+			revokeStatus: http.StatusOK,
+			wantRevoked:  []string{"refresh-token refresh_token", "access-token access_token"},
+			wantEndOkta:  true,
+		},
+		{
+			name: "revocation failed",
+			session: map[string]interface{}{
+				"id_token":      "id-token",
+				"access_token":  "access-token",
+				"refresh_token": "refresh-token",
+			},
+			revokeStatus: http.StatusInternalServerError,
+			wantRevoked:  []string{"refresh-token refresh_token", "access-token access_token"},
+			wantEndOkta:  true,
+		},
+		{
+			name:         "not signed in",
+			revokeStatus: http.StatusOK,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			issuer := newFakeIssuer(t)
+			issuer.revokeStatus = tt.revokeStatus
+			s := newTestServer(t, issuer)
+			b := newBrowser()
+			b.setSession(t, s, tt.session)
+
+			w := b.do(http.HandlerFunc(s.LogoutHandler), "/logout")
+
+			var revoked []string
+			for _, r := range issuer.requestsTo("revoke") {
+				if r.Get("basic_auth") != "client-id:client-secret" {
+					t.Errorf("revocation authenticated as %q", r.Get("basic_auth"))
+				}
+				revoked = append(revoked, r.Get("token")+" "+r.Get("token_type_hint"))
+			}
+			if strings.Join(revoked, ", ") != strings.Join(tt.wantRevoked, ", ") {
+				t.Errorf("revoked %v, want %v", revoked, tt.wantRevoked)
+			}
+
+			session := b.session(t, s)
+			for _, k := range []string{"id_token", "access_token", "refresh_token", "expires_at", "profile"} {
+				if _, found := session[k]; found {
+					t.Errorf("the session still has the %s", k)
+				}
+			}
+
+			if w.Code != http.StatusFound {
+				t.Fatalf("logout answered %d: %s", w.Code, w.Body)
+			}
+			want := "/"
+			if tt.wantEndOkta {
+				want = issuer.issuer + "/v1/logout?" + url.Values{
+					"id_token_hint":            {"id-token"},
+					"post_logout_redirect_uri": {"http://localhost:8080/"},
+				}.Encode()
+			}
+			if got := w.Header().Get("Location"); got != want {
+				t.Errorf("logout redirected to %s, want %s", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,27 @@
+package utils
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+)
+
+// GenerateCodeVerifier returns a random PKCE code_verifier as described in
+// RFC 7636 section 4.1.
+func GenerateCodeVerifier() (string, error) {
+	verifierBytes := make([]byte, 32)
+	_, err := rand.Read(verifierBytes)
+	if err != nil {
+		return "", fmt.Errorf("could not generate code verifier")
+	}
+
+	return base64.RawURLEncoding.EncodeToString(verifierBytes), nil
+}
+
+// CodeChallengeS256 derives the S256 code_challenge sent to the authorize
+// endpoint from the code_verifier kept in the session.
+func CodeChallengeS256(codeVerifier string) string {
+	sum := sha256.Sum256([]byte(codeVerifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
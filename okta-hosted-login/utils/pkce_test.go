@@ -0,0 +1,32 @@
+package utils
+
+import (
+	"regexp"
+	"testing"
+)
+
+func TestGenerateCodeVerifier(t *testing.T) {
+	// RFC 7636 section 4.1: 43 to 128 unreserved characters
+	unreserved := regexp.MustCompile(`^[A-Za-z0-9\-._~]{43,128}$`)
+	seen := map[string]bool{}
+	for i := 0; i < 10; i++ {
+		v, err := GenerateCodeVerifier()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !unreserved.MatchString(v) {
+			t.Errorf("code verifier %q isn't valid", v)
+		}
+		if seen[v] {
+			t.Errorf("code verifier %q was generated twice", v)
+		}
+		seen[v] = true
+	}
+}
+
+func TestCodeChallengeS256(t *testing.T) {
+	// The example of RFC 7636 appendix B
+	if got, want := CodeChallengeS256("dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"), "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"; got != want {
+		t.Errorf("CodeChallengeS256() = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,165 @@
+package auth
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// BearerError is an error response as defined by RFC 6750 section 3, or by
+// RFC 9449 section 7.1 for DPoP.
+type BearerError struct {
+	// Scheme is the authentication scheme of the challenge, Bearer unless
+	// set.
+	Scheme string
+	// Code is the error code, empty when the request had no token at all.
+	Code        string
+	Description string
+	// Scope is the scope needed, for insufficient_scope errors.
+	Scope  string
+	Status int
+}
+
+func (e *BearerError) Error() string {
+	if e.Code == "" {
+		return e.Description
+	}
+	return e.Code + ": " + e.Description
+}
+
+// Challenge returns the WWW-Authenticate header value for the error.
+func (e *BearerError) Challenge(realm string) string {
+	params := []string{fmt.Sprintf("realm=%q", realm)}
+	if e.Code != "" {
+		params = append(params, fmt.Sprintf("error=%q", e.Code))
+	}
+	if e.Description != "" && e.Code != "" {
+		params = append(params, fmt.Sprintf("error_description=%q", e.Description))
+	}
+	if e.Scope != "" {
+		params = append(params, fmt.Sprintf("scope=%q", e.Scope))
+	}
+	scheme := e.Scheme
+	if scheme == "" {
+		scheme = "Bearer"
+	}
+	if scheme == "DPoP" {
+		params = append(params, fmt.Sprintf("algs=%q", strings.Join(dpopAlgs, " ")))
+	}
+	return scheme + " " + strings.Join(params, ", ")
+}
+
+func missingToken() *BearerError {
+	return &BearerError{Description: "The request has no access token", Status: http.StatusUnauthorized}
+}
+
+// InvalidRequest is returned for malformed requests.
+func InvalidRequest(description string) *BearerError {
+	return &BearerError{Code: "invalid_request", Description: description, Status: http.StatusBadRequest}
+}
+
+// InvalidToken is returned for tokens that are expired, revoked, malformed
+// or otherwise fail verification.
+func InvalidToken(description string) *BearerError {
+	return &BearerError{Code: "invalid_token", Description: description, Status: http.StatusUnauthorized}
+}
+
+// InsufficientScope is returned when a valid token doesn't grant scope.
+func InsufficientScope(scope string) *BearerError {
+	return &BearerError{
+		Code:        "insufficient_scope",
+		Description: "The access token does not grant the " + scope + " scope",
+		Scope:       scope,
+		Status:      http.StatusForbidden,
+	}
+}
+
+// InvalidDPoPProof is returned when the DPoP proof of a request is missing
+// or doesn't check out.
+func InvalidDPoPProof(description string) *BearerError {
+	return &BearerError{Scheme: "DPoP", Code: "invalid_dpop_proof", Description: description, Status: http.StatusUnauthorized}
+}
+
+// BearerOptions enables the less secure ways RFC 6750 allows a client to
+// send its token, the Authorization header is always accepted.
+type BearerOptions struct {
+	// Form accepts an access_token parameter in form encoded request bodies.
+	Form bool
+	// Query accepts an access_token parameter in the query string.
+	Query bool
+	// DPoP accepts the DPoP authorization scheme as well as Bearer.
+	DPoP bool
+}
+
+// BearerToken returns the request's bearer token. A request must send its
+// token exactly one way.
+func BearerToken(r *http.Request, opts BearerOptions) (string, error) {
+	opts.DPoP = false
+	token, _, err := AccessToken(r, opts)
+	return token, err
+}
+
+// AccessToken returns the request's access token and the scheme it was sent
+// with, "Bearer" or "DPoP". A request must send its token exactly one way.
+func AccessToken(r *http.Request, opts BearerOptions) (string, string, error) {
+	var tokens []string
+	tokenScheme := "Bearer"
+
+	if h := r.Header.Get("Authorization"); h != "" {
+		scheme, token, ok := cutSpace(h)
+		switch {
+		case ok && strings.EqualFold(scheme, "Bearer"):
+		case ok && opts.DPoP && strings.EqualFold(scheme, "DPoP"):
+			tokenScheme = "DPoP"
+		case opts.DPoP:
+			return "", "", InvalidRequest("The Authorization header must use the Bearer or DPoP scheme")
+		default:
+			return "", "", InvalidRequest("The Authorization header must use the Bearer scheme")
+		}
+		if token == "" || strings.ContainsAny(token, " \t") {
+			return "", "", InvalidRequest("The Authorization header has a malformed access token")
+		}
+		tokens = append(tokens, token)
+	}
+
+	if opts.Form && r.Method != http.MethodGet && isFormEncoded(r) {
+		if err := r.ParseForm(); err != nil {
+			return "", "", InvalidRequest("The request body could not be parsed")
+		}
+		if values, ok := r.PostForm["access_token"]; ok {
+			tokens = append(tokens, values...)
+		}
+	}
+
+	if opts.Query {
+		if values, ok := r.URL.Query()["access_token"]; ok {
+			tokens = append(tokens, values...)
+		}
+	}
+
+	switch len(tokens) {
+	case 0:
+		return "", "", missingToken()
+	case 1:
+		if tokens[0] == "" {
+			return "", "", InvalidRequest("The access token is empty")
+		}
+		return tokens[0], tokenScheme, nil
+	default:
+		return "", "", InvalidRequest("The request must send its access token exactly once")
+	}
+}
+
+func cutSpace(s string) (string, string, bool) {
+	i := strings.IndexByte(s, ' ')
+	if i < 0 {
+		return s, "", false
+	}
+	return s[:i], strings.TrimLeft(s[i+1:], " "), true
+}
+
+func isFormEncoded(r *http.Request) bool {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return err == nil && mediaType == "application/x-www-form-urlencoded"
+}
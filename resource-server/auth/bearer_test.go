@@ -0,0 +1,102 @@
+package auth
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestBearerToken(t *testing.T) {
+	form := "application/x-www-form-urlencoded"
+
+	tests := []struct {
+		name        string
+		method      string
+		target      string
+		auth        string
+		contentType string
+		body        string
+		opts        BearerOptions
+		want        string
+		wantCode    string
+		wantStatus  int
+	}{
+		{name: "header", auth: "Bearer abc", want: "abc"},
+		{name: "scheme is case-insensitive", auth: "bearer abc", want: "abc"},
+		{name: "extra spaces after the scheme", auth: "Bearer   abc", want: "abc"},
+		{name: "no token", wantStatus: http.StatusUnauthorized},
+		{name: "other scheme", auth: "Basic abc", wantCode: "invalid_request", wantStatus: http.StatusBadRequest},
+		{name: "scheme only", auth: "Bearer", wantCode: "invalid_request", wantStatus: http.StatusBadRequest},
+		{name: "token with a space", auth: "Bearer abc def", wantCode: "invalid_request", wantStatus: http.StatusBadRequest},
+		{name: "query ignored by default", target: "/?access_token=abc", wantStatus: http.StatusUnauthorized},
+		{name: "query", target: "/?access_token=abc", opts: BearerOptions{Query: true}, want: "abc"},
+		{name: "empty query token", target: "/?access_token=", opts: BearerOptions{Query: true}, wantCode: "invalid_request", wantStatus: http.StatusBadRequest},
+		{name: "form ignored by default", method: http.MethodPost, contentType: form, body: "access_token=abc", wantStatus: http.StatusUnauthorized},
+		{name: "form", method: http.MethodPost, contentType: form, body: "access_token=abc", opts: BearerOptions{Form: true}, want: "abc"},
+		{name: "form needs a form body", method: http.MethodPost, contentType: "application/json", body: "access_token=abc", opts: BearerOptions{Form: true}, wantStatus: http.StatusUnauthorized},
+		{name: "header and query", target: "/?access_token=abc", auth: "Bearer abc", opts: BearerOptions{Query: true}, wantCode: "invalid_request", wantStatus: http.StatusBadRequest},
+		{name: "query twice", target: "/?access_token=abc&access_token=def", opts: BearerOptions{Query: true}, wantCode: "invalid_request", wantStatus: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			method, target := tt.method, tt.target
+			if method == "" {
+				method = http.MethodGet
+			}
+			if target == "" {
+				target = "/"
+			}
+			r := httptest.NewRequest(method, target, strings.NewReader(tt.body))
+			if tt.auth != "" {
+				r.Header.Set("Authorization", tt.auth)
+			}
+			if tt.contentType != "" {
+				r.Header.Set("Content-Type", tt.contentType)
+			}
+
+			got, err := BearerToken(r, tt.opts)
+			if tt.wantStatus == 0 {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got != tt.want {
+					t.Errorf("BearerToken() = %q, want %q", got, tt.want)
+				}
+				return
+			}
+
+			var be *BearerError
+			if !errors.As(err, &be) {
+				t.Fatalf("BearerToken() error = %v, want a *BearerError", err)
+			}
+			if be.Status != tt.wantStatus || be.Code != tt.wantCode {
+				t.Errorf("BearerToken() error = %d %q, want %d %q", be.Status, be.Code, tt.wantStatus, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestBearerErrorChallenge(t *testing.T) {
+	tests := []struct {
+		name string
+		err  *BearerError
+		want string
+	}{
+		{"missing token", missingToken(), `Bearer realm="api"`},
+		{"invalid token", InvalidToken("expired"), `Bearer realm="api", error="invalid_token", error_description="expired"`},
+		{
+			"insufficient scope",
+			InsufficientScope("messages:write"),
+			`Bearer realm="api", error="insufficient_scope", error_description="The access token does not grant the messages:write scope", scope="messages:write"`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.err.Challenge("api"); got != tt.want {
+				t.Errorf("Challenge() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,281 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// dpopAlgs are the proof signing algorithms the validator supports.
+var dpopAlgs = []string{"ES256", "RS256"}
+
+// DPoPConfig configures how DPoP proofs (RFC 9449) are checked.
+type DPoPConfig struct {
+	// BaseURL is the scheme and host clients use to reach the API, the
+	// proof's htu must be BaseURL followed by the request path.
+	BaseURL string
+	// MaxAge is how far from now a proof's iat may be, defaults to a minute.
+	MaxAge time.Duration
+	// ReplayCacheSize bounds the number of remembered proof ids, defaults to
+	// 10000.
+	ReplayCacheSize int
+}
+
+// DPoPValidator checks the DPoP proofs sent with DPoP bound access tokens.
+type DPoPValidator struct {
+	config DPoPConfig
+
+	mu    sync.Mutex
+	seen  map[string]time.Time
+	order []seenProof
+	next  int
+}
+
+// seenProof is a slot of the replay cache's eviction ring.
+type seenProof struct {
+	jti string
+	at  time.Time
+}
+
+func NewDPoPValidator(config DPoPConfig) *DPoPValidator {
+	if config.MaxAge == 0 {
+		config.MaxAge = time.Minute
+	}
+	if config.ReplayCacheSize == 0 {
+		config.ReplayCacheSize = 10000
+	}
+	config.BaseURL = strings.TrimSuffix(config.BaseURL, "/")
+
+	return &DPoPValidator{
+		config: config,
+		seen:   make(map[string]time.Time, config.ReplayCacheSize),
+		order:  make([]seenProof, config.ReplayCacheSize),
+	}
+}
+
+type dpopHeader struct {
+	Typ string          `json:"typ"`
+	Alg string          `json:"alg"`
+	JWK json.RawMessage `json:"jwk"`
+}
+
+type dpopClaims struct {
+	JTI string  `json:"jti"`
+	HTM string  `json:"htm"`
+	HTU string  `json:"htu"`
+	IAT float64 `json:"iat"`
+	ATH string  `json:"ath"`
+}
+
+// Validate checks the request's DPoP proof for accessToken and returns the
+// JWK thumbprint of the key that signed it, which the token's cnf.jkt claim
+// must match. Errors are an *BearerError.
+func (d *DPoPValidator) Validate(r *http.Request, accessToken string) (string, error) {
+	proofs := r.Header.Values("DPoP")
+	if len(proofs) != 1 {
+		return "", InvalidDPoPProof("The request must have exactly one DPoP header")
+	}
+
+	parts := strings.Split(proofs[0], ".")
+	if len(parts) != 3 {
+		return "", InvalidDPoPProof("The DPoP proof is not a JWT")
+	}
+
+	var header dpopHeader
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return "", InvalidDPoPProof("The DPoP proof header can't be decoded")
+	}
+	if header.Typ != "dpop+jwt" {
+		return "", InvalidDPoPProof("The DPoP proof typ must be dpop+jwt")
+	}
+
+	key, thumbprint, err := parseProofKey(header.JWK)
+	if err != nil {
+		return "", err
+	}
+	if err := verifySignature(header.Alg, key, parts); err != nil {
+		return "", err
+	}
+
+	var claims dpopClaims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return "", InvalidDPoPProof("The DPoP proof claims can't be decoded")
+	}
+	if claims.HTM != r.Method {
+		return "", InvalidDPoPProof("The DPoP proof htm does not match the request method")
+	}
+	if !d.matchesURL(claims.HTU, r) {
+		return "", InvalidDPoPProof("The DPoP proof htu does not match the request URL")
+	}
+	iat := time.Unix(int64(claims.IAT), 0)
+	if age := time.Since(iat); age > d.config.MaxAge || age < -d.config.MaxAge {
+		return "", InvalidDPoPProof("The DPoP proof iat is too far from the current time")
+	}
+	ath := sha256.Sum256([]byte(accessToken))
+	if claims.ATH != base64.RawURLEncoding.EncodeToString(ath[:]) {
+		return "", InvalidDPoPProof("The DPoP proof ath does not match the access token")
+	}
+	if claims.JTI == "" || !d.firstUse(claims.JTI) {
+		return "", InvalidDPoPProof("The DPoP proof has already been used")
+	}
+
+	return thumbprint, nil
+}
+
+func (d *DPoPValidator) matchesURL(htu string, r *http.Request) bool {
+	u, err := url.Parse(htu)
+	if err != nil {
+		return false
+	}
+	u.RawQuery = ""
+	u.Fragment = ""
+	return strings.EqualFold(u.Scheme+"://"+u.Host, d.config.BaseURL) && u.EscapedPath() == r.URL.EscapedPath()
+}
+
+// firstUse records jti and reports whether it wasn't seen within the time a
+// proof is accepted. Once the cache is full the oldest ids are forgotten.
+func (d *DPoPValidator) firstUse(jti string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := time.Now()
+	if seenAt, ok := d.seen[jti]; ok && now.Sub(seenAt) <= 2*d.config.MaxAge {
+		return false
+	}
+
+	// A jti seen again after its window has a newer slot, the old one must
+	// not forget it.
+	if old := d.order[d.next]; old.jti != "" && d.seen[old.jti].Equal(old.at) {
+		delete(d.seen, old.jti)
+	}
+	d.order[d.next] = seenProof{jti: jti, at: now}
+	d.next = (d.next + 1) % len(d.order)
+	d.seen[jti] = now
+	return true
+}
+
+// ConfirmationThumbprint returns the token's cnf.jkt claim, the thumbprint of
+// the key it is bound to, or "" for tokens that aren't DPoP bound.
+func (t *Token) ConfirmationThumbprint() string {
+	cnf, _ := t.Claims["cnf"].(map[string]interface{})
+	jkt, _ := cnf["jkt"].(string)
+	return jkt
+}
+
+type proofJWK struct {
+	Kty string `json:"kty"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	D   string `json:"d"`
+}
+
+// parseProofKey returns the public key in the proof header and its RFC 7638
+// thumbprint.
+func parseProofKey(raw json.RawMessage) (crypto.PublicKey, string, error) {
+	var jwk proofJWK
+	if err := json.Unmarshal(raw, &jwk); err != nil {
+		return nil, "", InvalidDPoPProof("The DPoP proof jwk can't be decoded")
+	}
+	if jwk.D != "" {
+		return nil, "", InvalidDPoPProof("The DPoP proof jwk must not contain a private key")
+	}
+
+	var key crypto.PublicKey
+	var members string
+	switch jwk.Kty {
+	case "EC":
+		if jwk.Crv != "P-256" {
+			return nil, "", InvalidDPoPProof(fmt.Sprintf("The DPoP proof jwk curve %q is not supported", jwk.Crv))
+		}
+		x, errX := base64.RawURLEncoding.DecodeString(jwk.X)
+		y, errY := base64.RawURLEncoding.DecodeString(jwk.Y)
+		if errX != nil || errY != nil {
+			return nil, "", InvalidDPoPProof("The DPoP proof jwk coordinates can't be decoded")
+		}
+		pub := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !pub.Curve.IsOnCurve(pub.X, pub.Y) {
+			return nil, "", InvalidDPoPProof("The DPoP proof jwk is not a valid P-256 key")
+		}
+		key = pub
+		members = fmt.Sprintf(`{"crv":%q,"kty":"EC","x":%q,"y":%q}`, jwk.Crv, jwk.X, jwk.Y)
+	case "RSA":
+		pub, err := rsaPublicKey(jwk.N, jwk.E)
+		if err != nil {
+			return nil, "", InvalidDPoPProof(fmt.Sprintf("The DPoP proof jwk is not a valid RSA key: %s", err))
+		}
+		key = pub
+		members = fmt.Sprintf(`{"e":%q,"kty":"RSA","n":%q}`, jwk.E, jwk.N)
+	default:
+		return nil, "", InvalidDPoPProof(fmt.Sprintf("The DPoP proof jwk type %q is not supported", jwk.Kty))
+	}
+
+	sum := sha256.Sum256([]byte(members))
+	return key, base64.RawURLEncoding.EncodeToString(sum[:]), nil
+}
+
+func verifySignature(alg string, key crypto.PublicKey, parts []string) error {
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return InvalidDPoPProof("The DPoP proof signature can't be decoded")
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+
+	switch pub := key.(type) {
+	case *ecdsa.PublicKey:
+		if alg != "ES256" || len(signature) != 64 {
+			return InvalidDPoPProof("The DPoP proof must be signed with ES256 for an EC key")
+		}
+		rInt := new(big.Int).SetBytes(signature[:32])
+		sInt := new(big.Int).SetBytes(signature[32:])
+		if !ecdsa.Verify(pub, digest[:], rInt, sInt) {
+			return InvalidDPoPProof("The DPoP proof signature is invalid")
+		}
+	case *rsa.PublicKey:
+		if alg != "RS256" {
+			return InvalidDPoPProof("The DPoP proof must be signed with RS256 for an RSA key")
+		}
+		if err := rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], signature); err != nil {
+			return InvalidDPoPProof("The DPoP proof signature is invalid")
+		}
+	}
+	return nil
+}
+
+func rsaPublicKey(n, e string) (*rsa.PublicKey, error) {
+	nb, err := base64.RawURLEncoding.DecodeString(n)
+	if err != nil {
+		return nil, fmt.Errorf("decoding modulus: %w", err)
+	}
+	eb, err := base64.RawURLEncoding.DecodeString(e)
+	if err != nil {
+		return nil, fmt.Errorf("decoding exponent: %w", err)
+	}
+	exp := new(big.Int).SetBytes(eb)
+	if !exp.IsInt64() || exp.Int64() > 1<<31-1 {
+		return nil, errors.New("exponent is too large")
+	}
+	return &rsa.PublicKey{N: new(big.Int).SetBytes(nb), E: int(exp.Int64())}, nil
+}
+
+func decodeSegment(seg string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
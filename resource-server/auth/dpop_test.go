@@ -0,0 +1,238 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// testProofKey signs DPoP proofs with a P-256 key.
+type testProofKey struct {
+	private *ecdsa.PrivateKey
+	jwk     map[string]string
+}
+
+func newTestProofKey(t *testing.T) *testProofKey {
+	t.Helper()
+	private, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	x := make([]byte, 32)
+	y := make([]byte, 32)
+	private.X.FillBytes(x)
+	private.Y.FillBytes(y)
+	return &testProofKey{
+		private: private,
+		jwk:     map[string]string{"kty": "EC", "crv": "P-256", "x": b64(x), "y": b64(y)},
+	}
+}
+
+func (k *testProofKey) thumbprint() string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf(`{"crv":"P-256","kty":"EC","x":%q,"y":%q}`, k.jwk["x"], k.jwk["y"])))
+	return b64(sum[:])
+}
+
+func (k *testProofKey) sign(t *testing.T, header, claims map[string]interface{}) string {
+	t.Helper()
+	h, _ := json.Marshal(header)
+	c, _ := json.Marshal(claims)
+	input := b64(h) + "." + b64(c)
+	digest := sha256.Sum256([]byte(input))
+	r, s, err := ecdsa.Sign(rand.Reader, k.private, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig := make([]byte, 64)
+	r.FillBytes(sig[:32])
+	s.FillBytes(sig[32:])
+	return input + "." + b64(sig)
+}
+
+// swapSignature returns proof with the signature of other.
+func swapSignature(proof, other string) string {
+	return proof[:strings.LastIndex(proof, ".")] + other[strings.LastIndex(other, "."):]
+}
+
+func accessTokenHash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return b64(sum[:])
+}
+
+func TestDPoPValidate(t *testing.T) {
+	key := newTestProofKey(t)
+	const accessToken = "access-token"
+
+	header := func() map[string]interface{} {
+		return map[string]interface{}{"typ": "dpop+jwt", "alg": "ES256", "jwk": key.jwk}
+	}
+	claims := func() map[string]interface{} {
+		return map[string]interface{}{
+			"jti": fmt.Sprint(time.Now().UnixNano()),
+			"htm": "POST",
+			"htu": "https://api.example.com/api/messages",
+			"iat": time.Now().Unix(),
+			"ath": accessTokenHash(accessToken),
+		}
+	}
+	withHeader := func(name string, value interface{}) string {
+		h := header()
+		h[name] = value
+		return key.sign(t, h, claims())
+	}
+	withClaim := func(name string, value interface{}) string {
+		c := claims()
+		c[name] = value
+		return key.sign(t, header(), c)
+	}
+	privateJWK := map[string]string{"d": "secret"}
+	for k, v := range key.jwk {
+		privateJWK[k] = v
+	}
+
+	tests := []struct {
+		name    string
+		proof   string
+		wantErr bool
+	}{
+		{"valid", key.sign(t, header(), claims()), false},
+		{"htu with a query", withClaim("htu", "https://api.example.com/api/messages?x=1"), false},
+		{"other typ", withHeader("typ", "JWT"), true},
+		{"other alg", withHeader("alg", "RS256"), true},
+		{"private key in jwk", withHeader("jwk", privateJWK), true},
+		{"other method", withClaim("htm", "GET"), true},
+		{"other URL", withClaim("htu", "https://evil.example.com/api/messages"), true},
+		{"old iat", withClaim("iat", time.Now().Add(-time.Hour).Unix()), true},
+		{"future iat", withClaim("iat", time.Now().Add(time.Hour).Unix()), true},
+		{"other access token", withClaim("ath", accessTokenHash("other")), true},
+		{"no jti", withClaim("jti", ""), true},
+		{"bad signature", swapSignature(key.sign(t, header(), claims()), key.sign(t, header(), claims())), true},
+		{"not a JWT", "not-a-jwt", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := NewDPoPValidator(DPoPConfig{BaseURL: "https://api.example.com/"})
+			r := httptest.NewRequest(http.MethodPost, "/api/messages", nil)
+			r.Header.Set("DPoP", tt.proof)
+
+			thumbprint, err := d.Validate(r, accessToken)
+			if tt.wantErr {
+				if err == nil {
+					t.Error("Validate() accepted the proof")
+				} else if be, ok := err.(*BearerError); !ok || be.Code != "invalid_dpop_proof" {
+					t.Errorf("Validate() error = %v, want an invalid_dpop_proof BearerError", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if thumbprint != key.thumbprint() {
+				t.Errorf("thumbprint = %s, want %s", thumbprint, key.thumbprint())
+			}
+		})
+	}
+}
+
+func TestDPoPValidateRejectsReplays(t *testing.T) {
+	key := newTestProofKey(t)
+	proof := key.sign(t,
+		map[string]interface{}{"typ": "dpop+jwt", "alg": "ES256", "jwk": key.jwk},
+		map[string]interface{}{
+			"jti": "once",
+			"htm": "GET",
+			"htu": "http://localhost:8000/api/messages",
+			"iat": time.Now().Unix(),
+			"ath": accessTokenHash("token"),
+		})
+	d := NewDPoPValidator(DPoPConfig{BaseURL: "http://localhost:8000"})
+
+	for i, wantErr := range []bool{false, true} {
+		r := httptest.NewRequest(http.MethodGet, "/api/messages", nil)
+		r.Header.Set("DPoP", proof)
+		if _, err := d.Validate(r, "token"); (err != nil) != wantErr {
+			t.Errorf("use %d: Validate() = %v, want error %v", i+1, err, wantErr)
+		}
+	}
+}
+
+func TestDPoPValidateNeedsOneProof(t *testing.T) {
+	d := NewDPoPValidator(DPoPConfig{BaseURL: "http://localhost:8000"})
+	for _, proofs := range [][]string{nil, {"a.b.c", "a.b.c"}} {
+		r := httptest.NewRequest(http.MethodGet, "/api/messages", nil)
+		for _, p := range proofs {
+			r.Header.Add("DPoP", p)
+		}
+		if _, err := d.Validate(r, "token"); err == nil {
+			t.Errorf("Validate() with %d proofs succeeded", len(proofs))
+		}
+	}
+}
+
+func TestFirstUseKeepsReusedJTI(t *testing.T) {
+	d := NewDPoPValidator(DPoPConfig{MaxAge: time.Minute, ReplayCacheSize: 2})
+
+	if !d.firstUse("a") {
+		t.Fatal("first use of a was rejected")
+	}
+	// a's window passes
+	past := time.Now().Add(-time.Hour)
+	d.seen["a"] = past
+	d.order[0].at = past
+
+	if !d.firstUse("a") {
+		t.Fatal("a was rejected after its window")
+	}
+	// b takes a's old slot, which must not forget the new a
+	if !d.firstUse("b") {
+		t.Fatal("first use of b was rejected")
+	}
+	if d.firstUse("a") {
+		t.Error("a was accepted again inside its new window")
+	}
+}
+
+func TestFirstUseForgetsOldest(t *testing.T) {
+	d := NewDPoPValidator(DPoPConfig{ReplayCacheSize: 2})
+	for _, jti := range []string{"a", "b", "c"} {
+		if !d.firstUse(jti) {
+			t.Fatalf("first use of %s was rejected", jti)
+		}
+	}
+	if len(d.seen) != 2 {
+		t.Errorf("remembers %d ids, want the cache size 2", len(d.seen))
+	}
+	if d.firstUse("c") {
+		t.Error("c was accepted twice")
+	}
+}
+
+func TestParseProofKeyThumbprint(t *testing.T) {
+	// The example of RFC 7638 section 3.1
+	raw := json.RawMessage(`{"kty":"RSA","e":"AQAB","alg":"RS256","kid":"2011-04-29","n":"` + strings.Join([]string{
+		"0vx7agoebGcQSuuPiLJXZptN9nndrQmbXEps2aiAFbWhM78LhWx4cbbfAAtVT86zwu1RK7aPFFxuhDR1L6tSoc_BJECPebWKRXjBZCiFV4n3oknjhMs",
+		"tn64tZ_2W-5JsGY4Hc5n9yBXArwl93lqt7_RN5w6Cf0h4QyQ5v-65YGjQR0_FDW2QvzqY368QQMicAtaSqzs8KJZgnYb9c7d0zgdAZHzu6qMQvRL5hajrn",
+		"1n91CbOpbISD08qNLyrdkt-bFTWhAI4vMQFh6WeZu0fM4lFd2NcRwr3XPksINHaQ-G_xBniIqbw0Ls1jF44-csFCur-kEgU8awapJzKnqDKgw",
+	}, "") + `"}`)
+
+	_, thumbprint, err := parseProofKey(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs"; thumbprint != want {
+		t.Errorf("thumbprint = %s, want %s", thumbprint, want)
+	}
+}
@@ -0,0 +1,156 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxIntrospectionCache bounds the number of cached introspection results.
+const maxIntrospectionCache = 10000
+
+// IntrospectionConfig turns on token introspection (RFC 7662) with the
+// resource server's own client credentials.
+type IntrospectionConfig struct {
+	ClientID     string
+	ClientSecret string
+	// Always introspects JWTs as well, instead of only tokens that can't be
+	// verified locally. Verify reuses cached results, so a revoked token is
+	// accepted until its result expires from the cache, VerifyNotRevoked
+	// asks the introspection endpoint every time.
+	Always bool
+	// CacheTTL caps how long a result is reused, by default it is reused
+	// until the token expires. A negative CacheTTL turns caching off.
+	CacheTTL time.Duration
+}
+
+type introspector struct {
+	endpoint string
+	config   IntrospectionConfig
+	client   *http.Client
+
+	mu    sync.Mutex
+	cache map[string]introspection
+}
+
+type introspection struct {
+	claims  map[string]interface{}
+	expires time.Time
+}
+
+func newIntrospector(endpoint string, config IntrospectionConfig) *introspector {
+	return &introspector{
+		endpoint: endpoint,
+		config:   config,
+		client:   &http.Client{Timeout: 10 * time.Second},
+		cache:    map[string]introspection{},
+	}
+}
+
+// introspect returns the claims of an active token, in the same shape as a
+// verified JWT's: cid is the client id and scp the list of scopes. Unless
+// fresh is set a cached result is returned when there is one.
+func (in *introspector) introspect(ctx context.Context, raw string, fresh bool) (map[string]interface{}, error) {
+	sum := sha256.Sum256([]byte(raw))
+	key := hex.EncodeToString(sum[:])
+
+	if !fresh {
+		in.mu.Lock()
+		cached, ok := in.cache[key]
+		in.mu.Unlock()
+		if ok && time.Now().Before(cached.expires) {
+			return cached.claims, nil
+		}
+	}
+
+	claims, err := in.fetch(ctx, raw)
+	if err != nil {
+		// A token found revoked mustn't be accepted from the cache either
+		in.mu.Lock()
+		delete(in.cache, key)
+		in.mu.Unlock()
+		return nil, err
+	}
+
+	if in.config.CacheTTL >= 0 {
+		expires := time.Now()
+		if exp, ok := claims["exp"].(float64); ok {
+			expires = time.Unix(int64(exp), 0)
+		}
+		if in.config.CacheTTL > 0 && time.Until(expires) > in.config.CacheTTL {
+			expires = time.Now().Add(in.config.CacheTTL)
+		}
+		in.store(key, introspection{claims: claims, expires: expires})
+	}
+	return claims, nil
+}
+
+func (in *introspector) store(key string, result introspection) {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+
+	if len(in.cache) >= maxIntrospectionCache {
+		now := time.Now()
+		for k, v := range in.cache {
+			if now.After(v.expires) {
+				delete(in.cache, k)
+			}
+		}
+		if len(in.cache) >= maxIntrospectionCache {
+			in.cache = map[string]introspection{}
+		}
+	}
+	in.cache[key] = result
+}
+
+func (in *introspector) fetch(ctx context.Context, raw string) (map[string]interface{}, error) {
+	form := url.Values{}
+	form.Set("token", raw)
+	form.Set("token_type_hint", "access_token")
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, in.endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.SetBasicAuth(in.config.ClientID, in.config.ClientSecret)
+	req.Header.Add("Accept", "application/json")
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := in.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("introspecting token: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("introspecting token: unexpected status %s", resp.Status)
+	}
+
+	var claims map[string]interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&claims); err != nil {
+		return nil, fmt.Errorf("decoding introspection response: %w", err)
+	}
+	if active, _ := claims["active"].(bool); !active {
+		return nil, errors.New("token is not active")
+	}
+
+	if _, ok := claims["cid"]; !ok {
+		claims["cid"] = claims["client_id"]
+	}
+	if scope, ok := claims["scope"].(string); ok {
+		var scp []interface{}
+		for _, s := range strings.Fields(scope) {
+			scp = append(scp, s)
+		}
+		claims["scp"] = scp
+	}
+	return claims, nil
+}
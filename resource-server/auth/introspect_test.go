@@ -0,0 +1,171 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testIntrospection is an introspection endpoint for a single token that can
+// be revoked.
+type testIntrospection struct {
+	*httptest.Server
+
+	mu      sync.Mutex
+	revoked bool
+	calls   int
+	claims  map[string]interface{}
+}
+
+func newTestIntrospection(t *testing.T, claims map[string]interface{}) *testIntrospection {
+	ti := &testIntrospection{claims: claims}
+	ti.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ti.mu.Lock()
+		defer ti.mu.Unlock()
+		ti.calls++
+		if id, secret, _ := r.BasicAuth(); id != "api" || secret != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if ti.revoked || r.PostFormValue("token") != "opaque" {
+			json.NewEncoder(w).Encode(map[string]bool{"active": false})
+			return
+		}
+		json.NewEncoder(w).Encode(ti.claims)
+	}))
+	t.Cleanup(ti.Close)
+	return ti
+}
+
+func (ti *testIntrospection) revoke() {
+	ti.mu.Lock()
+	defer ti.mu.Unlock()
+	ti.revoked = true
+}
+
+func (ti *testIntrospection) count() int {
+	ti.mu.Lock()
+	defer ti.mu.Unlock()
+	return ti.calls
+}
+
+func activeClaims() map[string]interface{} {
+	now := time.Now().Unix()
+	return map[string]interface{}{
+		"active":    true,
+		"iss":       "https://issuer.example.com",
+		"aud":       "api://default",
+		"client_id": "spa",
+		"sub":       "alice",
+		"scope":     "messages:read messages:write",
+		"iat":       now,
+		"exp":       now + 3600,
+	}
+}
+
+func newIntrospectingVerifier(endpoint string, config IntrospectionConfig) *Verifier {
+	config.ClientID, config.ClientSecret = "api", "secret"
+	return &Verifier{
+		config: Config{
+			Issuer:        "https://issuer.example.com",
+			Audience:      "api://default",
+			ClientID:      "spa",
+			Introspection: &config,
+		},
+		introspect: newIntrospector(endpoint, config),
+	}
+}
+
+func TestIntrospectionClaims(t *testing.T) {
+	endpoint := newTestIntrospection(t, activeClaims())
+	v := newIntrospectingVerifier(endpoint.URL, IntrospectionConfig{})
+
+	token, err := v.Verify(context.Background(), "opaque")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cid := token.Claims["cid"]; cid != "spa" {
+		t.Errorf("cid = %v, want the client_id spa", cid)
+	}
+	scp, _ := token.Claims["scp"].([]interface{})
+	if len(scp) != 2 || scp[0] != "messages:read" || scp[1] != "messages:write" {
+		t.Errorf("scp = %v, want the scopes as a list", token.Claims["scp"])
+	}
+
+	if _, err := v.Verify(context.Background(), "unknown"); err == nil {
+		t.Error("Verify() accepted an inactive token")
+	}
+}
+
+func TestIntrospectionValidatesClaims(t *testing.T) {
+	tests := []struct {
+		name  string
+		key   string
+		value interface{}
+	}{
+		{"other issuer", "iss", "https://other.example.com"},
+		{"other audience", "aud", "api://other"},
+		{"other client", "client_id", "other"},
+		{"expired", "exp", time.Now().Add(-time.Hour).Unix()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := activeClaims()
+			claims[tt.key] = tt.value
+			endpoint := newTestIntrospection(t, claims)
+			v := newIntrospectingVerifier(endpoint.URL, IntrospectionConfig{})
+
+			if _, err := v.Verify(context.Background(), "opaque"); err == nil {
+				t.Error("Verify() accepted the token")
+			}
+		})
+	}
+}
+
+func TestIntrospectionCache(t *testing.T) {
+	endpoint := newTestIntrospection(t, activeClaims())
+	v := newIntrospectingVerifier(endpoint.URL, IntrospectionConfig{})
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		if _, err := v.Verify(ctx, "opaque"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := endpoint.count(); n != 1 {
+		t.Fatalf("introspected %d times, want once", n)
+	}
+
+	endpoint.revoke()
+
+	// Reads are served from the cache until the result expires
+	if _, err := v.Verify(ctx, "opaque"); err != nil {
+		t.Errorf("Verify() = %v, want the cached result", err)
+	}
+	// but VerifyNotRevoked always asks
+	if _, err := v.VerifyNotRevoked(ctx, "opaque"); err == nil {
+		t.Error("VerifyNotRevoked() accepted a revoked token")
+	}
+	// and the revocation it saw isn't cached away
+	if _, err := v.Verify(ctx, "opaque"); err == nil {
+		t.Error("Verify() accepted a token found revoked")
+	}
+}
+
+func TestIntrospectionCacheDisabled(t *testing.T) {
+	endpoint := newTestIntrospection(t, activeClaims())
+	v := newIntrospectingVerifier(endpoint.URL, IntrospectionConfig{CacheTTL: -1})
+
+	for i := 0; i < 3; i++ {
+		if _, err := v.Verify(context.Background(), "opaque"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := endpoint.count(); n != 3 {
+		t.Errorf("introspected %d times, want every time", n)
+	}
+}
@@ -0,0 +1,228 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lestrrat-go/jwx/jwa"
+	"github.com/lestrrat-go/jwx/jwk"
+	"github.com/lestrrat-go/jwx/jws"
+)
+
+// testIssuer serves a JWKS that tests can swap or break.
+type testIssuer struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	keys     []jwk.Key
+	status   int
+	requests int
+}
+
+func newTestIssuer(t *testing.T, keys ...jwk.Key) *testIssuer {
+	ti := &testIssuer{keys: keys, status: http.StatusOK}
+	ti.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ti.mu.Lock()
+		defer ti.mu.Unlock()
+		ti.requests++
+		if ti.status != http.StatusOK {
+			w.WriteHeader(ti.status)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": ti.keys})
+	}))
+	t.Cleanup(ti.Close)
+	return ti
+}
+
+func (ti *testIssuer) set(status int, keys ...jwk.Key) {
+	ti.mu.Lock()
+	defer ti.mu.Unlock()
+	ti.status = status
+	if keys != nil {
+		ti.keys = keys
+	}
+}
+
+func (ti *testIssuer) fetches() int {
+	ti.mu.Lock()
+	defer ti.mu.Unlock()
+	return ti.requests
+}
+
+// newSigningKey returns a private key and its public JWK with the given kid.
+func newSigningKey(t *testing.T, kid string) (*rsa.PrivateKey, jwk.Key) {
+	t.Helper()
+	private, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	public, err := jwk.New(&private.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	public.Set(jwk.KeyIDKey, kid)
+	public.Set(jwk.AlgorithmKey, "RS256")
+	public.Set(jwk.KeyUsageKey, "sig")
+	return private, public
+}
+
+func signToken(t *testing.T, key *rsa.PrivateKey, kid string, claims map[string]interface{}) string {
+	t.Helper()
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	headers := jws.NewHeaders()
+	headers.Set(jws.KeyIDKey, kid)
+	token, err := jws.Sign(payload, jwa.RS256, key, jws.WithHeaders(headers))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(token)
+}
+
+func TestKeySetCachesKeys(t *testing.T) {
+	_, a := newSigningKey(t, "a")
+	issuer := newTestIssuer(t, a)
+	ks := NewKeySet(issuer.URL, time.Hour)
+
+	for i := 0; i < 3; i++ {
+		if _, err := ks.Keys(context.Background(), "a"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if n := issuer.fetches(); n != 1 {
+		t.Errorf("fetched the JWKS %d times, want once", n)
+	}
+	want := KeyCacheStats{Hits: 2, Misses: 1, Fetches: 1}
+	if got := ks.Stats(); got != want {
+		t.Errorf("Stats() = %+v, want %+v", got, want)
+	}
+}
+
+func TestKeySetUnknownKey(t *testing.T) {
+	_, a := newSigningKey(t, "a")
+	_, b := newSigningKey(t, "b")
+	issuer := newTestIssuer(t, a)
+	ks := NewKeySet(issuer.URL, time.Hour)
+	if _, err := ks.Keys(context.Background(), "a"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The issuer rotates to key b
+	issuer.set(http.StatusOK, b)
+
+	// Right after a fetch, an unknown kid doesn't fetch again
+	if _, err := ks.Keys(context.Background(), "b"); !errors.Is(err, ErrUnknownKey) {
+		t.Fatalf("Keys(b) right after a fetch = %v, want ErrUnknownKey", err)
+	}
+	if n := issuer.fetches(); n != 1 {
+		t.Fatalf("fetched the JWKS %d times, want once", n)
+	}
+
+	// Later on, it picks up the rotated key
+	ks.mu.Lock()
+	ks.attemptedAt = time.Now().Add(-minRefetchInterval)
+	ks.mu.Unlock()
+	keys, err := ks.Keys(context.Background(), "b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := keys.LookupKeyID("b"); !ok {
+		t.Error("the rotated key is not in the returned set")
+	}
+	if n := issuer.fetches(); n != 2 {
+		t.Errorf("fetched the JWKS %d times, want twice", n)
+	}
+}
+
+func TestKeySetRefreshesAfterTTL(t *testing.T) {
+	_, a := newSigningKey(t, "a")
+	issuer := newTestIssuer(t, a)
+	ks := NewKeySet(issuer.URL, time.Millisecond)
+
+	if _, err := ks.Keys(context.Background(), "a"); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(5 * time.Millisecond)
+	if _, err := ks.Keys(context.Background(), "a"); err != nil {
+		t.Fatal(err)
+	}
+	if n := issuer.fetches(); n != 2 {
+		t.Errorf("fetched the JWKS %d times, want twice", n)
+	}
+
+	// Stale keys are still used while the issuer can't be reached
+	issuer.set(http.StatusInternalServerError)
+	time.Sleep(5 * time.Millisecond)
+	if _, err := ks.Keys(context.Background(), "a"); err != nil {
+		t.Errorf("Keys() with the issuer down = %v, want the stale key", err)
+	}
+}
+
+func TestKeySetMergesConcurrentFetches(t *testing.T) {
+	_, a := newSigningKey(t, "a")
+	issuer := newTestIssuer(t, a)
+	ks := NewKeySet(issuer.URL, time.Hour)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := ks.Keys(context.Background(), "a"); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if n := issuer.fetches(); n != 1 {
+		t.Errorf("fetched the JWKS %d times, want once", n)
+	}
+}
+
+func TestKeySetAdaptorDecode(t *testing.T) {
+	private, a := newSigningKey(t, "a")
+	other, _ := newSigningKey(t, "a")
+	issuer := newTestIssuer(t, a)
+	adaptor := &keySetAdaptor{keys: NewKeySet(issuer.URL, time.Hour)}
+
+	tests := []struct {
+		name    string
+		token   string
+		wantErr bool
+	}{
+		{"signed with the issuer's key", signToken(t, private, "a", map[string]interface{}{"sub": "alice"}), false},
+		{"signed with another key", signToken(t, other, "a", map[string]interface{}{"sub": "alice"}), true},
+		{"unknown kid", signToken(t, private, "z", map[string]interface{}{"sub": "alice"}), true},
+		{"not a JWS", "not-a-token", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims, err := adaptor.Decode(tt.token, issuer.URL)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("Decode() = %v, want an error", claims)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if sub := claims.(map[string]interface{})["sub"]; sub != "alice" {
+				t.Errorf("sub = %v, want alice", sub)
+			}
+		})
+	}
+}
@@ -0,0 +1,169 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	jwtverifier "github.com/okta/okta-jwt-verifier-golang"
+	"github.com/okta/samples-golang/discovery"
+)
+
+// Config describes the access tokens a Verifier accepts.
+type Config struct {
+	Issuer   string
+	Audience string
+	// ClientID, if set, must match the token's cid claim.
+	ClientID string
+	// KeyCacheTTL is how long the issuer's signing keys are cached,
+	// defaults to an hour.
+	KeyCacheTTL time.Duration
+	// Leeway allows for clock skew when checking exp and iat, defaults to
+	// the okta-jwt-verifier's two minutes.
+	Leeway time.Duration
+	// Introspection, if set, is used for tokens that aren't JWTs.
+	Introspection *IntrospectionConfig
+}
+
+// Verifier verifies Okta access tokens. It is meant to be created once and
+// shared by every request so the issuer's keys are only fetched when needed.
+type Verifier struct {
+	config     Config
+	keys       *KeySet
+	jwt        *jwtverifier.JwtVerifier
+	introspect *introspector
+}
+
+// Token is a verified access token.
+type Token struct {
+	Raw    string
+	Claims map[string]interface{}
+}
+
+func NewVerifier(ctx context.Context, config Config) (*Verifier, error) {
+	if config.KeyCacheTTL == 0 {
+		config.KeyCacheTTL = time.Hour
+	}
+
+	oidc, err := discovery.Get(ctx, config.Issuer)
+	if err != nil {
+		return nil, err
+	}
+
+	claims := map[string]string{"aud": config.Audience}
+	if config.ClientID != "" {
+		claims["cid"] = config.ClientID
+	}
+	keys := NewKeySet(oidc.JwksURI, config.KeyCacheTTL)
+	jv := &jwtverifier.JwtVerifier{
+		Issuer:           config.Issuer,
+		ClaimsToValidate: claims,
+		Adaptor:          &keySetAdaptor{keys: keys},
+	}
+	jv = jv.New()
+	if config.Leeway != 0 {
+		jv.SetLeeway(config.Leeway.String())
+	}
+
+	v := &Verifier{
+		config: config,
+		keys:   keys,
+		jwt:    jv,
+	}
+	if config.Introspection != nil {
+		if oidc.IntrospectionEndpoint == "" {
+			return nil, fmt.Errorf("%s has no introspection endpoint", config.Issuer)
+		}
+		v.introspect = newIntrospector(oidc.IntrospectionEndpoint, *config.Introspection)
+	}
+	return v, nil
+}
+
+// KeyCacheStats returns the usage counts of the signing key cache.
+func (v *Verifier) KeyCacheStats() KeyCacheStats {
+	return v.keys.Stats()
+}
+
+// Verify checks the token's signature and its iss, aud, cid, exp and iat
+// claims. Opaque tokens, or every token when Introspection.Always is set, are
+// checked with the authorization server's introspection endpoint instead,
+// whose results are cached.
+func (v *Verifier) Verify(ctx context.Context, raw string) (*Token, error) {
+	return v.verify(ctx, raw, false)
+}
+
+// VerifyNotRevoked is Verify for routes that must not accept a revoked
+// token, like those changing data. Tokens checked with the introspection
+// endpoint are never checked against a cached result. JWTs are only
+// introspected when Introspection.Always is set, otherwise a revoked JWT is
+// accepted until it expires.
+func (v *Verifier) VerifyNotRevoked(ctx context.Context, raw string) (*Token, error) {
+	return v.verify(ctx, raw, true)
+}
+
+func (v *Verifier) verify(ctx context.Context, raw string, fresh bool) (*Token, error) {
+	if v.introspect != nil && (v.config.Introspection.Always || strings.Count(raw, ".") != 2) {
+		claims, err := v.introspect.introspect(ctx, raw, fresh)
+		if err != nil {
+			return nil, err
+		}
+		if err := v.validate(claims); err != nil {
+			return nil, err
+		}
+		return &Token{Raw: raw, Claims: claims}, nil
+	}
+
+	if strings.Count(raw, ".") != 2 {
+		return nil, errors.New("token is not a JWT")
+	}
+
+	jwt, err := v.jwt.VerifyAccessToken(raw)
+	if err != nil {
+		return nil, err
+	}
+	return &Token{Raw: raw, Claims: jwt.Claims}, nil
+}
+
+// validate checks the claims of an introspected token, which the
+// introspection endpoint doesn't check against this API's settings.
+func (v *Verifier) validate(claims map[string]interface{}) error {
+	if iss, _ := claims["iss"].(string); iss != v.config.Issuer {
+		return fmt.Errorf("token issuer %q is not %q", iss, v.config.Issuer)
+	}
+
+	if !hasAudience(claims["aud"], v.config.Audience) {
+		return fmt.Errorf("token audience is not %q", v.config.Audience)
+	}
+
+	if v.config.ClientID != "" {
+		if cid, _ := claims["cid"].(string); cid != v.config.ClientID {
+			return fmt.Errorf("token client id %q is not %q", cid, v.config.ClientID)
+		}
+	}
+
+	now := time.Now()
+	exp, ok := claims["exp"].(float64)
+	if !ok || now.After(time.Unix(int64(exp), 0).Add(v.config.Leeway)) {
+		return errors.New("token is expired")
+	}
+	if iat, ok := claims["iat"].(float64); ok && now.Add(v.config.Leeway).Before(time.Unix(int64(iat), 0)) {
+		return errors.New("token was issued in the future")
+	}
+	return nil
+}
+
+func hasAudience(aud interface{}, audience string) bool {
+	switch a := aud.(type) {
+	case string:
+		return a == audience
+	case []interface{}:
+		for _, s := range a {
+			if s == audience {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,99 @@
+package auth
+
+import (
+	"context"
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/lestrrat-go/jwx/jwk"
+)
+
+// newTestAuthorizationServer serves the discovery document and JWKS of an
+// issuer signing with key.
+func newTestAuthorizationServer(t *testing.T, key jwk.Key) *httptest.Server {
+	mux := http.NewServeMux()
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 srv.URL,
+			"jwks_uri":               srv.URL + "/keys",
+			"introspection_endpoint": srv.URL + "/introspect",
+		})
+	})
+	mux.HandleFunc("/keys", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": []jwk.Key{key}})
+	})
+	return srv
+}
+
+func TestVerifierVerify(t *testing.T) {
+	private, public := newSigningKey(t, "a")
+	srv := newTestAuthorizationServer(t, public)
+
+	v, err := NewVerifier(context.Background(), Config{
+		Issuer:   srv.URL,
+		Audience: "api://default",
+		ClientID: "spa",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	valid := func() map[string]interface{} {
+		now := time.Now().Unix()
+		return map[string]interface{}{
+			"iss": srv.URL,
+			"aud": "api://default",
+			"cid": "spa",
+			"sub": "alice",
+			"iat": now,
+			"exp": now + 3600,
+		}
+	}
+	with := func(key string, value interface{}) map[string]interface{} {
+		claims := valid()
+		claims[key] = value
+		return claims
+	}
+
+	tests := []struct {
+		name    string
+		key     *rsa.PrivateKey
+		claims  map[string]interface{}
+		wantErr bool
+	}{
+		{"valid", private, valid(), false},
+		{"other issuer", private, with("iss", "https://example.com"), true},
+		{"other audience", private, with("aud", "api://other"), true},
+		{"other client", private, with("cid", "other"), true},
+		{"expired", private, with("exp", time.Now().Add(-time.Hour).Unix()), true},
+		{"issued in the future", private, with("iat", time.Now().Add(time.Hour).Unix()), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token, err := v.Verify(context.Background(), signToken(t, tt.key, "a", tt.claims))
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("Verify() = %v, want an error", token.Claims)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if sub := token.Claims["sub"]; sub != "alice" {
+				t.Errorf("sub = %v, want alice", sub)
+			}
+		})
+	}
+
+	if _, err := v.Verify(context.Background(), "opaque-token"); err == nil {
+		t.Error("Verify() accepted an opaque token without introspection")
+	}
+}
@@ -0,0 +1,126 @@
+// Package cors implements a configurable Cross-Origin Resource Sharing policy.
+package cors
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/okta/samples-golang/resource-server/problem"
+)
+
+// Policy lists what browsers on other origins may do with the API.
+type Policy struct {
+	// AllowedOrigins are matched exactly against the Origin header, for
+	// example https://spa.example.com. "*" allows every origin.
+	AllowedOrigins []string
+	AllowedMethods []string
+	AllowedHeaders []string
+	// ExposedHeaders are response headers scripts are allowed to read.
+	ExposedHeaders   []string
+	AllowCredentials bool
+	// MaxAge is how long browsers may cache a preflight response.
+	MaxAge time.Duration
+}
+
+// Validate reports configurations browsers would reject, and origins that
+// can never match an Origin header.
+func (p *Policy) Validate() error {
+	var problems []string
+	for _, origin := range p.AllowedOrigins {
+		if origin == "*" {
+			continue
+		}
+		u, err := url.Parse(origin)
+		if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" ||
+			u.User != nil || u.Path != "" || u.RawQuery != "" || u.Fragment != "" {
+			problems = append(problems, fmt.Sprintf("origin %q must be a scheme and host only, like https://spa.example.com", origin))
+		}
+	}
+	if p.AllowCredentials && p.allowsAnyOrigin() {
+		problems = append(problems, "credentials can't be allowed for every origin, list the allowed origins instead")
+	}
+	if p.MaxAge < 0 {
+		problems = append(problems, "the max age of preflight responses can't be negative")
+	}
+	if len(problems) > 0 {
+		return errors.New("cors: " + strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// Handler applies the policy to requests before passing them on to next.
+// Preflight requests are answered without calling next.
+func (p *Policy) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		h := w.Header()
+		h.Add("Vary", "Origin")
+		preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+
+		if !p.allowsOrigin(origin) {
+			if preflight {
+				problem.Write(w, http.StatusForbidden, "Origin "+origin+" is not allowed")
+				return
+			}
+			// Without CORS headers the browser won't let the page read the
+			// response.
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		h.Set("Access-Control-Allow-Origin", origin)
+		if p.AllowCredentials {
+			h.Set("Access-Control-Allow-Credentials", "true")
+		}
+
+		if !preflight {
+			if len(p.ExposedHeaders) > 0 {
+				h.Set("Access-Control-Expose-Headers", strings.Join(p.ExposedHeaders, ", "))
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		h.Add("Vary", "Access-Control-Request-Method")
+		h.Add("Vary", "Access-Control-Request-Headers")
+		if !contains(p.AllowedMethods, r.Header.Get("Access-Control-Request-Method")) {
+			problem.Write(w, http.StatusForbidden, "Method "+r.Header.Get("Access-Control-Request-Method")+" is not allowed")
+			return
+		}
+		h.Set("Access-Control-Allow-Methods", strings.Join(p.AllowedMethods, ", "))
+		if len(p.AllowedHeaders) > 0 {
+			h.Set("Access-Control-Allow-Headers", strings.Join(p.AllowedHeaders, ", "))
+		}
+		if p.MaxAge > 0 {
+			h.Set("Access-Control-Max-Age", strconv.Itoa(int(p.MaxAge.Seconds())))
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+func (p *Policy) allowsAnyOrigin() bool {
+	return contains(p.AllowedOrigins, "*")
+}
+
+func (p *Policy) allowsOrigin(origin string) bool {
+	return p.allowsAnyOrigin() || contains(p.AllowedOrigins, origin)
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,149 @@
+package cors
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestPolicyHandler(t *testing.T) {
+	policy := &Policy{
+		AllowedOrigins:   []string{"https://spa.example.com"},
+		AllowedMethods:   []string{"GET", "POST"},
+		AllowedHeaders:   []string{"Authorization", "Content-Type"},
+		ExposedHeaders:   []string{"Location"},
+		AllowCredentials: true,
+		MaxAge:           10 * time.Minute,
+	}
+
+	tests := []struct {
+		name          string
+		method        string
+		origin        string
+		requestMethod string
+		wantStatus    int
+		wantNext      bool
+		wantHeaders   map[string]string
+	}{
+		{
+			name:       "same origin",
+			method:     http.MethodGet,
+			wantStatus: http.StatusOK,
+			wantNext:   true,
+			wantHeaders: map[string]string{
+				"Access-Control-Allow-Origin": "",
+			},
+		},
+		{
+			name:       "allowed origin",
+			method:     http.MethodGet,
+			origin:     "https://spa.example.com",
+			wantStatus: http.StatusOK,
+			wantNext:   true,
+			wantHeaders: map[string]string{
+				"Access-Control-Allow-Origin":      "https://spa.example.com",
+				"Access-Control-Allow-Credentials": "true",
+				"Access-Control-Expose-Headers":    "Location",
+				"Vary":                             "Origin",
+			},
+		},
+		{
+			name:       "other origin",
+			method:     http.MethodGet,
+			origin:     "https://evil.example.com",
+			wantStatus: http.StatusOK,
+			wantNext:   true,
+			wantHeaders: map[string]string{
+				"Access-Control-Allow-Origin": "",
+			},
+		},
+		{
+			name:          "preflight",
+			method:        http.MethodOptions,
+			origin:        "https://spa.example.com",
+			requestMethod: "POST",
+			wantStatus:    http.StatusNoContent,
+			wantHeaders: map[string]string{
+				"Access-Control-Allow-Origin":  "https://spa.example.com",
+				"Access-Control-Allow-Methods": "GET, POST",
+				"Access-Control-Allow-Headers": "Authorization, Content-Type",
+				"Access-Control-Max-Age":       "600",
+			},
+		},
+		{
+			name:          "preflight from other origin",
+			method:        http.MethodOptions,
+			origin:        "https://evil.example.com",
+			requestMethod: "POST",
+			wantStatus:    http.StatusForbidden,
+			wantHeaders: map[string]string{
+				"Access-Control-Allow-Origin": "",
+			},
+		},
+		{
+			name:          "preflight for other method",
+			method:        http.MethodOptions,
+			origin:        "https://spa.example.com",
+			requestMethod: "DELETE",
+			wantStatus:    http.StatusForbidden,
+			wantHeaders: map[string]string{
+				"Access-Control-Allow-Methods": "",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			called := false
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				called = true
+			})
+
+			r := httptest.NewRequest(tt.method, "/api/messages", nil)
+			if tt.origin != "" {
+				r.Header.Set("Origin", tt.origin)
+			}
+			if tt.requestMethod != "" {
+				r.Header.Set("Access-Control-Request-Method", tt.requestMethod)
+			}
+			w := httptest.NewRecorder()
+			policy.Handler(next).ServeHTTP(w, r)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if called != tt.wantNext {
+				t.Errorf("next called = %v, want %v", called, tt.wantNext)
+			}
+			for header, want := range tt.wantHeaders {
+				if got := w.Header().Get(header); got != want {
+					t.Errorf("%s = %q, want %q", header, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestPolicyValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  Policy
+		wantErr bool
+	}{
+		{"listed origins", Policy{AllowedOrigins: []string{"https://spa.example.com"}, AllowCredentials: true}, false},
+		{"any origin", Policy{AllowedOrigins: []string{"*"}}, false},
+		{"any origin with credentials", Policy{AllowedOrigins: []string{"*"}, AllowCredentials: true}, true},
+		{"origin without scheme", Policy{AllowedOrigins: []string{"localhost:8080"}}, true},
+		{"origin with a path", Policy{AllowedOrigins: []string{"http://localhost:8080/app"}}, true},
+		{"origin with a trailing slash", Policy{AllowedOrigins: []string{"http://localhost:8080/"}}, true},
+		{"origin with a port", Policy{AllowedOrigins: []string{"http://localhost:8080"}}, false},
+		{"negative max age", Policy{AllowedOrigins: []string{"*"}, MaxAge: -time.Second}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.policy.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -1,95 +1,392 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"strconv"
 	"strings"
+	"time"
 
-	verifier "github.com/okta/okta-jwt-verifier-golang"
-	oktaUtils "github.com/okta/samples-golang/resource-server/utils"
+	"github.com/okta/samples-golang/resource-server/auth"
+	"github.com/okta/samples-golang/resource-server/cors"
+	"github.com/okta/samples-golang/resource-server/messages"
+	"github.com/okta/samples-golang/resource-server/problem"
+	"github.com/okta/samples-golang/utils/config"
 )
 
+// api serves /api/messages.
+type api struct {
+	// messages keeps the messages served by /api/messages.
+	messages messages.Repository
+	// verifier is shared by every request so signing keys stay cached.
+	verifier *auth.Verifier
+	// bearer are the ways, besides the Authorization header, clients may
+	// send their access token.
+	bearer auth.BearerOptions
+	// dpop checks the proofs of DPoP bound tokens, when enabled.
+	dpop *auth.DPoPValidator
+}
+
+// defaults are the settings used when neither the config file, the
+// environment nor the flags set them. By default only the front-end samples
+// on http://localhost:8080 may call the API.
+var defaults = config.Config{
+	ListenAddr: "localhost:8000",
+	BaseURL:    "http://localhost:8000",
+	Audience:   "api://default",
+	ResourceServer: config.ResourceServer{
+		MessagesDB: "messages.db",
+		CORS: config.CORS{
+			AllowedOrigins: []string{"http://localhost:8080"},
+			AllowedMethods: []string{"GET", "POST", "PUT", "DELETE"},
+			AllowedHeaders: []string{"Authorization", "Content-Type", "DPoP"},
+			MaxAge:         10 * time.Minute,
+		},
+	},
+}
+
 func main() {
-	oktaUtils.ParseEnvironment()
+	cfg, err := config.Load(defaults, os.Args[1:])
+	if err == nil {
+		err = cfg.Validate("CLIENT_ID", "SPA_CLIENT_ID", "ISSUER", "AUDIENCE")
+	}
+	if err != nil {
+		log.Print(err)
+		os.Exit(1)
+	}
+	rs := cfg.ResourceServer
+
+	a := &api{
+		bearer: auth.BearerOptions{
+			Form:  rs.BearerTokenInForm,
+			Query: rs.BearerTokenInQuery,
+			DPoP:  rs.DPoPEnabled,
+		},
+	}
+	if rs.DPoPEnabled {
+		a.dpop = auth.NewDPoPValidator(auth.DPoPConfig{BaseURL: cfg.BaseURL})
+	}
+
+	verifierConfig := auth.Config{
+		Issuer:      cfg.Issuer,
+		Audience:    cfg.Audience,
+		ClientID:    cfg.SPAClientID,
+		KeyCacheTTL: rs.JWKSCacheTTL,
+	}
+	// Introspection needs the resource server's own client credentials
+	if cfg.ClientSecret != "" {
+		verifierConfig.Introspection = &auth.IntrospectionConfig{
+			ClientID:     cfg.ClientID,
+			ClientSecret: cfg.ClientSecret,
+			Always:       rs.IntrospectAlways,
+			CacheTTL:     rs.IntrospectionCacheTTL,
+		}
+	}
+
+	// Resolving the issuer's metadata up front catches a mistyped ISSUER
+	// before the first request comes in.
+	a.verifier, err = auth.NewVerifier(context.Background(), verifierConfig)
+	if err != nil {
+		log.Printf("could not resolve the issuer metadata: %s", err)
+		os.Exit(1)
+	}
+
+	// The key cache metrics get a listener of their own, so they aren't
+	// served to every caller of the API.
+	if rs.MetricsAddr != "" {
+		go serveMetrics(rs.MetricsAddr, a.verifier)
+	}
+
+	store, err := messages.NewBoltRepository(rs.MessagesDB)
+	if err != nil {
+		log.Printf("could not open the message store: %s", err)
+		os.Exit(1)
+	}
+	defer store.Close()
+	a.messages = store
 
 	http.HandleFunc("/", HomeHandler)
-	http.HandleFunc("/api/messages", ApiMessagesHandler)
+	http.HandleFunc("/api/messages", a.ApiMessagesHandler)
+	http.HandleFunc("/api/messages/", a.ApiMessagesHandler)
+
+	corsPolicy := &cors.Policy{
+		AllowedOrigins:   rs.CORS.AllowedOrigins,
+		AllowedMethods:   rs.CORS.AllowedMethods,
+		AllowedHeaders:   rs.CORS.AllowedHeaders,
+		ExposedHeaders:   []string{"Location", "WWW-Authenticate"},
+		AllowCredentials: rs.CORS.AllowCredentials,
+		MaxAge:           rs.CORS.MaxAge,
+	}
+	if err := corsPolicy.Validate(); err != nil {
+		log.Printf("invalid configuration: %s", err)
+		os.Exit(1)
+	}
 
-	log.Print("server starting at localhost:8000 ... ")
-	err := http.ListenAndServe("localhost:8000", nil)
+	log.Printf("server starting at %s ... ", cfg.ListenAddr)
+	err = http.ListenAndServe(cfg.ListenAddr, corsPolicy.Handler(http.DefaultServeMux))
 	if err != nil {
 		log.Printf("the HTTP server failed to start: %s", err)
 		os.Exit(1)
 	}
 }
 
+// serveMetrics serves the signing key cache counters of verifier as JSON on
+// addr.
+func serveMetrics(addr string, verifier *auth.Verifier) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, verifier.KeyCacheStats())
+	})
+
+	log.Printf("metrics served at %s/metrics", addr)
+	if err := http.ListenAndServe(addr, mux); err != nil {
+		log.Printf("the metrics server failed to start: %s", err)
+	}
+}
+
 func HomeHandler(w http.ResponseWriter, r *http.Request) {
 	fmt.Fprintln(w, "Hello!  There's not much to see here :) Please grab one of our front-end samples for use with this sample resource server")
 }
 
-func ApiMessagesHandler(w http.ResponseWriter, r *http.Request) {
-	w.Header().Add("Access-Control-Allow-Origin", "*")
-	w.Header().Add("Access-Control-Allow-Headers", "Content-Type, authorization")
-	w.Header().Add("Access-Control-Allow-Methods", "GET, POST,OPTIONS")
+func (a *api) ApiMessagesHandler(w http.ResponseWriter, r *http.Request) {
+	// /api/messages is the collection, /api/messages/{id} a single message
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/messages"), "/")
+	allowed := map[string]bool{http.MethodGet: true, http.MethodPost: true}
+	allow := "GET, POST"
+	if id != "" {
+		allowed = map[string]bool{http.MethodGet: true, http.MethodPut: true, http.MethodDelete: true}
+		allow = "GET, PUT, DELETE"
+	}
+	if !allowed[r.Method] {
+		w.Header().Set("Allow", allow)
+		problem.Write(w, http.StatusMethodNotAllowed, r.Method+" is not supported on "+r.URL.Path)
+		return
+	}
 
-	if r.Method == "OPTIONS" {
+	scope := messagesScopes[r.Method]
+
+	token, err := a.authenticate(r)
+	if err != nil {
+		authError(w, err)
 		return
 	}
 
-	if !isAuthenticated(r) {
-		w.WriteHeader(http.StatusUnauthorized)
-		w.Write([]byte("401 - You are not authorized for this request"))
+	if !hasScope(token, scope) {
+		authError(w, auth.InsufficientScope(scope))
 		return
 	}
 
-	m1 := Message{1522272240, "I am a robot."}
-	m2 := Message{1522268640, "Hello, World!"}
-	allMessages := []Message{}
-	allMessages = append(allMessages, m1)
-	allMessages = append(allMessages, m2)
+	owner, _ := token.Claims["sub"].(string)
+	if owner == "" {
+		authError(w, auth.InvalidToken("The access token has no subject"))
+		return
+	}
 
-	mess := Messages{
-		allMessages,
+	switch {
+	case id == "" && r.Method == http.MethodGet:
+		a.listMessages(w, r, owner)
+	case id == "" && r.Method == http.MethodPost:
+		a.createMessage(w, r, owner)
+	case r.Method == http.MethodGet:
+		a.getMessage(w, r, owner, id)
+	case r.Method == http.MethodPut:
+		a.updateMessage(w, r, owner, id)
+	case r.Method == http.MethodDelete:
+		a.deleteMessage(w, r, owner, id)
 	}
+}
 
-	w.WriteHeader(http.StatusOK)
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(mess)
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+type Messages struct {
+	MessageList []messages.Message `json:"messages"`
+	NextCursor  string             `json:"next_cursor,omitempty"`
 }
 
-type Message struct {
-	Date float64 `json:"date"`
-	Text string  `json:"text"`
+// messageInput is the body accepted by POST and PUT.
+type messageInput struct {
+	Text string `json:"text"`
 }
 
-type Messages struct {
-	MessageList []Message `json:"messages"`
+func (a *api) listMessages(w http.ResponseWriter, r *http.Request, owner string) {
+	limit := defaultPageSize
+	if l := r.URL.Query().Get("limit"); l != "" {
+		n, err := strconv.Atoi(l)
+		if err != nil || n < 1 || n > maxPageSize {
+			problem.Write(w, http.StatusBadRequest, fmt.Sprintf("limit must be between 1 and %d", maxPageSize))
+			return
+		}
+		limit = n
+	}
+
+	list, next, err := a.messages.List(r.Context(), owner, limit, r.URL.Query().Get("cursor"))
+	if err != nil {
+		storeError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, Messages{MessageList: list, NextCursor: next})
 }
 
-func isAuthenticated(r *http.Request) bool {
-	authHeader := r.Header.Get("Authorization")
+func (a *api) createMessage(w http.ResponseWriter, r *http.Request, owner string) {
+	in, ok := readMessageInput(w, r)
+	if !ok {
+		return
+	}
 
-	if authHeader == "" {
-		return false
+	m := &messages.Message{
+		Owner: owner,
+		Date:  float64(time.Now().Unix()),
+		Text:  in.Text,
 	}
-	tokenParts := strings.Split(authHeader, "Bearer ")
-	bearerToken := tokenParts[1]
+	if err := a.messages.Create(r.Context(), m); err != nil {
+		storeError(w, err)
+		return
+	}
+
+	w.Header().Set("Location", "/api/messages/"+m.ID)
+	writeJSON(w, http.StatusCreated, m)
+}
 
-	tv := map[string]string{}
-	tv["aud"] = "api://default"
-	tv["cid"] = os.Getenv("SPA_CLIENT_ID")
-	jv := verifier.JwtVerifier{
-		Issuer:           os.Getenv("ISSUER"),
-		ClaimsToValidate: tv,
+func (a *api) getMessage(w http.ResponseWriter, r *http.Request, owner, id string) {
+	m, err := a.messages.Get(r.Context(), owner, id)
+	if err != nil {
+		storeError(w, err)
+		return
 	}
 
-	_, err := jv.New().VerifyAccessToken(bearerToken)
+	writeJSON(w, http.StatusOK, m)
+}
+
+func (a *api) updateMessage(w http.ResponseWriter, r *http.Request, owner, id string) {
+	in, ok := readMessageInput(w, r)
+	if !ok {
+		return
+	}
+
+	m := &messages.Message{
+		ID:    id,
+		Owner: owner,
+		Date:  float64(time.Now().Unix()),
+		Text:  in.Text,
+	}
+	if err := a.messages.Update(r.Context(), m); err != nil {
+		storeError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, m)
+}
+
+func (a *api) deleteMessage(w http.ResponseWriter, r *http.Request, owner, id string) {
+	if err := a.messages.Delete(r.Context(), owner, id); err != nil {
+		storeError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func readMessageInput(w http.ResponseWriter, r *http.Request) (messageInput, bool) {
+	var in messageInput
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&in); err != nil {
+		problem.Write(w, http.StatusBadRequest, "The body must be a JSON object with a text field")
+		return in, false
+	}
+	if strings.TrimSpace(in.Text) == "" {
+		problem.Write(w, http.StatusBadRequest, "text must not be empty")
+		return in, false
+	}
+	return in, true
+}
+
+func storeError(w http.ResponseWriter, err error) {
+	if errors.Is(err, messages.ErrNotFound) {
+		problem.Write(w, http.StatusNotFound, "Message not found")
+		return
+	}
+	log.Printf("message store error: %s", err)
+	problem.Write(w, http.StatusInternalServerError, "Something went wrong")
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// messagesScopes is the scope a token needs for each method on /api/messages.
+var messagesScopes = map[string]string{
+	http.MethodGet:    "messages:read",
+	http.MethodPost:   "messages:write",
+	http.MethodPut:    "messages:write",
+	http.MethodDelete: "messages:write",
+}
+
+// authenticate verifies the request's bearer token and returns it. Errors
+// are always an *auth.BearerError.
+func (a *api) authenticate(r *http.Request) (*auth.Token, error) {
+	accessToken, scheme, err := auth.AccessToken(r, a.bearer)
+	if err != nil {
+		return nil, err
+	}
+
+	// Changes can't wait for a revoked token's cached introspection result
+	// to expire.
+	verify := a.verifier.Verify
+	if r.Method != http.MethodGet {
+		verify = a.verifier.VerifyNotRevoked
+	}
+	token, err := verify(r.Context(), accessToken)
 	if err != nil {
-		return false
+		return nil, auth.InvalidToken(err.Error())
+	}
+
+	// A DPoP bound token is only accepted with a proof signed by the key it
+	// is bound to, otherwise a stolen token could be replayed as a bearer
+	// token.
+	jkt := token.ConfirmationThumbprint()
+	switch {
+	case scheme == "DPoP":
+		thumbprint, err := a.dpop.Validate(r, accessToken)
+		if err != nil {
+			return nil, err
+		}
+		if jkt == "" || thumbprint != jkt {
+			return nil, auth.InvalidToken("The access token is not bound to the DPoP proof key")
+		}
+	case jkt != "":
+		return nil, auth.InvalidToken("The access token is DPoP bound and must be sent with the DPoP scheme")
+	}
+	return token, nil
+}
+
+// authError responds to a failed authentication or authorization with the
+// RFC 6750 WWW-Authenticate challenge and a problem details body.
+func authError(w http.ResponseWriter, err error) {
+	var be *auth.BearerError
+	if !errors.As(err, &be) {
+		be = auth.InvalidToken(err.Error())
 	}
+	w.Header().Set("WWW-Authenticate", be.Challenge("api"))
+	problem.Write(w, be.Status, be.Description)
+}
 
-	return true
+// hasScope reports whether the token's scp claim contains scope.
+func hasScope(token *auth.Token, scope string) bool {
+	scp, _ := token.Claims["scp"].([]interface{})
+	for _, s := range scp {
+		if s == scope {
+			return true
+		}
+	}
+	return false
 }
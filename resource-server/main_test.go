@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/okta/samples-golang/resource-server/auth"
+	"github.com/okta/samples-golang/resource-server/messages"
+)
+
+// tokenScopes are the opaque access tokens the test authorization server
+// knows, with the scopes they grant.
+var tokenScopes = map[string]string{
+	"read-token":  "messages:read",
+	"write-token": "messages:write",
+	"both-token":  "messages:read messages:write",
+	"other-token": "openid profile",
+}
+
+// newTestAPI returns an api whose tokens are checked with the introspection
+// endpoint of a test authorization server.
+func newTestAPI(t *testing.T) *api {
+	t.Helper()
+	var issuer string
+	mux := http.NewServeMux()
+	mux.HandleFunc("/oauth2/default/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 issuer,
+			"jwks_uri":               issuer + "/v1/keys",
+			"introspection_endpoint": issuer + "/v1/introspect",
+		})
+	})
+	mux.HandleFunc("/oauth2/default/v1/introspect", func(w http.ResponseWriter, r *http.Request) {
+		scope, ok := tokenScopes[r.PostFormValue("token")]
+		if !ok {
+			json.NewEncoder(w).Encode(map[string]bool{"active": false})
+			return
+		}
+		now := time.Now().Unix()
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"active":    true,
+			"iss":       issuer,
+			"aud":       "api://default",
+			"client_id": "spa",
+			"sub":       "alice@example.com",
+			"scope":     scope,
+			"iat":       now,
+			"exp":       now + 3600,
+		})
+	})
+	ts := httptest.NewServer(mux)
+	t.Cleanup(ts.Close)
+	issuer = ts.URL + "/oauth2/default"
+
+	verifier, err := auth.NewVerifier(context.Background(), auth.Config{
+		Issuer:        issuer,
+		Audience:      "api://default",
+		ClientID:      "spa",
+		Introspection: &auth.IntrospectionConfig{ClientID: "api", ClientSecret: "secret"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := messages.NewBoltRepository(filepath.Join(t.TempDir(), "messages.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+
+	return &api{messages: store, verifier: verifier}
+}
+
+func (a *api) call(method, path, token, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	r.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	a.ApiMessagesHandler(w, r)
+	return w
+}
+
+func TestMessagesScopes(t *testing.T) {
+	a := newTestAPI(t)
+	w := a.call(http.MethodPost, "/api/messages", "both-token", `{"text": "hello"}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("creating a message answered %d: %s", w.Code, w.Body)
+	}
+	message := w.Header().Get("Location")
+
+	tests := []struct {
+		method     string
+		path       string
+		token      string
+		wantStatus int
+		wantScope  string
+	}{
+		{method: http.MethodGet, path: "/api/messages", token: "read-token", wantStatus: http.StatusOK},
+		{method: http.MethodGet, path: message, token: "read-token", wantStatus: http.StatusOK},
+		{method: http.MethodGet, path: "/api/messages", token: "write-token", wantStatus: http.StatusForbidden, wantScope: "messages:read"},
+		{method: http.MethodGet, path: message, token: "other-token", wantStatus: http.StatusForbidden, wantScope: "messages:read"},
+		{method: http.MethodPost, path: "/api/messages", token: "write-token", wantStatus: http.StatusCreated},
+		{method: http.MethodPost, path: "/api/messages", token: "read-token", wantStatus: http.StatusForbidden, wantScope: "messages:write"},
+		{method: http.MethodPut, path: message, token: "read-token", wantStatus: http.StatusForbidden, wantScope: "messages:write"},
+		{method: http.MethodDelete, path: message, token: "read-token", wantStatus: http.StatusForbidden, wantScope: "messages:write"},
+		{method: http.MethodPut, path: message, token: "write-token", wantStatus: http.StatusOK},
+		{method: http.MethodDelete, path: message, token: "write-token", wantStatus: http.StatusNoContent},
+		{method: http.MethodGet, path: "/api/messages", token: "unknown-token", wantStatus: http.StatusUnauthorized},
+		{method: http.MethodGet, path: "/api/messages", wantStatus: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.path+" "+tt.token, func(t *testing.T) {
+			w := a.call(tt.method, tt.path, tt.token, `{"text": "changed"}`)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("answered %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+			challenge := w.Header().Get("WWW-Authenticate")
+			if tt.wantScope != "" && (!strings.Contains(challenge, `error="insufficient_scope"`) || !strings.Contains(challenge, `scope="`+tt.wantScope+`"`)) {
+				t.Errorf("WWW-Authenticate = %q, want insufficient_scope for %s", challenge, tt.wantScope)
+			}
+		})
+	}
+}
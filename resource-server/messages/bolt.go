@@ -0,0 +1,139 @@
+package messages
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var messagesBucket = []byte("messages")
+
+// BoltRepository is a Repository kept in a BoltDB file. Each owner gets a
+// nested bucket keyed by message ID, IDs are zero padded sequence numbers so
+// keys sort in creation order.
+type BoltRepository struct {
+	db *bolt.DB
+}
+
+// NewBoltRepository opens, or creates, the database at path.
+func NewBoltRepository(path string) (*BoltRepository, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("opening message store %q: %w", path, err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(messagesBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("creating message bucket: %w", err)
+	}
+	return &BoltRepository{db: db}, nil
+}
+
+func (br *BoltRepository) Close() error {
+	return br.db.Close()
+}
+
+func (br *BoltRepository) Create(_ context.Context, m *Message) error {
+	return br.db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.Bucket(messagesBucket).CreateBucketIfNotExists([]byte(m.Owner))
+		if err != nil {
+			return err
+		}
+		seq, err := b.NextSequence()
+		if err != nil {
+			return err
+		}
+		m.ID = fmt.Sprintf("%020d", seq)
+		return put(b, m)
+	})
+}
+
+func (br *BoltRepository) Get(_ context.Context, owner, id string) (*Message, error) {
+	var m Message
+	err := br.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(messagesBucket).Bucket([]byte(owner))
+		if b == nil {
+			return ErrNotFound
+		}
+		v := b.Get([]byte(id))
+		if v == nil {
+			return ErrNotFound
+		}
+		return json.Unmarshal(v, &m)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+func (br *BoltRepository) Update(_ context.Context, m *Message) error {
+	return br.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(messagesBucket).Bucket([]byte(m.Owner))
+		if b == nil || b.Get([]byte(m.ID)) == nil {
+			return ErrNotFound
+		}
+		return put(b, m)
+	})
+}
+
+func (br *BoltRepository) Delete(_ context.Context, owner, id string) error {
+	return br.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(messagesBucket).Bucket([]byte(owner))
+		if b == nil || b.Get([]byte(id)) == nil {
+			return ErrNotFound
+		}
+		return b.Delete([]byte(id))
+	})
+}
+
+func (br *BoltRepository) List(_ context.Context, owner string, limit int, cursor string) ([]Message, string, error) {
+	list := []Message{}
+	next := ""
+	err := br.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(messagesBucket).Bucket([]byte(owner))
+		if b == nil {
+			return nil
+		}
+
+		c := b.Cursor()
+		k, v := c.First()
+		if cursor != "" {
+			k, v = c.Seek([]byte(cursor))
+			if k != nil && string(k) == cursor {
+				k, v = c.Next()
+			}
+		}
+
+		for ; k != nil; k, v = c.Next() {
+			if len(list) == limit {
+				next = list[len(list)-1].ID
+				break
+			}
+			var m Message
+			if err := json.Unmarshal(v, &m); err != nil {
+				return err
+			}
+			list = append(list, m)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	return list, next, nil
+}
+
+func put(b *bolt.Bucket, m *Message) error {
+	v, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return b.Put([]byte(m.ID), v)
+}
@@ -0,0 +1,147 @@
+package messages
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func newTestRepository(t *testing.T) *BoltRepository {
+	t.Helper()
+	br, err := NewBoltRepository(filepath.Join(t.TempDir(), "messages.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { br.Close() })
+	return br
+}
+
+func TestBoltRepositoryCRUD(t *testing.T) {
+	ctx := context.Background()
+	br := newTestRepository(t)
+
+	m := &Message{Owner: "alice", Date: 1, Text: "hello"}
+	if err := br.Create(ctx, m); err != nil {
+		t.Fatal(err)
+	}
+	if m.ID == "" {
+		t.Fatal("Create did not assign an ID")
+	}
+
+	got, err := br.Get(ctx, "alice", m.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *got != *m {
+		t.Errorf("Get = %+v, want %+v", got, m)
+	}
+
+	m.Text = "hello again"
+	if err := br.Update(ctx, m); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := br.Get(ctx, "alice", m.ID); got.Text != "hello again" {
+		t.Errorf("Get after Update = %q, want %q", got.Text, "hello again")
+	}
+
+	if err := br.Delete(ctx, "alice", m.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := br.Get(ctx, "alice", m.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get after Delete = %v, want ErrNotFound", err)
+	}
+}
+
+func TestBoltRepositoryScopesByOwner(t *testing.T) {
+	ctx := context.Background()
+	br := newTestRepository(t)
+
+	m := &Message{Owner: "alice", Text: "private"}
+	if err := br.Create(ctx, m); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		call func() error
+	}{
+		{"get", func() error {
+			_, err := br.Get(ctx, "bob", m.ID)
+			return err
+		}},
+		{"update", func() error {
+			return br.Update(ctx, &Message{ID: m.ID, Owner: "bob", Text: "mine now"})
+		}},
+		{"delete", func() error {
+			return br.Delete(ctx, "bob", m.ID)
+		}},
+		{"missing id", func() error {
+			_, err := br.Get(ctx, "alice", "does-not-exist")
+			return err
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.call(); !errors.Is(err, ErrNotFound) {
+				t.Errorf("got %v, want ErrNotFound", err)
+			}
+		})
+	}
+
+	list, _, err := br.List(ctx, "bob", 10, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 0 {
+		t.Errorf("List for another owner = %v, want none", list)
+	}
+	if got, _ := br.Get(ctx, "alice", m.ID); got.Text != "private" {
+		t.Errorf("message was changed by another owner: %+v", got)
+	}
+}
+
+func TestBoltRepositoryListPages(t *testing.T) {
+	ctx := context.Background()
+	br := newTestRepository(t)
+
+	var ids []string
+	for _, text := range []string{"one", "two", "three", "four", "five"} {
+		m := &Message{Owner: "alice", Text: text}
+		if err := br.Create(ctx, m); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, m.ID)
+	}
+
+	var got []string
+	cursor := ""
+	for pages := 0; ; pages++ {
+		if pages > len(ids) {
+			t.Fatal("List never returned an empty cursor")
+		}
+		list, next, err := br.List(ctx, "alice", 2, cursor)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(list) > 2 {
+			t.Fatalf("List returned %d messages, more than the limit", len(list))
+		}
+		for _, m := range list {
+			got = append(got, m.ID)
+		}
+		if next == "" {
+			break
+		}
+		cursor = next
+	}
+
+	if len(got) != len(ids) {
+		t.Fatalf("paged through %v, want %v", got, ids)
+	}
+	for i := range ids {
+		if got[i] != ids[i] {
+			t.Fatalf("paged through %v, want %v in creation order", got, ids)
+		}
+	}
+}
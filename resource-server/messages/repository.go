@@ -0,0 +1,34 @@
+package messages
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrNotFound is returned when a message doesn't exist or belongs to
+// someone else.
+var ErrNotFound = errors.New("message not found")
+
+// Message is a message owned by the subject (sub) of the access token that
+// created it.
+type Message struct {
+	ID    string  `json:"id"`
+	Owner string  `json:"owner"`
+	Date  float64 `json:"date"`
+	Text  string  `json:"text"`
+}
+
+// Repository stores messages. Every call is scoped to an owner, a caller can
+// never read or change another owner's messages.
+type Repository interface {
+	// Create stores m, assigning its ID.
+	Create(ctx context.Context, m *Message) error
+	Get(ctx context.Context, owner, id string) (*Message, error)
+	// Update replaces the text and date of the message with m's ID.
+	Update(ctx context.Context, m *Message) error
+	Delete(ctx context.Context, owner, id string) error
+	// List returns up to limit of owner's messages, oldest first, starting
+	// after cursor. The returned cursor is empty when there are no more
+	// messages.
+	List(ctx context.Context, owner string, limit int, cursor string) ([]Message, string, error)
+}
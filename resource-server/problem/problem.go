@@ -0,0 +1,27 @@
+// Package problem writes RFC 7807 problem details error responses.
+package problem
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// Details is the body of an application/problem+json response.
+type Details struct {
+	Type   string `json:"type"`
+	Title  string `json:"title"`
+	Status int    `json:"status"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// Write responds with status and a problem details body describing it.
+func Write(w http.ResponseWriter, status int, detail string) {
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(Details{
+		Type:   "about:blank",
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: detail,
+	})
+}
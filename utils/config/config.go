@@ -0,0 +1,408 @@
+/**
+ * Copyright 2021 - Present Okta, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package config loads the settings of the custom-login, okta-hosted-login
+// and resource-server samples.
+//
+// Settings come from, in increasing order of precedence, the defaults of the
+// sample, a YAML file given with -config, the environment (including the
+// .env file given with -env) and command line flags.
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/okta/samples-golang/utils/dotenv"
+)
+
+// Config holds the settings shared by the classic samples. Fields a sample
+// doesn't use are left empty.
+type Config struct {
+	ClientID     string `yaml:"client_id"`
+	ClientSecret string `yaml:"client_secret"`
+	Issuer       string `yaml:"issuer"`
+	// SPAClientID and Audience are the cid and aud the resource server
+	// expects in access tokens.
+	SPAClientID string `yaml:"spa_client_id"`
+	Audience    string `yaml:"audience"`
+
+	// ListenAddr is the host:port the server listens on.
+	ListenAddr string `yaml:"listen_addr"`
+	// BaseURL is the scheme and host browsers use to reach the sample.
+	BaseURL string `yaml:"base_url"`
+	// RedirectPath is where Okta sends the browser back after login.
+	RedirectPath          string   `yaml:"redirect_path"`
+	PostLogoutRedirectURI string   `yaml:"post_logout_redirect_uri"`
+	Scopes                []string `yaml:"scopes"`
+
+	// SessionSecret authenticates and encrypts session cookies.
+	SessionSecret string  `yaml:"session_secret"`
+	Session       Session `yaml:"session"`
+	Cookie        Cookie  `yaml:"cookie"`
+
+	ResourceServer ResourceServer `yaml:"resource_server"`
+}
+
+// Session holds the settings of the session store.
+type Session struct {
+	// Store is where session values are kept: "cookie" keeps them in an
+	// encrypted cookie, "memory" and "file" keep them on the server and only
+	// put the session ID in the cookie.
+	Store string `yaml:"store"`
+	// Dir is the directory of the "file" store.
+	Dir string `yaml:"dir"`
+	// PreviousSecrets are former session secrets, still accepted so that
+	// rotating SessionSecret doesn't sign everybody out.
+	PreviousSecrets []string `yaml:"previous_secrets"`
+	// IdleTimeout ends sessions that haven't been used for that long, and
+	// AbsoluteTimeout ends them that long after login. Zero disables them.
+	IdleTimeout     time.Duration `yaml:"idle_timeout"`
+	AbsoluteTimeout time.Duration `yaml:"absolute_timeout"`
+}
+
+// Cookie holds the settings of the session cookie.
+type Cookie struct {
+	Name     string `yaml:"name"`
+	Path     string `yaml:"path"`
+	Domain   string `yaml:"domain"`
+	MaxAge   int    `yaml:"max_age"`
+	Secure   bool   `yaml:"secure"`
+	SameSite string `yaml:"same_site"`
+}
+
+// ResourceServer holds the settings only the resource-server sample uses.
+type ResourceServer struct {
+	// MessagesDB is the path of the BoltDB file the messages are kept in.
+	MessagesDB string `yaml:"messages_db"`
+	// MetricsAddr, if set, is the host:port the signing key cache metrics
+	// are served on.
+	MetricsAddr string `yaml:"metrics_addr"`
+	// BearerTokenInForm and BearerTokenInQuery accept an access_token
+	// parameter in form bodies and query strings, besides the header.
+	BearerTokenInForm  bool `yaml:"bearer_token_in_form"`
+	BearerTokenInQuery bool `yaml:"bearer_token_in_query"`
+	DPoPEnabled        bool `yaml:"dpop_enabled"`
+	// JWKSCacheTTL is how long the issuer's signing keys are cached, zero
+	// keeps the verifier's default.
+	JWKSCacheTTL time.Duration `yaml:"jwks_cache_ttl"`
+	// IntrospectAlways introspects JWTs too, it needs the ClientSecret.
+	IntrospectAlways bool `yaml:"introspect_always"`
+	// IntrospectionCacheTTL caps how long introspection results are reused,
+	// a negative value turns caching off.
+	IntrospectionCacheTTL time.Duration `yaml:"introspection_cache_ttl"`
+	CORS                  CORS          `yaml:"cors"`
+}
+
+// CORS holds the cross-origin policy of the resource server.
+type CORS struct {
+	// AllowedOrigins are the scheme and host of the front-ends allowed to
+	// call the API, or "*" for any.
+	AllowedOrigins   []string      `yaml:"allowed_origins"`
+	AllowedMethods   []string      `yaml:"allowed_methods"`
+	AllowedHeaders   []string      `yaml:"allowed_headers"`
+	AllowCredentials bool          `yaml:"allow_credentials"`
+	MaxAge           time.Duration `yaml:"max_age"`
+}
+
+// RedirectURI is the absolute redirect_uri sent to Okta.
+func (c *Config) RedirectURI() string {
+	return c.BaseURL + c.RedirectPath
+}
+
+// SameSiteMode returns the cookie's SameSite setting as an http.SameSite.
+func (c *Cookie) SameSiteMode() http.SameSite {
+	switch strings.ToLower(c.SameSite) {
+	case "strict":
+		return http.SameSiteStrictMode
+	case "none":
+		return http.SameSiteNoneMode
+	default:
+		return http.SameSiteLaxMode
+	}
+}
+
+// Load builds the configuration from defaults, then the YAML file, the
+// environment and the command line args, usually os.Args[1:].
+func Load(defaults Config, args []string) (*Config, error) {
+	flags := flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	configFile := flags.String("config", "", "path to a YAML configuration file")
+	envFile := flags.String("env", ".env", "path to the .env file")
+	listenAddr := flags.String("listen", "", "host:port to listen on")
+	baseURL := flags.String("base-url", "", "scheme and host browsers use to reach the server")
+	if err := flags.Parse(args); err != nil {
+		return nil, err
+	}
+
+	c := defaults
+	if *configFile != "" {
+		if err := c.loadYAML(*configFile); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := dotenv.Load(*envFile); errors.Is(err, fs.ErrNotExist) {
+		log.Printf("Environment Variable file (%s) is not present.  Relying on Global Environment Variables", *envFile)
+	} else if err != nil {
+		return nil, err
+	}
+	if err := c.loadEnv(); err != nil {
+		return nil, err
+	}
+
+	if *listenAddr != "" {
+		c.ListenAddr = *listenAddr
+	}
+	if *baseURL != "" {
+		c.BaseURL = *baseURL
+	}
+
+	c.BaseURL = strings.TrimSuffix(c.BaseURL, "/")
+	// Browsers send origins without a trailing slash
+	var origins []string
+	for _, origin := range c.ResourceServer.CORS.AllowedOrigins {
+		origins = append(origins, strings.TrimSuffix(origin, "/"))
+	}
+	c.ResourceServer.CORS.AllowedOrigins = origins
+	if c.PostLogoutRedirectURI == "" {
+		c.PostLogoutRedirectURI = c.BaseURL + "/"
+	}
+	return &c, nil
+}
+
+func (c *Config) loadYAML(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	// Unknown keys are most likely typos, report them rather than
+	// silently running with the default.
+	dec := yaml.NewDecoder(f)
+	dec.KnownFields(true)
+	if err := dec.Decode(c); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return nil
+}
+
+func (c *Config) loadEnv() error {
+	fields := map[string]*string{
+		"CLIENT_ID":                &c.ClientID,
+		"CLIENT_SECRET":            &c.ClientSecret,
+		"ISSUER":                   &c.Issuer,
+		"SPA_CLIENT_ID":            &c.SPAClientID,
+		"AUDIENCE":                 &c.Audience,
+		"LISTEN_ADDR":              &c.ListenAddr,
+		"BASE_URL":                 &c.BaseURL,
+		"REDIRECT_PATH":            &c.RedirectPath,
+		"POST_LOGOUT_REDIRECT_URI": &c.PostLogoutRedirectURI,
+		"SESSION_SECRET":           &c.SessionSecret,
+		"COOKIE_NAME":              &c.Cookie.Name,
+		"COOKIE_PATH":              &c.Cookie.Path,
+		"COOKIE_DOMAIN":            &c.Cookie.Domain,
+		"COOKIE_SAME_SITE":         &c.Cookie.SameSite,
+		"SESSION_STORE":            &c.Session.Store,
+		"SESSION_DIR":              &c.Session.Dir,
+		"MESSAGES_DB":              &c.ResourceServer.MessagesDB,
+		"METRICS_ADDR":             &c.ResourceServer.MetricsAddr,
+	}
+	for env, field := range fields {
+		if v := os.Getenv(env); v != "" {
+			*field = v
+		}
+	}
+
+	lists := map[string]*[]string{
+		"SCOPES":                   &c.Scopes,
+		"SESSION_PREVIOUS_SECRETS": &c.Session.PreviousSecrets,
+		"CORS_ALLOWED_ORIGINS":     &c.ResourceServer.CORS.AllowedOrigins,
+		"CORS_ALLOWED_METHODS":     &c.ResourceServer.CORS.AllowedMethods,
+		"CORS_ALLOWED_HEADERS":     &c.ResourceServer.CORS.AllowedHeaders,
+	}
+	for env, field := range lists {
+		if v := os.Getenv(env); v != "" {
+			*field = fieldsFunc(v)
+		}
+	}
+
+	durations := map[string]*time.Duration{
+		"SESSION_IDLE_TIMEOUT":     &c.Session.IdleTimeout,
+		"SESSION_ABSOLUTE_TIMEOUT": &c.Session.AbsoluteTimeout,
+		"JWKS_CACHE_TTL":           &c.ResourceServer.JWKSCacheTTL,
+		"INTROSPECTION_CACHE_TTL":  &c.ResourceServer.IntrospectionCacheTTL,
+		"CORS_MAX_AGE":             &c.ResourceServer.CORS.MaxAge,
+	}
+	for env, field := range durations {
+		if v := os.Getenv(env); v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil {
+				return fmt.Errorf("%s: %w", env, err)
+			}
+			*field = d
+		}
+	}
+
+	bools := map[string]*bool{
+		"COOKIE_SECURE":          &c.Cookie.Secure,
+		"BEARER_TOKEN_IN_FORM":   &c.ResourceServer.BearerTokenInForm,
+		"BEARER_TOKEN_IN_QUERY":  &c.ResourceServer.BearerTokenInQuery,
+		"DPOP_ENABLED":           &c.ResourceServer.DPoPEnabled,
+		"INTROSPECT_ALWAYS":      &c.ResourceServer.IntrospectAlways,
+		"CORS_ALLOW_CREDENTIALS": &c.ResourceServer.CORS.AllowCredentials,
+	}
+	for env, field := range bools {
+		if v := os.Getenv(env); v != "" {
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				return fmt.Errorf("%s: %w", env, err)
+			}
+			*field = b
+		}
+	}
+
+	if v := os.Getenv("COOKIE_MAX_AGE"); v != "" {
+		maxAge, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("COOKIE_MAX_AGE: %w", err)
+		}
+		c.Cookie.MaxAge = maxAge
+	}
+	return nil
+}
+
+// Validate checks the configuration is usable. required lists the settings,
+// by environment variable name, the sample can't run without.
+func (c *Config) Validate(required ...string) error {
+	values := map[string]string{
+		"CLIENT_ID":      c.ClientID,
+		"CLIENT_SECRET":  c.ClientSecret,
+		"ISSUER":         c.Issuer,
+		"SPA_CLIENT_ID":  c.SPAClientID,
+		"AUDIENCE":       c.Audience,
+		"LISTEN_ADDR":    c.ListenAddr,
+		"BASE_URL":       c.BaseURL,
+		"REDIRECT_PATH":  c.RedirectPath,
+		"SESSION_SECRET": c.SessionSecret,
+	}
+
+	var problems []string
+	for _, env := range required {
+		if values[env] == "" {
+			problems = append(problems, fmt.Sprintf("could not resolve a %s setting", env))
+		}
+	}
+
+	if c.Issuer != "" {
+		if err := checkURL(c.Issuer); err != nil {
+			problems = append(problems, "ISSUER "+err.Error())
+		}
+	}
+	if c.BaseURL != "" {
+		if err := checkURL(c.BaseURL); err != nil {
+			problems = append(problems, "BASE_URL "+err.Error())
+		}
+	}
+	if c.RedirectPath != "" && !strings.HasPrefix(c.RedirectPath, "/") {
+		problems = append(problems, "REDIRECT_PATH must start with /")
+	}
+	if c.SessionSecret != "" && len(c.SessionSecret) < 32 {
+		problems = append(problems, "SESSION_SECRET must be at least 32 characters")
+	}
+	for _, secret := range c.Session.PreviousSecrets {
+		if len(secret) < 32 {
+			problems = append(problems, "SESSION_PREVIOUS_SECRETS must be at least 32 characters each")
+			break
+		}
+	}
+	if len(c.Session.PreviousSecrets) > 0 && c.SessionSecret == "" {
+		problems = append(problems, "SESSION_PREVIOUS_SECRETS needs a SESSION_SECRET")
+	}
+	switch c.Session.Store {
+	case "", "cookie", "memory":
+	case "file":
+		if c.Session.Dir == "" {
+			problems = append(problems, "SESSION_STORE=file needs a SESSION_DIR")
+		}
+	default:
+		problems = append(problems, fmt.Sprintf("SESSION_STORE %q must be cookie, memory or file", c.Session.Store))
+	}
+	if c.Session.IdleTimeout < 0 || c.Session.AbsoluteTimeout < 0 {
+		problems = append(problems, "session timeouts can't be negative")
+	}
+	switch strings.ToLower(c.Cookie.SameSite) {
+	case "", "lax", "strict":
+	case "none":
+		if !c.Cookie.Secure {
+			problems = append(problems, "COOKIE_SAME_SITE=none needs COOKIE_SECURE=true")
+		}
+	default:
+		problems = append(problems, fmt.Sprintf("COOKIE_SAME_SITE %q must be lax, strict or none", c.Cookie.SameSite))
+	}
+	problems = append(problems, c.ResourceServer.validate(c.ClientSecret)...)
+
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid configuration: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+func (rs *ResourceServer) validate(clientSecret string) []string {
+	var problems []string
+	if rs.IntrospectAlways && clientSecret == "" {
+		problems = append(problems, "INTROSPECT_ALWAYS needs the CLIENT_SECRET of the resource server")
+	}
+	if rs.JWKSCacheTTL < 0 {
+		problems = append(problems, "JWKS_CACHE_TTL can't be negative")
+	}
+	if rs.MetricsAddr != "" {
+		if _, _, err := net.SplitHostPort(rs.MetricsAddr); err != nil {
+			problems = append(problems, fmt.Sprintf("METRICS_ADDR %q must be a host:port", rs.MetricsAddr))
+		}
+	}
+	return problems
+}
+
+func checkURL(s string) error {
+	u, err := url.Parse(s)
+	if err != nil {
+		return fmt.Errorf("is not a URL: %s", err)
+	}
+	if (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return fmt.Errorf("%q must be an absolute http(s) URL", s)
+	}
+	return nil
+}
+
+// fieldsFunc splits a space or comma separated list.
+func fieldsFunc(s string) []string {
+	return strings.FieldsFunc(s, func(r rune) bool {
+		return r == ' ' || r == ','
+	})
+}
@@ -0,0 +1,173 @@
+/**
+ * Copyright 2021 - Present Okta, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// load runs Load without a .env file.
+func load(t *testing.T, defaults Config, args ...string) (*Config, error) {
+	t.Helper()
+	return Load(defaults, append([]string{"-env", filepath.Join(t.TempDir(), ".env")}, args...))
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	yamlFile := filepath.Join(t.TempDir(), "config.yaml")
+	yaml := `
+issuer: https://yaml.example.com/oauth2/default
+audience: api://yaml
+resource_server:
+  messages_db: yaml.db
+  jwks_cache_ttl: 5m
+  cors:
+    allowed_origins: [https://spa.example.com/]
+`
+	if err := os.WriteFile(yamlFile, []byte(yaml), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("AUDIENCE", "api://env")
+	t.Setenv("LISTEN_ADDR", "localhost:1")
+	t.Setenv("DPOP_ENABLED", "true")
+	t.Setenv("CORS_ALLOWED_METHODS", "GET, POST")
+
+	defaults := Config{
+		ListenAddr: "localhost:8000",
+		ResourceServer: ResourceServer{
+			MessagesDB: "messages.db",
+			CORS:       CORS{AllowedOrigins: []string{"http://localhost:8080"}},
+		},
+	}
+	c, err := load(t, defaults, "-config", yamlFile, "-listen", "localhost:2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checks := []struct {
+		name      string
+		got, want interface{}
+	}{
+		{"yaml over defaults", c.ResourceServer.MessagesDB, "yaml.db"},
+		{"yaml only", c.Issuer, "https://yaml.example.com/oauth2/default"},
+		{"yaml duration", c.ResourceServer.JWKSCacheTTL, 5 * time.Minute},
+		{"origin without trailing slash", c.ResourceServer.CORS.AllowedOrigins, []string{"https://spa.example.com"}},
+		{"environment over yaml", c.Audience, "api://env"},
+		{"environment bool", c.ResourceServer.DPoPEnabled, true},
+		{"environment list", c.ResourceServer.CORS.AllowedMethods, []string{"GET", "POST"}},
+		{"flag over environment", c.ListenAddr, "localhost:2"},
+	}
+	for _, check := range checks {
+		if !reflect.DeepEqual(check.got, check.want) {
+			t.Errorf("%s: got %v, want %v", check.name, check.got, check.want)
+		}
+	}
+	if defaults.ResourceServer.CORS.AllowedOrigins[0] != "http://localhost:8080" {
+		t.Error("Load changed the defaults")
+	}
+}
+
+func TestLoadUnknownYAMLKey(t *testing.T) {
+	yamlFile := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(yamlFile, []byte("resource_server:\n  messages_bd: typo.db\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := load(t, Config{}, "-config", yamlFile); err == nil {
+		t.Error("Load() accepted an unknown key")
+	}
+}
+
+func TestLoadEnvErrors(t *testing.T) {
+	tests := []struct {
+		env, value string
+	}{
+		{"COOKIE_SECURE", "yes please"},
+		{"COOKIE_MAX_AGE", "a day"},
+		{"SESSION_IDLE_TIMEOUT", "10"},
+		{"DPOP_ENABLED", "on"},
+		{"BEARER_TOKEN_IN_QUERY", "maybe"},
+		{"JWKS_CACHE_TTL", "an hour"},
+		{"INTROSPECTION_CACHE_TTL", "30"},
+		{"CORS_MAX_AGE", "10 minutes"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.env, func(t *testing.T) {
+			t.Setenv(tt.env, tt.value)
+			_, err := load(t, Config{})
+			if err == nil || !strings.HasPrefix(err.Error(), tt.env+":") {
+				t.Errorf("Load() = %v, want an error about %s", err, tt.env)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	valid := func() Config {
+		return Config{
+			ClientID: "client",
+			Issuer:   "https://example.okta.com/oauth2/default",
+			BaseURL:  "http://localhost:8000",
+			ResourceServer: ResourceServer{
+				CORS: CORS{AllowedOrigins: []string{"http://localhost:8080"}},
+			},
+		}
+	}
+
+	tests := []struct {
+		name    string
+		change  func(c *Config)
+		wantErr string
+	}{
+		{"valid", func(c *Config) {}, ""},
+		{"missing required", func(c *Config) { c.ClientID = "" }, "CLIENT_ID"},
+		{"relative issuer", func(c *Config) { c.Issuer = "/oauth2/default" }, "ISSUER"},
+		{"redirect path", func(c *Config) { c.RedirectPath = "callback" }, "REDIRECT_PATH"},
+		{"short session secret", func(c *Config) { c.SessionSecret = "short" }, "SESSION_SECRET"},
+		{"file store without dir", func(c *Config) { c.Session.Store = "file" }, "SESSION_DIR"},
+		{"unknown store", func(c *Config) { c.Session.Store = "redis" }, "SESSION_STORE"},
+		{"negative timeout", func(c *Config) { c.Session.IdleTimeout = -time.Minute }, "timeouts"},
+		{"insecure SameSite=none", func(c *Config) { c.Cookie.SameSite = "none" }, "COOKIE_SECURE"},
+		{"introspect always without secret", func(c *Config) { c.ResourceServer.IntrospectAlways = true }, "INTROSPECT_ALWAYS"},
+		{"introspect always with secret", func(c *Config) {
+			c.ResourceServer.IntrospectAlways = true
+			c.ClientSecret = "secret"
+		}, ""},
+		{"introspection cache off", func(c *Config) { c.ResourceServer.IntrospectionCacheTTL = -1 }, ""},
+		{"negative key cache TTL", func(c *Config) { c.ResourceServer.JWKSCacheTTL = -time.Minute }, "JWKS_CACHE_TTL"},
+		{"metrics address without port", func(c *Config) { c.ResourceServer.MetricsAddr = "localhost" }, "METRICS_ADDR"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := valid()
+			tt.change(&c)
+			err := c.Validate("CLIENT_ID", "ISSUER")
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("Validate() = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Validate() = %v, want an error about %s", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,297 @@
+/**
+ * Copyright 2021 - Present Okta, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package dotenv loads .env files into the environment.
+//
+// Each line holds a KEY=value pair, optionally prefixed with "export ". Blank
+// lines and lines starting with # are ignored. Values may be:
+//
+//	KEY=unquoted value # trailing comments are dropped
+//	KEY='single quoted, taken literally'
+//	KEY="double quoted, with \n \t \r \" \\ \$ escapes, may span lines"
+//
+// ${VAR} in unquoted and double quoted values is replaced with the value of
+// VAR from the environment or, failing that, from earlier in the file. That
+// is the value Load leaves VAR with, since variables already set in the
+// environment win over the file.
+package dotenv
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// ParseError reports a malformed line.
+type ParseError struct {
+	Path string
+	Line int
+	Msg  string
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("%s:%d: %s", e.Path, e.Line, e.Msg)
+}
+
+// Load reads the file at path and sets each variable in it that isn't already
+// set in the environment, so real environment variables take precedence.
+func Load(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	vars, err := parse(path, f)
+	if err != nil {
+		return err
+	}
+
+	for _, v := range vars {
+		if current, ok := os.LookupEnv(v.key); ok && current != "" {
+			continue
+		}
+		if err := os.Setenv(v.key, v.value); err != nil {
+			return fmt.Errorf("%s: setting %s: %w", path, v.key, err)
+		}
+	}
+	return nil
+}
+
+// Parse returns the variables defined in r.
+func Parse(r io.Reader) (map[string]string, error) {
+	vars, err := parse(".env", r)
+	if err != nil {
+		return nil, err
+	}
+	m := make(map[string]string, len(vars))
+	for _, v := range vars {
+		m[v.key] = v.value
+	}
+	return m, nil
+}
+
+type variable struct {
+	key   string
+	value string
+}
+
+type parser struct {
+	path    string
+	scanner *bufio.Scanner
+	line    int
+	vars    []variable
+	defined map[string]string
+}
+
+func parse(path string, r io.Reader) ([]variable, error) {
+	p := &parser{
+		path:    path,
+		scanner: bufio.NewScanner(r),
+		defined: map[string]string{},
+	}
+
+	for p.scanner.Scan() {
+		p.line++
+		if err := p.parseLine(p.scanner.Text()); err != nil {
+			return nil, err
+		}
+	}
+	if err := p.scanner.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return p.vars, nil
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	return &ParseError{Path: p.path, Line: p.line, Msg: fmt.Sprintf(format, args...)}
+}
+
+func (p *parser) parseLine(line string) error {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return nil
+	}
+	if rest := strings.TrimPrefix(line, "export"); rest != line && (rest == "" || rest[0] == ' ' || rest[0] == '\t') {
+		line = strings.TrimSpace(rest)
+	}
+
+	eq := strings.IndexByte(line, '=')
+	if eq < 0 {
+		return p.errorf("expected KEY=value, got %q", line)
+	}
+	key := strings.TrimSpace(line[:eq])
+	if !validKey(key) {
+		return p.errorf("invalid variable name %q", key)
+	}
+
+	value, err := p.parseValue(line[eq+1:])
+	if err != nil {
+		return err
+	}
+
+	p.vars = append(p.vars, variable{key: key, value: value})
+	p.defined[key] = value
+	return nil
+}
+
+func (p *parser) parseValue(raw string) (string, error) {
+	value := strings.TrimSpace(raw)
+	switch {
+	case strings.HasPrefix(value, "'"):
+		end := strings.IndexByte(value[1:], '\'')
+		if end < 0 {
+			return "", p.errorf("unterminated single quoted value")
+		}
+		if err := p.checkTrailing(value[end+2:]); err != nil {
+			return "", err
+		}
+		return value[1 : end+1], nil
+
+	case strings.HasPrefix(value, `"`):
+		return p.parseDoubleQuoted(value[1:])
+
+	default:
+		// The comment is looked for before trimming, so that the # of
+		// "KEY= # comment" isn't taken for the start of the value.
+		if i := commentStart(raw); i >= 0 {
+			raw = raw[:i]
+		}
+		return p.interpolate(strings.TrimSpace(raw))
+	}
+}
+
+// commentStart returns the index of the # starting a trailing comment in an
+// unquoted value, or -1. A # only starts a comment after whitespace, so
+// values like KEY=a#b keep it.
+func commentStart(raw string) int {
+	for i := 1; i < len(raw); i++ {
+		if raw[i] == '#' && (raw[i-1] == ' ' || raw[i-1] == '\t') {
+			return i
+		}
+	}
+	return -1
+}
+
+// parseDoubleQuoted reads a double quoted value, pulling in more lines until
+// the closing quote.
+func (p *parser) parseDoubleQuoted(raw string) (string, error) {
+	var b strings.Builder
+	for {
+		for i := 0; i < len(raw); i++ {
+			c := raw[i]
+			switch {
+			case c == '"':
+				if err := p.checkTrailing(raw[i+1:]); err != nil {
+					return "", err
+				}
+				return b.String(), nil
+			case c == '$' && i+1 < len(raw) && raw[i+1] == '{':
+				n, err := p.expand(&b, raw[i:])
+				if err != nil {
+					return "", err
+				}
+				i += n - 1
+			case c == '\\' && i+1 < len(raw):
+				i++
+				switch raw[i] {
+				case 'n':
+					b.WriteByte('\n')
+				case 't':
+					b.WriteByte('\t')
+				case 'r':
+					b.WriteByte('\r')
+				case '$', '"', '\\':
+					b.WriteByte(raw[i])
+				default:
+					b.WriteByte('\\')
+					b.WriteByte(raw[i])
+				}
+			default:
+				b.WriteByte(c)
+			}
+		}
+
+		if !p.scanner.Scan() {
+			return "", p.errorf("unterminated double quoted value")
+		}
+		p.line++
+		b.WriteByte('\n')
+		raw = p.scanner.Text()
+	}
+}
+
+func (p *parser) checkTrailing(rest string) error {
+	rest = strings.TrimSpace(rest)
+	if rest != "" && !strings.HasPrefix(rest, "#") {
+		return p.errorf("unexpected %q after closing quote", rest)
+	}
+	return nil
+}
+
+// interpolate replaces the ${VAR} references in an unquoted value.
+func (p *parser) interpolate(s string) (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '$' && i+1 < len(s) && s[i+1] == '{' {
+			n, err := p.expand(&b, s[i:])
+			if err != nil {
+				return "", err
+			}
+			i += n - 1
+			continue
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String(), nil
+}
+
+// expand writes the value of the ${VAR} reference s starts with to b and
+// returns the length of the reference.
+func (p *parser) expand(b *strings.Builder, s string) (int, error) {
+	end := strings.IndexByte(s, '}')
+	if end < 0 {
+		return 0, p.errorf("unterminated ${ in value")
+	}
+	name := s[2:end]
+	if !validKey(name) {
+		return 0, p.errorf("invalid variable name %q in ${}", name)
+	}
+	// Same precedence as Load: a variable set in the environment wins
+	if v := os.Getenv(name); v != "" {
+		b.WriteString(v)
+	} else {
+		b.WriteString(p.defined[name])
+	}
+	return end + 1, nil
+}
+
+func validKey(key string) bool {
+	if key == "" {
+		return false
+	}
+	for i, c := range key {
+		switch {
+		case c == '_', c >= 'A' && c <= 'Z', c >= 'a' && c <= 'z':
+		case i > 0 && (c >= '0' && c <= '9' || c == '.'):
+		default:
+			return false
+		}
+	}
+	return true
+}
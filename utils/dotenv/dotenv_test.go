@@ -0,0 +1,149 @@
+/**
+ * Copyright 2021 - Present Okta, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dotenv
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  map[string]string
+	}{
+		{"plain", "KEY=value", map[string]string{"KEY": "value"}},
+		{"spaces around", "  KEY = value  ", map[string]string{"KEY": "value"}},
+		{"empty", "KEY=", map[string]string{"KEY": ""}},
+		{"export", "export KEY=value", map[string]string{"KEY": "value"}},
+		{"export with a tab", "export\tKEY=value", map[string]string{"KEY": "value"}},
+		{"key starting with export", "EXPORTED=value", map[string]string{"EXPORTED": "value"}},
+		{"= in the value", "URL=https://example.com/?a=b&c=d", map[string]string{"URL": "https://example.com/?a=b&c=d"}},
+		{"blank lines", "\nA=1\n\n   \nB=2\n", map[string]string{"A": "1", "B": "2"}},
+		{"comment lines", "# comment\nA=1\n  # indented comment", map[string]string{"A": "1"}},
+		{"trailing comment", "KEY=value # comment", map[string]string{"KEY": "value"}},
+		{"trailing comment after a tab", "KEY=value\t# comment", map[string]string{"KEY": "value"}},
+		{"comment instead of a value", "KEY= # comment", map[string]string{"KEY": ""}},
+		{"# inside a value", "KEY=a#b", map[string]string{"KEY": "a#b"}},
+		{"# starting a value", "KEY=#value", map[string]string{"KEY": "#value"}},
+		{"single quoted", "KEY='a value # not a comment'", map[string]string{"KEY": "a value # not a comment"}},
+		{"single quoted is literal", `KEY='a\nb ${A}'`, map[string]string{"KEY": `a\nb ${A}`}},
+		{"single quoted with a comment", "KEY='value' # comment", map[string]string{"KEY": "value"}},
+		{"double quoted", `KEY="a value # not a comment"`, map[string]string{"KEY": "a value # not a comment"}},
+		{"double quoted escapes", `KEY="a\nb\tc\rd\"e\\f\$g\x"`, map[string]string{"KEY": "a\nb\tc\rd\"e\\f$g\\x"}},
+		{"double quoted over lines", "KEY=\"one\ntwo\"\nNEXT=3", map[string]string{"KEY": "one\ntwo", "NEXT": "3"}},
+		{"double quoted with a comment", `KEY="value" # comment`, map[string]string{"KEY": "value"}},
+		{"interpolation", "A=one\nB=${A}-two", map[string]string{"A": "one", "B": "one-two"}},
+		{"interpolation in double quotes", "A=one\nB=\"${A} two\"", map[string]string{"A": "one", "B": "one two"}},
+		{"escaped interpolation", "A=one\nB=\"\\${A}\"", map[string]string{"A": "one", "B": "${A}"}},
+		{"interpolation of an unset variable", "B=${DOTENV_TEST_UNSET}", map[string]string{"B": ""}},
+		{"later definitions win", "A=1\nA=2", map[string]string{"A": "2"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse(strings.NewReader(tt.input))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Parse() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		wantLine int
+	}{
+		{"no =", "A=1\nNOT A VARIABLE", 2},
+		{"invalid name", "1KEY=value", 1},
+		{"invalid name with a dash", "MY-KEY=value", 1},
+		{"unterminated single quote", "KEY='value", 1},
+		{"unterminated double quote", "KEY=\"value\nmore", 2},
+		{"text after the quote", `KEY="value" more`, 1},
+		{"unterminated ${", "KEY=${A", 1},
+		{"invalid ${} name", "KEY=${A-B}", 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse(strings.NewReader(tt.input))
+			var pe *ParseError
+			if !errors.As(err, &pe) {
+				t.Fatalf("Parse() error = %v, want a *ParseError", err)
+			}
+			if pe.Line != tt.wantLine {
+				t.Errorf("error on line %d, want %d: %s", pe.Line, tt.wantLine, pe)
+			}
+		})
+	}
+}
+
+func TestInterpolationPrefersEnvironment(t *testing.T) {
+	t.Setenv("DOTENV_TEST_A", "from-env")
+
+	got, err := Parse(strings.NewReader("DOTENV_TEST_A=from-file\nB=${DOTENV_TEST_A}"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got["B"] != "from-env" {
+		t.Errorf("B = %q, want the environment's value like Load keeps", got["B"])
+	}
+}
+
+func TestLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".env")
+	content := "DOTENV_TEST_SET=from-file\nDOTENV_TEST_EMPTY=from-file\nDOTENV_TEST_NEW=from-file\nDOTENV_TEST_REF=${DOTENV_TEST_SET}\n"
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("DOTENV_TEST_SET", "from-env")
+	t.Setenv("DOTENV_TEST_EMPTY", "")
+	t.Setenv("DOTENV_TEST_NEW", "")
+	os.Unsetenv("DOTENV_TEST_NEW")
+	t.Setenv("DOTENV_TEST_REF", "")
+
+	if err := Load(path); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{
+		"DOTENV_TEST_SET":   "from-env",
+		"DOTENV_TEST_EMPTY": "from-file",
+		"DOTENV_TEST_NEW":   "from-file",
+		"DOTENV_TEST_REF":   "from-env",
+	}
+	for key, value := range want {
+		if got := os.Getenv(key); got != value {
+			t.Errorf("%s = %q, want %q", key, got, value)
+		}
+	}
+}
+
+func TestLoadMissingFile(t *testing.T) {
+	err := Load(filepath.Join(t.TempDir(), ".env"))
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Load() = %v, want an error wrapping os.ErrNotExist", err)
+	}
+}
@@ -0,0 +1,10 @@
+module github.com/okta/samples-golang/utils
+
+go 1.17
+
+require gopkg.in/yaml.v3 v3.0.1
+
+require (
+	github.com/gorilla/securecookie v1.1.1
+	github.com/gorilla/sessions v1.2.1
+)
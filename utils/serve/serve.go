@@ -0,0 +1,174 @@
+/**
+ * Copyright 2021 - Present Okta, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package serve starts the HTTP servers of the samples, over TLS when asked
+// to, and shuts them down gracefully on SIGINT or SIGTERM.
+package serve
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// DefaultShutdownTimeout is how long in-flight requests get to finish once
+// a shutdown starts.
+const DefaultShutdownTimeout = 30 * time.Second
+
+// TLS says how, if at all, the server terminates TLS. Either CertFile and
+// KeyFile are set, or SelfSigned generates a certificate for local
+// development.
+type TLS struct {
+	CertFile   string
+	KeyFile    string
+	SelfSigned bool
+}
+
+// Enabled reports whether the server should serve HTTPS.
+func (t TLS) Enabled() bool {
+	return t.CertFile != "" || t.KeyFile != "" || t.SelfSigned
+}
+
+// Listen opens the listener srv will serve on, wrapped in TLS when t is
+// enabled. srv.Addr is where it listens.
+func Listen(srv *http.Server, t TLS) (net.Listener, error) {
+	var tlsConfig *tls.Config
+	switch {
+	case t.CertFile != "" || t.KeyFile != "":
+		if t.SelfSigned {
+			return nil, errors.New("a certificate can't be both given and self-signed")
+		}
+		cert, err := tls.LoadX509KeyPair(t.CertFile, t.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("could not load the TLS certificate: %w", err)
+		}
+		tlsConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+	case t.SelfSigned:
+		cert, err := SelfSignedCertificate(srv.Addr)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+	}
+
+	ln, err := net.Listen("tcp", srv.Addr)
+	if err != nil {
+		return nil, err
+	}
+	if tlsConfig != nil {
+		tlsConfig.MinVersion = tls.VersionTLS12
+		tlsConfig.NextProtos = []string{"h2", "http/1.1"}
+		srv.TLSConfig = tlsConfig
+		ln = tls.NewListener(ln, tlsConfig)
+	}
+	return ln, nil
+}
+
+// Serve serves srv on ln until the process gets SIGINT or SIGTERM, then
+// stops accepting connections and waits up to timeout for in-flight
+// requests to finish.
+func Serve(srv *http.Server, ln net.Listener, timeout time.Duration) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		// A second signal during the shutdown kills the process
+		<-ctx.Done()
+		stop()
+	}()
+	return ServeContext(ctx, srv, ln, timeout)
+}
+
+// ServeContext is Serve, shutting down when ctx is done instead of on a
+// signal.
+func ServeContext(ctx context.Context, srv *http.Server, ln net.Listener, timeout time.Duration) error {
+	errs := make(chan error, 1)
+	go func() {
+		errs <- srv.Serve(ln)
+	}()
+
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+	}
+
+	log.Print("shutting down, waiting for in-flight requests")
+	if timeout <= 0 {
+		timeout = DefaultShutdownTimeout
+	}
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		return fmt.Errorf("could not shut down gracefully: %w", err)
+	}
+	return nil
+}
+
+// SelfSignedCertificate generates a certificate, valid for a week, for
+// localhost and the host of addr. Browsers will warn about it, it is only
+// meant for development.
+func SelfSignedCertificate(addr string) (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{Organization: []string{"Okta Samples Development"}},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(7 * 24 * time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+	}
+	if host, _, err := net.SplitHostPort(addr); err == nil && host != "" && host != "localhost" {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("could not create a self-signed certificate: %w", err)
+	}
+	return tls.Certificate{
+		Certificate: [][]byte{der},
+		PrivateKey:  key,
+	}, nil
+}
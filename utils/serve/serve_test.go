@@ -0,0 +1,222 @@
+/**
+ * Copyright 2021 - Present Okta, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package serve
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestListen(t *testing.T) {
+	srv := &http.Server{Addr: "127.0.0.1:0"}
+	ln, err := Listen(srv, TLS{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	// Nothing serves yet, the port already takes connections
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("could not connect before serving: %v", err)
+	}
+	conn.Close()
+
+	if _, err := Listen(&http.Server{Addr: ln.Addr().String()}, TLS{}); err == nil {
+		t.Error("listening on a port in use succeeded")
+	}
+}
+
+func TestListenTLSErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		tls     TLS
+		wantErr string
+	}{
+		{name: "given and self-signed", tls: TLS{CertFile: "cert.pem", KeyFile: "key.pem", SelfSigned: true}, wantErr: "both given and self-signed"},
+		{name: "missing certificate", tls: TLS{CertFile: "nothing.pem", KeyFile: "nothing.pem"}, wantErr: "could not load the TLS certificate"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ln, err := Listen(&http.Server{Addr: "127.0.0.1:0"}, tt.tls)
+			if err == nil {
+				ln.Close()
+				t.Fatal("Listen() succeeded")
+			}
+			if !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Listen() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestListenSelfSigned(t *testing.T) {
+	srv := &http.Server{
+		Addr: "127.0.0.1:0",
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, "hello")
+		}),
+	}
+	ln, err := Listen(srv, TLS{SelfSigned: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Serve(ln)
+	defer srv.Close()
+
+	cert, err := x509.ParseCertificate(srv.TLSConfig.Certificates[0].Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(cert)
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots}}}
+
+	resp, err := client.Get("https://" + ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if string(body) != "hello" || resp.TLS == nil {
+		t.Errorf("answered %q, over TLS %v", body, resp.TLS != nil)
+	}
+}
+
+func TestSelfSignedCertificate(t *testing.T) {
+	tests := []struct {
+		addr     string
+		wantHost string
+	}{
+		{addr: ":8443", wantHost: "localhost"},
+		{addr: "localhost:8443", wantHost: "localhost"},
+		{addr: "samples.example.com:8443", wantHost: "samples.example.com"},
+		{addr: "10.0.0.1:8443", wantHost: "10.0.0.1"},
+	}
+	for _, tt := range tests {
+		cert, err := SelfSignedCertificate(tt.addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		leaf, err := x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, host := range []string{"localhost", "127.0.0.1", tt.wantHost} {
+			if err := leaf.VerifyHostname(host); err != nil {
+				t.Errorf("certificate for %q: %v", tt.addr, err)
+			}
+		}
+	}
+}
+
+// serveInBackground runs ServeContext with a handler that holds requests
+// until release is closed, and returns when ServeContext did.
+func serveInBackground(t *testing.T, ctx context.Context, timeout time.Duration, release chan struct{}) (string, chan struct{}, chan error) {
+	t.Helper()
+	started := make(chan struct{}, 1)
+	srv := &http.Server{
+		Addr: "127.0.0.1:0",
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			started <- struct{}{}
+			<-release
+			io.WriteString(w, "done")
+		}),
+	}
+	ln, err := Listen(srv, TLS{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	errs := make(chan error, 1)
+	go func() {
+		errs <- ServeContext(ctx, srv, ln, timeout)
+	}()
+	return "http://" + ln.Addr().String(), started, errs
+}
+
+func TestServeContextShutdown(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	release := make(chan struct{})
+	url, started, errs := serveInBackground(t, ctx, time.Minute, release)
+
+	answers := make(chan string, 1)
+	go func() {
+		resp, err := http.Get(url)
+		if err != nil {
+			answers <- err.Error()
+			return
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		answers <- string(body)
+	}()
+	<-started
+
+	cancel()
+	select {
+	case err := <-errs:
+		t.Fatalf("ServeContext() returned %v with a request in flight", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	if _, err := http.Get(url); err == nil {
+		t.Error("a new request was served after the shutdown started")
+	}
+
+	close(release)
+	if err := <-errs; err != nil {
+		t.Errorf("ServeContext() error = %v", err)
+	}
+	if answer := <-answers; answer != "done" {
+		t.Errorf("in-flight request got %q, want it to finish", answer)
+	}
+}
+
+func TestServeContextShutdownTimeout(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	release := make(chan struct{})
+	defer close(release)
+	url, started, errs := serveInBackground(t, ctx, 20*time.Millisecond, release)
+
+	go http.Get(url)
+	<-started
+
+	cancel()
+	if err := <-errs; !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("ServeContext() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestServeContextError(t *testing.T) {
+	srv := &http.Server{Addr: "127.0.0.1:0"}
+	ln, err := Listen(srv, TLS{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln.Close()
+
+	if err := ServeContext(context.Background(), srv, ln, 0); err == nil {
+		t.Error("ServeContext() on a closed listener succeeded")
+	}
+}
@@ -0,0 +1,114 @@
+/**
+ * Copyright 2021 - Present Okta, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package session
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// filePrefix starts the name of every session file.
+const filePrefix = "session_"
+
+// fileBackend keeps each session in its own file, so sessions survive
+// restarts. A file holds the expiry, in Unix seconds, followed by the
+// encoded session values.
+type fileBackend struct {
+	dir string
+	// mu serializes writes so a save never interleaves with another.
+	mu sync.Mutex
+}
+
+func newFileBackend(dir string) (*fileBackend, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("could not create the session directory: %w", err)
+	}
+	f := &fileBackend{dir: dir}
+	if err := f.purge(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *fileBackend) load(id string) ([]byte, error) {
+	b, err := os.ReadFile(f.path(id))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, errNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(b) < 8 {
+		return nil, fmt.Errorf("session file %s is corrupt", f.path(id))
+	}
+	expires := time.Unix(int64(binary.BigEndian.Uint64(b[:8])), 0)
+	if time.Now().After(expires) {
+		f.delete(id)
+		return nil, errNotFound
+	}
+	return b[8:], nil
+}
+
+func (f *fileBackend) save(id string, data []byte, expires time.Time) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	b := make([]byte, 8, 8+len(data))
+	binary.BigEndian.PutUint64(b, uint64(expires.Unix()))
+	b = append(b, data...)
+
+	// Write to a temporary file first so readers never see half a session.
+	tmp := f.path(id) + ".tmp"
+	if err := os.WriteFile(tmp, b, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, f.path(id))
+}
+
+func (f *fileBackend) delete(id string) error {
+	err := os.Remove(f.path(id))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// purge removes the files of sessions that expired while the server was
+// down, load removes the others as it comes across them.
+func (f *fileBackend) purge() error {
+	entries, err := os.ReadDir(f.dir)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if id := strings.TrimPrefix(e.Name(), filePrefix); id != e.Name() && !strings.HasSuffix(id, ".tmp") {
+			f.load(id)
+		}
+	}
+	return nil
+}
+
+func (f *fileBackend) path(id string) string {
+	return filepath.Join(f.dir, filePrefix+id)
+}
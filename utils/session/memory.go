@@ -0,0 +1,85 @@
+/**
+ * Copyright 2021 - Present Okta, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package session
+
+import (
+	"sync"
+	"time"
+)
+
+// memoryBackend keeps sessions in memory, they are lost on restart and not
+// shared between instances.
+type memoryBackend struct {
+	mu       sync.Mutex
+	sessions map[string]memorySession
+	// lastPurge is when expired sessions were last dropped.
+	lastPurge time.Time
+}
+
+type memorySession struct {
+	data    []byte
+	expires time.Time
+}
+
+func newMemoryBackend() *memoryBackend {
+	return &memoryBackend{
+		sessions:  make(map[string]memorySession),
+		lastPurge: time.Now(),
+	}
+}
+
+func (m *memoryBackend) load(id string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s, ok := m.sessions[id]
+	if !ok || time.Now().After(s.expires) {
+		return nil, errNotFound
+	}
+	return s.data, nil
+}
+
+func (m *memoryBackend) save(id string, data []byte, expires time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.sessions[id] = memorySession{data: data, expires: expires}
+	m.purge()
+	return nil
+}
+
+func (m *memoryBackend) delete(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.sessions, id)
+	return nil
+}
+
+// purge drops expired sessions, at most once a minute. m.mu must be held.
+func (m *memoryBackend) purge() {
+	now := time.Now()
+	if now.Sub(m.lastPurge) < time.Minute {
+		return
+	}
+	for id, s := range m.sessions {
+		if now.After(s.expires) {
+			delete(m.sessions, id)
+		}
+	}
+	m.lastPurge = now
+}
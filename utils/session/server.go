@@ -0,0 +1,146 @@
+/**
+ * Copyright 2021 - Present Okta, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package session
+
+import (
+	"bytes"
+	"encoding/base32"
+	"encoding/gob"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/securecookie"
+	"github.com/gorilla/sessions"
+)
+
+// errNotFound is returned by backends for unknown or expired session IDs.
+var errNotFound = errors.New("session not found")
+
+// backend keeps the encoded values of server-side sessions.
+type backend interface {
+	load(id string) ([]byte, error)
+	save(id string, data []byte, expires time.Time) error
+	delete(id string) error
+}
+
+// serverStore keeps session values in a backend, the cookie only holds the
+// signed and encrypted session ID.
+type serverStore struct {
+	backend backend
+	codecs  []securecookie.Codec
+	options *sessions.Options
+	// idleTimeout lets the backend forget sessions before the cookie
+	// expires.
+	idleTimeout time.Duration
+}
+
+func newServerStore(b backend, options *sessions.Options, keys [][]byte, idleTimeout time.Duration) *serverStore {
+	codecs := securecookie.CodecsFromPairs(keys...)
+	for _, codec := range codecs {
+		if sc, ok := codec.(*securecookie.SecureCookie); ok {
+			sc.MaxAge(options.MaxAge)
+		}
+	}
+	return &serverStore{
+		backend:     b,
+		codecs:      codecs,
+		options:     options,
+		idleTimeout: idleTimeout,
+	}
+}
+
+func (s *serverStore) Get(r *http.Request, name string) (*sessions.Session, error) {
+	return sessions.GetRegistry(r).Get(s, name)
+}
+
+func (s *serverStore) New(r *http.Request, name string) (*sessions.Session, error) {
+	session := sessions.NewSession(s, name)
+	opts := *s.options
+	session.Options = &opts
+	session.IsNew = true
+
+	c, err := r.Cookie(name)
+	if err != nil {
+		return session, nil
+	}
+	var id string
+	if err := securecookie.DecodeMulti(name, c.Value, &id, s.codecs...); err != nil {
+		return session, err
+	}
+	data, err := s.backend.load(id)
+	if errors.Is(err, errNotFound) {
+		// The session expired or the server restarted, start over.
+		return session, nil
+	}
+	if err != nil {
+		return session, err
+	}
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&session.Values); err != nil {
+		return session, err
+	}
+	session.ID = id
+	session.IsNew = false
+	return session, nil
+}
+
+func (s *serverStore) Save(r *http.Request, w http.ResponseWriter, session *sessions.Session) error {
+	if session.Options.MaxAge < 0 {
+		if session.ID != "" {
+			if err := s.backend.delete(session.ID); err != nil {
+				return err
+			}
+		}
+		http.SetCookie(w, sessions.NewCookie(session.Name(), "", session.Options))
+		return nil
+	}
+
+	if session.ID == "" {
+		session.ID = strings.TrimRight(
+			base32.StdEncoding.EncodeToString(securecookie.GenerateRandomKey(32)), "=")
+	}
+
+	var data bytes.Buffer
+	if err := gob.NewEncoder(&data).Encode(session.Values); err != nil {
+		return err
+	}
+	if err := s.backend.save(session.ID, data.Bytes(), s.expiry(session)); err != nil {
+		return err
+	}
+
+	encoded, err := securecookie.EncodeMulti(session.Name(), session.ID, s.codecs...)
+	if err != nil {
+		return err
+	}
+	http.SetCookie(w, sessions.NewCookie(session.Name(), encoded, session.Options))
+	return nil
+}
+
+// expiry is when the backend may forget the session: when the cookie or the
+// idle timeout expires, whichever comes first. Sessions that have neither
+// are kept for a day.
+func (s *serverStore) expiry(session *sessions.Session) time.Time {
+	ttl := 24 * time.Hour
+	if session.Options.MaxAge > 0 {
+		ttl = time.Duration(session.Options.MaxAge) * time.Second
+	}
+	if s.idleTimeout > 0 && s.idleTimeout < ttl {
+		ttl = s.idleTimeout
+	}
+	return time.Now().Add(ttl)
+}
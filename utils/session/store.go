@@ -0,0 +1,204 @@
+/**
+ * Copyright 2021 - Present Okta, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package session provides the session stores of the custom-login and
+// okta-hosted-login samples.
+//
+// Session values are either kept in an encrypted cookie or on the server,
+// in memory or in files, with only the session ID in the cookie. Whatever
+// the backend, sessions end after an idle and an absolute timeout.
+package session
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/securecookie"
+	"github.com/gorilla/sessions"
+
+	"github.com/okta/samples-golang/utils/config"
+)
+
+const (
+	// createdKey and lastSeenKey are the session values, in Unix seconds,
+	// the timeouts are checked against.
+	createdKey  = "_session_created"
+	lastSeenKey = "_session_last_seen"
+
+	// touchInterval is how stale the last seen time may get before Touch
+	// saves the session again.
+	touchInterval = time.Minute
+)
+
+// Store is a sessions.Store that ends sessions after the configured idle
+// and absolute timeouts.
+type Store struct {
+	backend         sessions.Store
+	idleTimeout     time.Duration
+	absoluteTimeout time.Duration
+}
+
+// NewStore builds the session store described by c.
+func NewStore(c *config.Config) (*Store, error) {
+	// A random secret would sign everybody out on every restart
+	if c.SessionSecret == "" {
+		return nil, errors.New("the session store needs a SESSION_SECRET")
+	}
+	secrets := append([]string{c.SessionSecret}, c.Session.PreviousSecrets...)
+
+	options := &sessions.Options{
+		Path:     c.Cookie.Path,
+		Domain:   c.Cookie.Domain,
+		MaxAge:   c.Cookie.MaxAge,
+		Secure:   c.Cookie.Secure,
+		HttpOnly: true,
+		SameSite: c.Cookie.SameSiteMode(),
+	}
+	keys := keyPairs(secrets)
+
+	var backend sessions.Store
+	switch c.Session.Store {
+	case "", "cookie":
+		store := sessions.NewCookieStore(keys...)
+		store.Options = options
+		store.MaxAge(options.MaxAge)
+		backend = store
+	case "memory":
+		backend = newServerStore(newMemoryBackend(), options, keys, c.Session.IdleTimeout)
+	case "file":
+		files, err := newFileBackend(c.Session.Dir)
+		if err != nil {
+			return nil, err
+		}
+		backend = newServerStore(files, options, keys, c.Session.IdleTimeout)
+	default:
+		return nil, fmt.Errorf("unknown session store %q", c.Session.Store)
+	}
+
+	return &Store{
+		backend:         backend,
+		idleTimeout:     c.Session.IdleTimeout,
+		absoluteTimeout: c.Session.AbsoluteTimeout,
+	}, nil
+}
+
+// keyPairs derives an authentication and an encryption key from each
+// secret. The first secret protects new cookies, the others are only used
+// to read cookies issued before a rotation.
+func keyPairs(secrets []string) [][]byte {
+	var keys [][]byte
+	for _, secret := range secrets {
+		hashKey := sha256.Sum256([]byte("session-hash-key:" + secret))
+		blockKey := sha256.Sum256([]byte("session-block-key:" + secret))
+		keys = append(keys, hashKey[:], blockKey[:])
+	}
+	return keys
+}
+
+// Get returns the named session of the request, a new one if it is
+// missing or has timed out.
+func (s *Store) Get(r *http.Request, name string) (*sessions.Session, error) {
+	return sessions.GetRegistry(r).Get(s, name)
+}
+
+func (s *Store) New(r *http.Request, name string) (*sessions.Session, error) {
+	loaded, err := s.backend.New(r, name)
+	if loaded == nil {
+		return nil, err
+	}
+	// A cookie that can't be decoded was protected by a secret that is no
+	// longer configured, or was tampered with: its owner starts over.
+	var cookieErr securecookie.Error
+	if errors.As(err, &cookieErr) && cookieErr.IsDecode() {
+		err = nil
+	}
+
+	// The session is rebuilt around s so that session.Save goes through
+	// Save and records the last seen time.
+	session := sessions.NewSession(s, name)
+	session.ID = loaded.ID
+	session.Values = loaded.Values
+	session.Options = loaded.Options
+	session.IsNew = loaded.IsNew
+
+	// Other errors mean the session couldn't be loaded, the caller gets a
+	// new session along with the error.
+	if err == nil && !session.IsNew && s.expired(session, time.Now()) {
+		if server, ok := s.backend.(*serverStore); ok {
+			if err := server.backend.delete(session.ID); err != nil {
+				log.Printf("could not delete expired session: %s", err)
+			}
+		}
+		session.ID = ""
+		session.Values = make(map[interface{}]interface{})
+		session.IsNew = true
+	}
+	if session.IsNew {
+		session.Values[createdKey] = time.Now().Unix()
+	}
+	return session, err
+}
+
+func (s *Store) Save(r *http.Request, w http.ResponseWriter, session *sessions.Session) error {
+	if session.Options.MaxAge >= 0 {
+		session.Values[lastSeenKey] = time.Now().Unix()
+	}
+	return s.backend.Save(r, w, session)
+}
+
+// Renew gives the session a new ID and starts its absolute timeout over,
+// then saves it. Call it when the user signs in, so that a session ID planted
+// in the browser before the login doesn't carry the signed in session.
+func (s *Store) Renew(r *http.Request, w http.ResponseWriter, session *sessions.Session) error {
+	if server, ok := s.backend.(*serverStore); ok && session.ID != "" {
+		if err := server.backend.delete(session.ID); err != nil {
+			return err
+		}
+	}
+	session.ID = ""
+	session.IsNew = true
+	session.Values[createdKey] = time.Now().Unix()
+	return s.Save(r, w, session)
+}
+
+// Touch saves the session when it was last saved more than a minute ago,
+// so the idle timeout only ends sessions nobody is using.
+func (s *Store) Touch(r *http.Request, w http.ResponseWriter, session *sessions.Session) error {
+	if s.idleTimeout == 0 || session.IsNew {
+		return nil
+	}
+	lastSeen, _ := session.Values[lastSeenKey].(int64)
+	if time.Since(time.Unix(lastSeen, 0)) < touchInterval {
+		return nil
+	}
+	return s.Save(r, w, session)
+}
+
+func (s *Store) expired(session *sessions.Session, now time.Time) bool {
+	created, _ := session.Values[createdKey].(int64)
+	lastSeen, _ := session.Values[lastSeenKey].(int64)
+	if s.absoluteTimeout > 0 && now.Sub(time.Unix(created, 0)) > s.absoluteTimeout {
+		return true
+	}
+	if s.idleTimeout > 0 && now.Sub(time.Unix(lastSeen, 0)) > s.idleTimeout {
+		return true
+	}
+	return false
+}
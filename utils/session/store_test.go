@@ -0,0 +1,385 @@
+/**
+ * Copyright 2021 - Present Okta, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package session
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/sessions"
+
+	"github.com/okta/samples-golang/utils/config"
+)
+
+const (
+	testSecret      = "0123456789abcdef0123456789abcdef"
+	otherTestSecret = "fedcba9876543210fedcba9876543210"
+	cookieName      = "okta-hosted-login-session-store"
+)
+
+func testConfig(t *testing.T, store string) *config.Config {
+	c := &config.Config{SessionSecret: testSecret}
+	c.Session.Store = store
+	c.Session.Dir = t.TempDir()
+	c.Cookie.Path = "/"
+	return c
+}
+
+func newTestStore(t *testing.T, c *config.Config) *Store {
+	t.Helper()
+	s, err := NewStore(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// request returns a request carrying the cookies set on w.
+func request(w *httptest.ResponseRecorder) *http.Request {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	if w != nil {
+		for _, c := range w.Result().Cookies() {
+			r.AddCookie(c)
+		}
+	}
+	return r
+}
+
+// save stores values in a new session and returns the response setting its
+// cookie.
+func save(t *testing.T, s *Store, values map[interface{}]interface{}) *httptest.ResponseRecorder {
+	t.Helper()
+	session, err := s.Get(request(nil), cookieName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k, v := range values {
+		session.Values[k] = v
+	}
+	w := httptest.NewRecorder()
+	if err := session.Save(request(nil), w); err != nil {
+		t.Fatal(err)
+	}
+	return w
+}
+
+func load(t *testing.T, s *Store, w *httptest.ResponseRecorder) *sessions.Session {
+	t.Helper()
+	session, err := s.Get(request(w), cookieName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return session
+}
+
+func TestStores(t *testing.T) {
+	for _, store := range []string{"", "cookie", "memory", "file"} {
+		t.Run(store, func(t *testing.T) {
+			s := newTestStore(t, testConfig(t, store))
+
+			w := save(t, s, map[interface{}]interface{}{"id_token": "abc"})
+			session := load(t, s, w)
+			if session.IsNew || session.Values["id_token"] != "abc" {
+				t.Fatalf("loaded session IsNew=%v values=%v, want the saved one", session.IsNew, session.Values)
+			}
+
+			// Logging out deletes the session
+			session.Options.MaxAge = -1
+			logout := httptest.NewRecorder()
+			if err := session.Save(request(w), logout); err != nil {
+				t.Fatal(err)
+			}
+			if c := logout.Result().Cookies(); len(c) != 1 || c[0].MaxAge >= 0 {
+				t.Errorf("logout cookies = %v, want the cookie removed", c)
+			}
+			if store == "memory" || store == "file" {
+				// The old cookie no longer finds its values
+				if session := load(t, s, w); !session.IsNew {
+					t.Error("the session survived logout")
+				}
+			}
+		})
+	}
+}
+
+func TestServerStoreKeepsValuesOutOfTheCookie(t *testing.T) {
+	s := newTestStore(t, testConfig(t, "memory"))
+	w := save(t, s, map[interface{}]interface{}{"id_token": strings.Repeat("x", 4096)})
+
+	if c := w.Result().Cookies(); len(c) != 1 || len(c[0].Value) > 512 {
+		t.Errorf("cookie is %d bytes, want only the session ID", len(c[0].Value))
+	}
+}
+
+func TestTamperedCookie(t *testing.T) {
+	for _, store := range []string{"cookie", "memory"} {
+		t.Run(store, func(t *testing.T) {
+			s := newTestStore(t, testConfig(t, store))
+			w := save(t, s, map[interface{}]interface{}{"id_token": "abc"})
+
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			c := w.Result().Cookies()[0]
+			c.Value = "x" + c.Value[1:]
+			r.AddCookie(c)
+			session, err := s.Get(r, cookieName)
+			if err != nil {
+				t.Fatalf("Get() = %v, want a new session", err)
+			}
+			if !session.IsNew || session.Values["id_token"] != nil {
+				t.Error("a tampered cookie didn't get a new session")
+			}
+		})
+	}
+}
+
+// TestCookieOfAnOldKey is a restart with another SESSION_SECRET: the old
+// cookies start new sessions rather than failing every request.
+func TestCookieOfAnOldKey(t *testing.T) {
+	for _, store := range []string{"cookie", "memory", "file"} {
+		t.Run(store, func(t *testing.T) {
+			c := testConfig(t, store)
+			w := save(t, newTestStore(t, c), map[interface{}]interface{}{"id_token": "abc"})
+
+			c.SessionSecret = otherTestSecret
+			restarted := newTestStore(t, c)
+			session, err := restarted.Get(request(w), cookieName)
+			if err != nil {
+				t.Fatalf("Get() = %v, want a new session", err)
+			}
+			if !session.IsNew || session.Values["id_token"] != nil {
+				t.Errorf("old cookie loaded IsNew=%v values=%v, want a new session", session.IsNew, session.Values)
+			}
+			if err := session.Save(request(w), httptest.NewRecorder()); err != nil {
+				t.Errorf("the new session can't be saved: %s", err)
+			}
+		})
+	}
+}
+
+func TestNewStoreNeedsASecret(t *testing.T) {
+	c := testConfig(t, "memory")
+	c.SessionSecret = ""
+	if _, err := NewStore(c); err == nil {
+		t.Error("NewStore() accepted an empty SESSION_SECRET")
+	}
+}
+
+func TestRenew(t *testing.T) {
+	for _, store := range []string{"cookie", "memory", "file"} {
+		t.Run(store, func(t *testing.T) {
+			c := testConfig(t, store)
+			c.Session.AbsoluteTimeout = time.Hour
+			s := newTestStore(t, c)
+			// A session planted before the login
+			planted := save(t, s, map[interface{}]interface{}{
+				createdKey: time.Now().Add(-50 * time.Minute).Unix(),
+			})
+
+			session := load(t, s, planted)
+			oldID := session.ID
+			session.Values["id_token"] = "abc"
+			w := httptest.NewRecorder()
+			if err := s.Renew(request(planted), w, session); err != nil {
+				t.Fatal(err)
+			}
+
+			renewed := load(t, s, w)
+			if renewed.Values["id_token"] != "abc" {
+				t.Fatalf("renewed session has %v, want the login's values", renewed.Values)
+			}
+			if created, _ := renewed.Values[createdKey].(int64); time.Since(time.Unix(created, 0)) > time.Minute {
+				t.Error("the absolute timeout didn't start over")
+			}
+			if store == "cookie" {
+				return
+			}
+			if renewed.ID == oldID {
+				t.Error("the session kept its ID")
+			}
+			if old := load(t, s, planted); !old.IsNew || old.Values["id_token"] != nil {
+				t.Error("the planted cookie reaches the signed in session")
+			}
+		})
+	}
+}
+
+func TestSecretRotation(t *testing.T) {
+	for _, store := range []string{"cookie", "memory"} {
+		t.Run(store, func(t *testing.T) {
+			c := testConfig(t, store)
+			old := newTestStore(t, c)
+			w := save(t, old, map[interface{}]interface{}{"id_token": "abc"})
+
+			c.SessionSecret = otherTestSecret
+			c.Session.PreviousSecrets = []string{testSecret}
+			rotated := newTestStore(t, c)
+			if store == "memory" {
+				// The sessions are still in the old store's memory
+				rotated.backend.(*serverStore).backend = old.backend.(*serverStore).backend
+			}
+			if session := load(t, rotated, w); session.Values["id_token"] != "abc" {
+				t.Error("a cookie of the previous secret was rejected")
+			}
+
+			c.Session.PreviousSecrets = nil
+			dropped := newTestStore(t, c)
+			if store == "memory" {
+				dropped.backend.(*serverStore).backend = old.backend.(*serverStore).backend
+			}
+			if session := load(t, dropped, w); !session.IsNew {
+				t.Error("a cookie of a dropped secret was accepted")
+			}
+		})
+	}
+}
+
+func TestTimeouts(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name     string
+		idle     time.Duration
+		absolute time.Duration
+		created  time.Time
+		lastSeen time.Time
+		want     bool
+	}{
+		{"no timeouts", 0, 0, now.Add(-48 * time.Hour), now.Add(-48 * time.Hour), false},
+		{"recently used", time.Hour, 0, now.Add(-48 * time.Hour), now.Add(-time.Minute), false},
+		{"idle", time.Hour, 0, now.Add(-2 * time.Hour), now.Add(-2 * time.Hour), true},
+		{"within absolute", 0, 8 * time.Hour, now.Add(-time.Hour), now, false},
+		{"absolute", 0, 8 * time.Hour, now.Add(-9 * time.Hour), now, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Store{idleTimeout: tt.idle, absoluteTimeout: tt.absolute}
+			session := sessions.NewSession(s, cookieName)
+			session.Values[createdKey] = tt.created.Unix()
+			session.Values[lastSeenKey] = tt.lastSeen.Unix()
+			if got := s.expired(session, now); got != tt.want {
+				t.Errorf("expired() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExpiredSessionStartsOver(t *testing.T) {
+	for _, store := range []string{"cookie", "file"} {
+		t.Run(store, func(t *testing.T) {
+			c := testConfig(t, store)
+			c.Session.AbsoluteTimeout = time.Hour
+			s := newTestStore(t, c)
+			w := save(t, s, map[interface{}]interface{}{
+				"id_token": "abc",
+				createdKey: time.Now().Add(-2 * time.Hour).Unix(),
+			})
+
+			session := load(t, s, w)
+			if !session.IsNew || session.Values["id_token"] != nil {
+				t.Errorf("expired session loaded with %v", session.Values)
+			}
+			if _, ok := session.Values[createdKey].(int64); !ok {
+				t.Error("the new session has no creation time")
+			}
+			if store == "file" {
+				entries, _ := os.ReadDir(c.Session.Dir)
+				if len(entries) != 0 {
+					t.Errorf("%d session files left, want the expired one removed", len(entries))
+				}
+			}
+		})
+	}
+}
+
+func TestTouch(t *testing.T) {
+	c := testConfig(t, "cookie")
+	c.Session.IdleTimeout = time.Hour
+	s := newTestStore(t, c)
+
+	fresh := load(t, s, save(t, s, nil))
+	w := httptest.NewRecorder()
+	if err := s.Touch(request(nil), w, fresh); err != nil {
+		t.Fatal(err)
+	}
+	if len(w.Result().Cookies()) != 0 {
+		t.Error("Touch() saved a session seen just now")
+	}
+
+	fresh.Values[lastSeenKey] = time.Now().Add(-10 * time.Minute).Unix()
+	w = httptest.NewRecorder()
+	if err := s.Touch(request(nil), w, fresh); err != nil {
+		t.Fatal(err)
+	}
+	if len(w.Result().Cookies()) != 1 {
+		t.Error("Touch() didn't save a stale session")
+	}
+}
+
+func TestBackendExpiry(t *testing.T) {
+	backends := map[string]func(t *testing.T) backend{
+		"memory": func(t *testing.T) backend { return newMemoryBackend() },
+		"file": func(t *testing.T) backend {
+			f, err := newFileBackend(t.TempDir())
+			if err != nil {
+				t.Fatal(err)
+			}
+			return f
+		},
+	}
+	for name, newBackend := range backends {
+		t.Run(name, func(t *testing.T) {
+			b := newBackend(t)
+			if err := b.save("live", []byte("data"), time.Now().Add(time.Hour)); err != nil {
+				t.Fatal(err)
+			}
+			if err := b.save("gone", []byte("data"), time.Now().Add(-time.Second)); err != nil {
+				t.Fatal(err)
+			}
+
+			if data, err := b.load("live"); err != nil || string(data) != "data" {
+				t.Errorf("load(live) = %q, %v", data, err)
+			}
+			if _, err := b.load("gone"); err != errNotFound {
+				t.Errorf("load(gone) = %v, want errNotFound", err)
+			}
+			if _, err := b.load("unknown"); err != errNotFound {
+				t.Errorf("load(unknown) = %v, want errNotFound", err)
+			}
+		})
+	}
+}
+
+func TestFileBackendPurgesOnStart(t *testing.T) {
+	dir := t.TempDir()
+	f, err := newFileBackend(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.save("live", []byte("data"), time.Now().Add(time.Hour))
+	f.save("gone", []byte("data"), time.Now().Add(-time.Second))
+
+	if _, err := newFileBackend(dir); err != nil {
+		t.Fatal(err)
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 || entries[0].Name() != filePrefix+"live" {
+		t.Errorf("files after restart = %v, want only the live session", entries)
+	}
+}